@@ -0,0 +1,339 @@
+// Package agent runs a model in a loop: it thinks, calls tools, observes
+// their results and repeats until it has an answer or reaches its step
+// limit. Every step is recorded as a run in a db.RunStore.
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultMaxSteps = 10
+
+// ErrStepLimit is returned when the model did not answer within the step
+// limit of the runner.
+var ErrStepLimit = errors.New("agent reached its step limit without an answer")
+
+// Mode decides how tools are offered to the model.
+type Mode int
+
+const (
+	// ModeAuto uses native tool calls when the provider supports them and
+	// the ReAct protocol otherwise.
+	ModeAuto Mode = iota
+	// ModeNative offers the tools through client.ToolLllmChatClient.
+	ModeNative
+	// ModeReAct describes the tools in the system context and reads the
+	// calls from the text of the answers, for providers without tools.
+	ModeReAct
+)
+
+// Tool is a function the agent can call.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments, they are validated
+	// against it before Run is called.
+	Parameters client.Schema
+	// Dangerous tools only run when Runner.Approve allows the call.
+	Dangerous bool
+	Run       func(arguments json.RawMessage) (string, error)
+}
+
+// NewTool creates a tool whose arguments are decoded into T, the schema of
+// the arguments is generated from T like for client.SendStructured.
+func NewTool[T any](name string, description string, run func(arguments T) (string, error)) (Tool, error) {
+	var zero T
+	schema, err := client.JSONSchema(zero)
+	if err != nil {
+		return Tool{}, fmt.Errorf("unable to generate schema of tool %s: %v", name, err)
+	}
+	return Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  schema,
+		Run: func(arguments json.RawMessage) (string, error) {
+			var decoded T
+			if err := json.Unmarshal(arguments, &decoded); err != nil {
+				return "", err
+			}
+			return run(decoded)
+		},
+	}, nil
+}
+
+// Runner runs agents on top of a client.Client: the goal and the final
+// answer are stored in the context like any other turn, the history and
+// system context of the client are sent with the goal.
+type Runner struct {
+	Client *client.Client
+	Tools  []Tool
+	// MaxSteps is the most requests to the model per run, DefaultMaxSteps
+	// when zero.
+	MaxSteps int
+	Mode     Mode
+	// Approve decides whether a dangerous tool may be called. Without it
+	// every call of a dangerous tool is rejected. An error stops the run.
+	Approve func(runId string, call db.ToolCall) (bool, error)
+	// OnStep is called with every step when it is recorded.
+	OnStep func(step db.AgentStep)
+	// Runs keeps the traces of the runs. The store of Client is used when it
+	// is nil and implements db.RunStore, runs are not kept otherwise.
+	Runs   db.RunStore
+	Logger *logrus.Logger
+}
+
+func NewRunner(c *client.Client, tools ...Tool) *Runner {
+	return &Runner{
+		Client: c,
+		Tools:  tools,
+		Logger: c.Logger,
+	}
+}
+
+// Result is the outcome of a run, Steps are the recorded steps.
+type Result struct {
+	RunId  string
+	Answer string
+	Steps  []db.AgentStep
+}
+
+// Run works on goal in a context until the model answers. The result holds
+// the steps taken so far also when an error is returned.
+func (r *Runner) Run(goal string, contextId string) (*Result, error) {
+	a, err := r.newRun()
+	if err != nil {
+		return nil, err
+	}
+	answer, err := r.Client.SendTurn(goal, contextId, a.loop)
+	a.result.Answer = answer
+	if finishErr := a.finish(err); finishErr != nil && err == nil {
+		err = finishErr
+	}
+	return a.result, err
+}
+
+func (r *Runner) newRun() (*run, error) {
+	tools := make(map[string]Tool, len(r.Tools))
+	for _, tool := range r.Tools {
+		if tool.Name == "" || tool.Run == nil {
+			return nil, errors.New("tools need a name and a Run function")
+		}
+		if _, ok := tools[tool.Name]; ok {
+			return nil, fmt.Errorf("tool %s is defined twice", tool.Name)
+		}
+		tools[tool.Name] = tool
+	}
+	return &run{
+		runner: r,
+		tools:  tools,
+		store:  r.runStore(),
+		result: &Result{RunId: uuid.New().String(), Steps: []db.AgentStep{}},
+	}, nil
+}
+
+func (r *Runner) runStore() db.RunStore {
+	if r.Runs != nil {
+		return r.Runs
+	}
+	store := r.Client.Store
+	if store == nil {
+		store, _ = db.DefaultStore()
+	}
+	if runs, ok := store.(db.RunStore); ok {
+		return runs
+	}
+	return nil
+}
+
+func (r *Runner) maxSteps() int {
+	if r.MaxSteps <= 0 {
+		return DefaultMaxSteps
+	}
+	return r.MaxSteps
+}
+
+// run is the state of one Run.
+type run struct {
+	runner  *Runner
+	tools   map[string]Tool
+	store   db.RunStore
+	result  *Result
+	created bool
+}
+
+// loop is the send function of the turn of the run, it returns the goal
+// and the final answer.
+func (a *run) loop(messages []db.Message, context []string) ([]db.Message, error) {
+	goal := messages[len(messages)-1]
+	if a.store != nil {
+		if err := a.store.CreateRun(db.AgentRun{ID: a.result.RunId, ContextId: goal.ContextId, Goal: goal.Content}); err != nil {
+			return nil, fmt.Errorf("unable to store run: %v", err)
+		}
+		a.created = true
+	}
+	provider := a.runner.Client.Client
+	native := client.SupportsTools(provider)
+	switch a.runner.Mode {
+	case ModeNative:
+		if !native {
+			return nil, fmt.Errorf("%T does not support native tool calls", provider)
+		}
+	case ModeReAct:
+		native = false
+	}
+	if native {
+		return a.native(provider.(client.ToolLllmChatClient), messages, context)
+	}
+	return a.react(provider, messages, context)
+}
+
+func (a *run) native(provider client.ToolLllmChatClient, messages []db.Message, context []string) ([]db.Message, error) {
+	contextId := messages[len(messages)-1].ContextId
+	definitions := make([]client.Tool, len(a.runner.Tools))
+	for i, tool := range a.runner.Tools {
+		definitions[i] = client.Tool{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters}
+	}
+	conversation := messages[:len(messages):len(messages)]
+	for step := 0; step < a.runner.maxSteps(); step++ {
+		answers, err := provider.SendMessagesWithTools(conversation, context, definitions)
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) == 0 {
+			return nil, errors.New("model returned no messages")
+		}
+		answer := answers[len(answers)-1]
+		if len(answer.ToolCalls) == 0 {
+			if err := a.record(db.AgentStep{Type: db.StepAnswer, Content: answer.Content}); err != nil {
+				return nil, err
+			}
+			return append(messages, answer), nil
+		}
+		if strings.TrimSpace(answer.Content) != "" {
+			if err := a.record(db.AgentStep{Type: db.StepThought, Content: answer.Content}); err != nil {
+				return nil, err
+			}
+		}
+		conversation = append(conversation, answer)
+		for _, call := range answer.ToolCalls {
+			observation, err := a.call(call)
+			if err != nil {
+				return nil, err
+			}
+			result := db.CreateNewMessage(db.ToolRoleName, observation, contextId)
+			result.ToolCallId = call.ID
+			conversation = append(conversation, result)
+		}
+	}
+	return nil, ErrStepLimit
+}
+
+// call runs a tool and returns what the model observes. Unknown tools,
+// invalid arguments and failures of the tool are observations, the model
+// can correct them.
+func (a *run) call(call db.ToolCall) (string, error) {
+	if strings.TrimSpace(call.Arguments) == "" {
+		call.Arguments = "{}"
+	}
+	if err := a.record(db.AgentStep{Type: db.StepToolCall, Tool: call.Name, CallId: call.ID, Arguments: call.Arguments}); err != nil {
+		return "", err
+	}
+	tool, ok := a.tools[call.Name]
+	if !ok {
+		return a.observe(call, fmt.Sprintf("Error: there is no tool %s, the tools are %s.", call.Name, strings.Join(a.toolNames(), ", ")))
+	}
+	var arguments interface{}
+	if err := json.Unmarshal([]byte(call.Arguments), &arguments); err != nil {
+		return a.observe(call, fmt.Sprintf("Error: the arguments are not valid JSON: %v", err))
+	}
+	if tool.Parameters != nil {
+		if err := tool.Parameters.Validate(arguments); err != nil {
+			return a.observe(call, fmt.Sprintf("Error: invalid arguments: %v", err))
+		}
+	}
+	if tool.Dangerous {
+		approved := false
+		if a.runner.Approve != nil {
+			var err error
+			if approved, err = a.runner.Approve(a.result.RunId, call); err != nil {
+				return "", fmt.Errorf("unable to approve %s: %v", call.Name, err)
+			}
+		}
+		if !approved {
+			rejection := fmt.Sprintf("The call of %s was not approved, do not try it again with the same arguments.", call.Name)
+			if err := a.record(db.AgentStep{Type: db.StepRejected, Tool: call.Name, CallId: call.ID, Content: rejection}); err != nil {
+				return "", err
+			}
+			return rejection, nil
+		}
+	}
+	output, err := tool.Run(json.RawMessage(call.Arguments))
+	if err != nil {
+		output = fmt.Sprintf("Error: %v", err)
+	}
+	return a.observe(call, output)
+}
+
+func (a *run) observe(call db.ToolCall, observation string) (string, error) {
+	return observation, a.record(db.AgentStep{Type: db.StepObservation, Tool: call.Name, CallId: call.ID, Content: observation})
+}
+
+func (a *run) toolNames() []string {
+	names := make([]string, 0, len(a.tools))
+	for name := range a.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *run) record(step db.AgentStep) error {
+	step.RunId = a.result.RunId
+	step.Seq = len(a.result.Steps) + 1
+	step.CreatedAt = time.Now()
+	a.result.Steps = append(a.result.Steps, step)
+	if a.runner.Logger != nil {
+		a.runner.Logger.WithFields(logrus.Fields{
+			"runId": step.RunId,
+			"seq":   step.Seq,
+			"type":  step.Type,
+			"tool":  step.Tool,
+		}).Debug("Agent step")
+	}
+	if a.runner.OnStep != nil {
+		a.runner.OnStep(step)
+	}
+	if a.store != nil && a.created {
+		if err := a.store.AddRunStep(step); err != nil {
+			return fmt.Errorf("unable to store step %d of run %s: %v", step.Seq, step.RunId, err)
+		}
+	}
+	return nil
+}
+
+// finish records how the run ended.
+func (a *run) finish(err error) error {
+	if a.store == nil || !a.created {
+		return nil
+	}
+	status := db.RunStatusCompleted
+	errorMessage := ""
+	if err != nil {
+		status = db.RunStatusFailed
+		if errors.Is(err, ErrStepLimit) {
+			status = db.RunStatusStepLimit
+		}
+		errorMessage = err.Error()
+	}
+	return a.store.FinishRun(a.result.RunId, status, a.result.Answer, errorMessage)
+}
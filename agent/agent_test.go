@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedLlm answers with the next of its answers and records the
+// requests.
+type scriptedLlm struct {
+	answers  []db.Message
+	requests [][]db.Message
+	contexts [][]string
+}
+
+func (s *scriptedLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	s.requests = append(s.requests, messages)
+	s.contexts = append(s.contexts, context)
+	if len(s.answers) == 0 {
+		return nil, errors.New("no more answers")
+	}
+	answer := s.answers[0]
+	s.answers = s.answers[1:]
+	answer.ContextId = messages[0].ContextId
+	return append(messages, answer), nil
+}
+
+// toolLlm is a scriptedLlm with native tool calls.
+type toolLlm struct {
+	scriptedLlm
+	tools []client.Tool
+}
+
+func (t *toolLlm) SendMessagesWithTools(messages []db.Message, context []string, tools []client.Tool) ([]db.Message, error) {
+	t.tools = tools
+	return t.SendMessages(messages, context)
+}
+
+func text(content string) db.Message {
+	return db.CreateNewMessage(db.AssistentRoleNeam, content, "")
+}
+
+func toolCalls(content string, calls ...db.ToolCall) db.Message {
+	message := text(content)
+	message.ToolCalls = calls
+	return message
+}
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+type weatherArguments struct {
+	City string `json:"city"`
+}
+
+func weatherTool(t *testing.T) Tool {
+	tool, err := NewTool("weather", "Current weather of a city.", func(arguments weatherArguments) (string, error) {
+		if arguments.City == "Atlantis" {
+			return "", errors.New("unknown city")
+		}
+		return "sunny in " + arguments.City, nil
+	})
+	require.NoError(t, err)
+	return tool
+}
+
+func stepTypes(steps []db.AgentStep) []string {
+	types := make([]string, len(steps))
+	for i, step := range steps {
+		types[i] = step.Type
+	}
+	return types
+}
+
+func TestNativeRunCallsToolsAndStoresTrace(t *testing.T) {
+	store := newTestStore(t)
+	llm := &toolLlm{scriptedLlm: scriptedLlm{answers: []db.Message{
+		toolCalls("Let me check.",
+			db.ToolCall{ID: "1", Name: "weather", Arguments: `{"city":"Paris"}`},
+			db.ToolCall{ID: "2", Name: "weather", Arguments: `{"town":"Rome"}`}),
+		toolCalls("", db.ToolCall{ID: "3", Name: "weather", Arguments: `{"city":"Atlantis"}`}),
+		text("It is sunny in Paris."),
+	}}}
+	c := &client.Client{Client: llm, ContextDepth: 10, Store: store}
+	runner := NewRunner(c, weatherTool(t))
+
+	result, err := runner.Run("How is the weather in Paris?", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "It is sunny in Paris.", result.Answer)
+	assert.Equal(t, []string{db.StepThought, db.StepToolCall, db.StepObservation, db.StepToolCall, db.StepObservation,
+		db.StepToolCall, db.StepObservation, db.StepAnswer}, stepTypes(result.Steps))
+	assert.Equal(t, "sunny in Paris", result.Steps[2].Content)
+	assert.Equal(t, `Error: invalid arguments: $.city is required`, result.Steps[4].Content)
+	assert.Equal(t, "Error: unknown city", result.Steps[6].Content)
+
+	require.Len(t, llm.tools, 1)
+	assert.Equal(t, "weather", llm.tools[0].Name)
+	last := llm.requests[2]
+	require.Len(t, last, 6)
+	assert.Equal(t, db.ToolRoleName, last[2].Role)
+	assert.Equal(t, "1", last[2].ToolCallId)
+	assert.Equal(t, "sunny in Paris", last[2].Content)
+
+	run, err := store.GetRun(result.RunId)
+	require.NoError(t, err)
+	assert.Equal(t, db.RunStatusCompleted, run.Status)
+	assert.Equal(t, "ctx", run.ContextId)
+	assert.Equal(t, "How is the weather in Paris?", run.Goal)
+	steps, err := store.GetRunSteps(result.RunId)
+	require.NoError(t, err)
+	assert.Equal(t, stepTypes(result.Steps), stepTypes(steps))
+
+	// Only the goal and the answer are stored in the context.
+	messages, err := store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "It is sunny in Paris.", messages[1].Content)
+}
+
+func TestDangerousToolsNeedApproval(t *testing.T) {
+	deleted := make([]string, 0)
+	deleteTool, err := NewTool("delete_file", "Deletes a file.", func(arguments struct {
+		Path string `json:"path"`
+	}) (string, error) {
+		deleted = append(deleted, arguments.Path)
+		return "deleted", nil
+	})
+	require.NoError(t, err)
+	deleteTool.Dangerous = true
+
+	llm := &toolLlm{scriptedLlm: scriptedLlm{answers: []db.Message{
+		toolCalls("", db.ToolCall{ID: "1", Name: "delete_file", Arguments: `{"path":"/etc/passwd"}`}),
+		toolCalls("", db.ToolCall{ID: "2", Name: "delete_file", Arguments: `{"path":"/tmp/x"}`}),
+		text("Done."),
+	}}}
+	runner := NewRunner(&client.Client{Client: llm, ContextDepth: 10, Store: newTestStore(t)}, deleteTool)
+	approvals := make([]string, 0)
+	runner.Approve = func(runId string, call db.ToolCall) (bool, error) {
+		approvals = append(approvals, call.Arguments)
+		return !strings.Contains(call.Arguments, "/etc"), nil
+	}
+
+	result, err := runner.Run("Clean up", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/tmp/x"}, deleted)
+	assert.Equal(t, []string{`{"path":"/etc/passwd"}`, `{"path":"/tmp/x"}`}, approvals)
+	assert.Equal(t, []string{db.StepToolCall, db.StepRejected, db.StepToolCall, db.StepObservation, db.StepAnswer}, stepTypes(result.Steps))
+
+	runner.Approve = func(runId string, call db.ToolCall) (bool, error) {
+		return false, errors.New("nobody there")
+	}
+	llm.answers = []db.Message{toolCalls("", db.ToolCall{ID: "1", Name: "delete_file", Arguments: `{"path":"/tmp/y"}`})}
+	_, err = runner.Run("Clean up again", "ctx")
+	assert.EqualError(t, err, "unable to approve delete_file: nobody there")
+}
+
+func TestReActRun(t *testing.T) {
+	store := newTestStore(t)
+	llm := &scriptedLlm{answers: []db.Message{
+		text("Thought: I need the weather.\nAction: weather\nAction Input: ```json\n{\"city\": \"Paris\"}\n```\nObservation: rainy"),
+		text("Thought: I know it now.\nFinal Answer: It is sunny in Paris."),
+	}}
+	c := &client.Client{Client: llm, ContextDepth: 10, Store: store}
+	runner := NewRunner(c, weatherTool(t))
+
+	result, err := runner.Run("How is the weather in Paris?", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "It is sunny in Paris.", result.Answer)
+	assert.Equal(t, []string{db.StepThought, db.StepToolCall, db.StepObservation, db.StepThought, db.StepAnswer}, stepTypes(result.Steps))
+	assert.Equal(t, "I need the weather.", result.Steps[0].Content)
+	assert.Equal(t, `{"city": "Paris"}`, result.Steps[1].Arguments)
+
+	require.Len(t, llm.contexts[0], 1)
+	assert.Contains(t, llm.contexts[0][0], `weather: Current weather of a city. Arguments (JSON schema): {"additionalProperties":false`)
+	second := llm.requests[1]
+	require.Len(t, second, 3)
+	assert.NotContains(t, second[1].Content, "rainy")
+	assert.Equal(t, db.UserRoleName, second[2].Role)
+	assert.Equal(t, "Observation: sunny in Paris", second[2].Content)
+}
+
+func TestRunStopsAtStepLimit(t *testing.T) {
+	store := newTestStore(t)
+	answers := make([]db.Message, 0)
+	for i := 0; i < 3; i++ {
+		answers = append(answers, toolCalls("", db.ToolCall{ID: fmt.Sprint(i), Name: "weather", Arguments: `{"city":"Paris"}`}))
+	}
+	llm := &toolLlm{scriptedLlm: scriptedLlm{answers: answers}}
+	runner := NewRunner(&client.Client{Client: llm, ContextDepth: 10, Store: store}, weatherTool(t))
+	runner.MaxSteps = 2
+
+	result, err := runner.Run("Weather?", "ctx")
+	assert.ErrorIs(t, err, ErrStepLimit)
+	assert.Len(t, llm.requests, 2)
+	run, err := store.GetRun(result.RunId)
+	require.NoError(t, err)
+	assert.Equal(t, db.RunStatusStepLimit, run.Status)
+	assert.Equal(t, ErrStepLimit.Error(), run.Error)
+
+	runner.Mode = ModeNative
+	runner.Client.Client = &scriptedLlm{}
+	_, err = runner.Run("Weather?", "ctx")
+	assert.EqualError(t, err, "*agent.scriptedLlm does not support native tool calls")
+}
+
+func TestParseReAct(t *testing.T) {
+	assert.Equal(t, reactStep{thought: "look it up", action: "search", input: `{"q": "go"}`},
+		parseReAct("Thought: look it up\nAction: `search`\nAction Input: {\"q\": \"go\"}"))
+	assert.Equal(t, reactStep{action: "clock"}, parseReAct("Action: clock\n"))
+	assert.Equal(t, reactStep{thought: "easy", answer: "42"}, parseReAct("Thought: easy\nFinal Answer: 42"))
+	assert.Equal(t, reactStep{answer: "Just an answer."}, parseReAct("Just an answer."))
+}
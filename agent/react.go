@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// Labels of the ReAct protocol.
+const (
+	labelThought     = "Thought:"
+	labelAction      = "Action:"
+	labelActionInput = "Action Input:"
+	labelObservation = "Observation:"
+	labelFinalAnswer = "Final Answer:"
+)
+
+// react runs the loop with the tools described in the system context. The
+// model answers with a thought and either an action or the final answer,
+// observations are sent back as user messages so that providers which need
+// alternating turns accept the conversation.
+func (a *run) react(provider client.LllmChatClient, messages []db.Message, context []string) ([]db.Message, error) {
+	contextId := messages[len(messages)-1].ContextId
+	context = append(context[:len(context):len(context)], a.reactInstructions())
+	conversation := messages[:len(messages):len(messages)]
+	for step := 0; step < a.runner.maxSteps(); step++ {
+		answers, err := provider.SendMessages(conversation, context)
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) == 0 {
+			return nil, errors.New("model returned no messages")
+		}
+		answer := answers[len(answers)-1]
+		// Models sometimes go on and invent the observation themselves.
+		if i := strings.Index(answer.Content, labelObservation); i >= 0 {
+			answer.Content = strings.TrimSpace(answer.Content[:i])
+		}
+		parsed := parseReAct(answer.Content)
+		if parsed.thought != "" {
+			if err := a.record(db.AgentStep{Type: db.StepThought, Content: parsed.thought}); err != nil {
+				return nil, err
+			}
+		}
+		if parsed.action == "" {
+			answer.Content = parsed.answer
+			if err := a.record(db.AgentStep{Type: db.StepAnswer, Content: answer.Content}); err != nil {
+				return nil, err
+			}
+			return append(messages, answer), nil
+		}
+		conversation = append(conversation, answer)
+		observation, err := a.call(db.ToolCall{
+			ID:        fmt.Sprintf("call_%d", step+1),
+			Name:      parsed.action,
+			Arguments: parsed.input,
+		})
+		if err != nil {
+			return nil, err
+		}
+		conversation = append(conversation, db.CreateNewMessage(db.UserRoleName, labelObservation+" "+observation, contextId))
+	}
+	return nil, ErrStepLimit
+}
+
+func (a *run) reactInstructions() string {
+	var b strings.Builder
+	b.WriteString("You can use tools to answer. The tools are:\n")
+	for _, tool := range a.runner.Tools {
+		parameters := []byte("{}")
+		if tool.Parameters != nil {
+			parameters, _ = json.Marshal(tool.Parameters)
+		}
+		fmt.Fprintf(&b, "\n%s: %s Arguments (JSON schema): %s", tool.Name, tool.Description, parameters)
+	}
+	b.WriteString("\n\nTo use a tool, answer in this format and stop:\n" +
+		labelThought + " what you need to do next\n" +
+		labelAction + " the name of the tool\n" +
+		labelActionInput + " the arguments as JSON\n\n" +
+		"The result is sent to you as:\n" +
+		labelObservation + " the result of the tool\n\n" +
+		"When you know the answer, answer in this format:\n" +
+		labelThought + " why you know the answer\n" +
+		labelFinalAnswer + " the answer for the user")
+	return b.String()
+}
+
+type reactStep struct {
+	thought string
+	// action is empty when the model answered.
+	action string
+	input  string
+	answer string
+}
+
+// parseReAct reads a step of the ReAct protocol. An answer without an
+// action or final answer is taken as the final answer.
+func parseReAct(text string) reactStep {
+	if i := strings.Index(text, labelFinalAnswer); i >= 0 {
+		return reactStep{
+			thought: thought(text[:i]),
+			answer:  strings.TrimSpace(text[i+len(labelFinalAnswer):]),
+		}
+	}
+	action := strings.Index(text, labelAction)
+	if action < 0 {
+		return reactStep{answer: thought(text)}
+	}
+	step := reactStep{thought: thought(text[:action])}
+	rest := text[action+len(labelAction):]
+	if input := strings.Index(rest, labelActionInput); input >= 0 {
+		step.action = rest[:input]
+		step.input = unfence(rest[input+len(labelActionInput):])
+	} else {
+		step.action, _, _ = strings.Cut(strings.TrimSpace(rest), "\n")
+	}
+	step.action = strings.Trim(step.action, " \t\n\"'`[]")
+	return step
+}
+
+func thought(text string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), labelThought))
+}
+
+// unfence removes a Markdown code fence around the arguments.
+func unfence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if newline := strings.IndexByte(text, '\n'); newline >= 0 {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
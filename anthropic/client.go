@@ -0,0 +1,307 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/internal/sse"
+	"github.com/sirupsen/logrus"
+)
+
+const anthropicUserRole = "user"
+const anthropicAssistantRole = "assistant"
+
+type AnthropicClient struct {
+	ApiKey string
+	Model  *ClaudeModel
+	// MaxTokens limits the answer, capped at Model.MaxOutputTokens.
+	MaxTokens int
+	// BaseURL replaces https://api.anthropic.com, e.g. for a proxy or a test
+	// server.
+	BaseURL string
+	Logger  *logrus.Logger
+}
+
+func NewDefaultAnthropicClient(apiKey string, logger *logrus.Logger) *client.Client {
+	return NewAnthropicClient(apiKey, 8, ModelClaude35Sonnet, "", 4096, logger)
+}
+
+func NewDefaultAnthropicClientFromFile(apiKeyFilePath string, logger *logrus.Logger) (*client.Client, error) {
+	b, err := os.ReadFile(apiKeyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewDefaultAnthropicClient(strings.ReplaceAll(string(b), "\n", ""), logger), nil
+}
+
+func NewAnthropicClient(apiKey string, contextDepth int, model *ClaudeModel, defaultContext string, maxTokens int, logger *logrus.Logger) *client.Client {
+	return &client.Client{
+		Client: &AnthropicClient{
+			ApiKey:    apiKey,
+			Model:     model,
+			MaxTokens: maxTokens,
+			Logger:    logger,
+		},
+		ContextDepth:   contextDepth,
+		DefaultContext: defaultContext,
+		Logger:         logger,
+	}
+}
+
+// supportedOptions are the request options of the Messages API.
+var supportedOptions = []string{
+	client.OptionTemperature,
+	client.OptionTopP,
+	client.OptionTopK,
+	client.OptionMaxTokens,
+	client.OptionStop,
+	client.OptionUser,
+}
+
+func (c *AnthropicClient) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return c.SendMessagesWithOptions(messages, context, nil)
+}
+
+func (c *AnthropicClient) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, options)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response MessagesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	answer := &answerBuilder{}
+	answer.start(&response)
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			answer.text.WriteString(block.Text)
+		}
+	}
+	return answer.appendTo(messages, c.modelName())
+}
+
+// StreamMessages sends the request with "stream": true and calls onChunk with
+// each text delta of the answer.
+func (c *AnthropicClient) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	return c.StreamMessagesWithOptions(messages, context, nil, onChunk)
+}
+
+func (c *AnthropicClient) StreamMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions, onChunk func(chunk string) error) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, options)
+	if err != nil {
+		return nil, err
+	}
+	request.Stream = true
+	resp, err := c.post(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	answer := &answerBuilder{}
+	events := sse.NewReader(resp.Body)
+	for stopped := false; !stopped; {
+		event, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			// The connection was cut, the answer would be truncated.
+			return nil, &client.APIError{Provider: "Anthropic", StatusCode: resp.StatusCode, Message: "stream ended before message_stop", Retryable: true}
+		}
+		if err != nil {
+			return nil, err
+		}
+		var streamed streamEvent
+		if err := json.Unmarshal([]byte(event.Data), &streamed); err != nil {
+			return nil, fmt.Errorf("unable to parse Anthropic stream event: %v", err)
+		}
+		switch streamed.Type {
+		case "message_start":
+			answer.start(&streamed.Message)
+		case "content_block_delta":
+			if streamed.Delta.Type != "text_delta" || streamed.Delta.Text == "" {
+				continue
+			}
+			answer.text.WriteString(streamed.Delta.Text)
+			if err := onChunk(streamed.Delta.Text); err != nil {
+				return nil, err
+			}
+		case "message_delta":
+			if streamed.Delta.StopReason != "" {
+				answer.metadata.FinishReason = streamed.Delta.StopReason
+			}
+			if streamed.Usage.OutputTokens > 0 {
+				answer.metadata.CompletionTokens = streamed.Usage.OutputTokens
+			}
+		case "message_stop":
+			stopped = true
+		case "error":
+			return nil, fmt.Errorf("error event from Anthropic (%s): %s", streamed.Error.Type, streamed.Error.Message)
+		}
+	}
+	return answer.appendTo(messages, c.modelName())
+}
+
+// answerBuilder collects the answer of one response or of a stream, where
+// message_start carries the model and the prompt tokens and message_delta the
+// stop reason and the output tokens.
+type answerBuilder struct {
+	text     strings.Builder
+	metadata db.MessageMetadata
+	received bool
+}
+
+func (a *answerBuilder) start(response *MessagesResponse) {
+	a.received = true
+	a.metadata.Model = response.Model
+	a.metadata.FinishReason = response.StopReason
+	a.metadata.PromptTokens = response.Usage.InputTokens
+	a.metadata.CompletionTokens = response.Usage.OutputTokens
+}
+
+func (a *answerBuilder) appendTo(messages []db.Message, modelName string) ([]db.Message, error) {
+	if !a.received {
+		return nil, errors.New("no message in Anthropic response")
+	}
+	newMessage := db.CreateNewMessage(db.AssistentRoleNeam, a.text.String(), messages[0].ContextId)
+	newMessage.Metadata = a.metadata
+	newMessage.Metadata.Provider = ProviderName
+	newMessage.Metadata.TotalTokens = a.metadata.PromptTokens + a.metadata.CompletionTokens
+	if newMessage.Metadata.Model == "" {
+		newMessage.Metadata.Model = modelName
+	}
+	return append(messages, newMessage), nil
+}
+
+// buildRequest sends context and system messages as the top-level system
+// prompt. The Messages API wants alternating turns that start and end with
+// the user, so consecutive messages of one role are joined, leading
+// assistant messages are dropped and so are trailing ones, which the API
+// would take as the beginning of its own answer.
+func (c *AnthropicClient) buildRequest(messages []db.Message, context []string, options *client.RequestOptions) (*MessagesRequest, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("no messages to send")
+	}
+	if err := options.Check(ProviderName, c.Logger, supportedOptions...); err != nil {
+		return nil, err
+	}
+	system := append([]string{}, context...)
+	turns := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		role := anthropicUserRole
+		switch msg.Role {
+		case db.SystemRoleName:
+			system = append(system, msg.Content)
+			continue
+		case db.AssistentRoleNeam:
+			role = anthropicAssistantRole
+		}
+		if len(turns) == 0 && role != anthropicUserRole {
+			continue
+		}
+		if len(turns) > 0 && turns[len(turns)-1].Role == role {
+			turns[len(turns)-1].Content += "\n\n" + msg.Content
+			continue
+		}
+		turns = append(turns, Message{Role: role, Content: msg.Content})
+	}
+	for len(turns) > 0 && turns[len(turns)-1].Role != anthropicUserRole {
+		turns = turns[:len(turns)-1]
+	}
+	if len(turns) == 0 {
+		return nil, errors.New("no user message to send")
+	}
+
+	model := c.Model
+	if model == nil {
+		model = ModelClaude35Sonnet
+	}
+	maxTokens := c.MaxTokens
+	if options != nil && options.MaxTokens > 0 {
+		maxTokens = options.MaxTokens
+	}
+	if maxTokens <= 0 || maxTokens > model.MaxOutputTokens {
+		maxTokens = model.MaxOutputTokens
+	}
+	request := &MessagesRequest{
+		Model:     model.Name,
+		System:    strings.Join(system, "\n\n"),
+		Messages:  turns,
+		MaxTokens: maxTokens,
+	}
+	if options != nil {
+		request.Temperature = options.Temperature
+		request.TopP = options.TopP
+		request.TopK = options.TopK
+		request.StopSequences = options.Stop
+		if options.User != "" {
+			request.Metadata = &RequestMetadata{UserID: options.User}
+		}
+	}
+	return request, nil
+}
+
+func (c *AnthropicClient) modelName() string {
+	if c.Model == nil {
+		return ModelClaude35Sonnet.Name
+	}
+	return c.Model.Name
+}
+
+// post sends the request and returns the response if it succeeded.
+func (c *AnthropicClient) post(request *MessagesRequest) (*http.Response, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"requestBody": string(requestBody),
+		}).Debug("Anthropic request")
+	}
+	url := API_URL
+	if c.BaseURL != "" {
+		url = c.BaseURL + "/v1/messages"
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", c.ApiKey)
+	req.Header.Set("anthropic-version", APIVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := &http.Client{}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var apiError errorResponse
+	if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
+		return nil, client.NewAPIError("Anthropic", resp.StatusCode, apiError.Error.Message)
+	}
+	return nil, client.NewAPIError("Anthropic", resp.StatusCode, string(body))
+}
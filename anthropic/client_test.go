@@ -0,0 +1,195 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSendMessages(t *testing.T) {
+	var received MessagesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("x-api-key"))
+		assert.Equal(t, APIVersion, r.Header.Get("anthropic-version"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{
+			"id": "msg_1", "type": "message", "role": "assistant",
+			"content": [{"type": "text", "text": "Paris"}],
+			"model": "claude-3-haiku-20240307",
+			"stop_reason": "end_turn",
+			"usage": {"input_tokens": 20, "output_tokens": 1}
+		}`))
+	}))
+	defer server.Close()
+
+	c := &AnthropicClient{ApiKey: "key", BaseURL: server.URL, Model: ModelClaude3Haiku, MaxTokens: 100000}
+	history := []db.Message{
+		db.CreateNewMessage(db.AssistentRoleNeam, "How can I help?", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "Hi", "ctx"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "Hello!", "ctx"),
+		db.CreateNewMessage(db.SystemRoleName, "Be brief.", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "I am planning a trip.", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "Capital of France?", "ctx"),
+	}
+
+	result, err := c.SendMessages(history, []string{"Answer with one word."})
+	require.NoError(t, err)
+	require.Len(t, result, len(history)+1)
+	answer := result[len(result)-1]
+	assert.Equal(t, "Paris", answer.Content)
+	assert.Equal(t, db.AssistentRoleNeam, answer.Role)
+	assert.Equal(t, db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            "claude-3-haiku-20240307",
+		FinishReason:     "end_turn",
+		PromptTokens:     20,
+		CompletionTokens: 1,
+		TotalTokens:      21,
+	}, answer.Metadata)
+
+	assert.Equal(t, MessagesRequest{
+		Model:  ModelClaude3Haiku.Name,
+		System: "Answer with one word.\n\nBe brief.",
+		Messages: []Message{
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello!"},
+			{Role: "user", Content: "I am planning a trip.\n\nCapital of France?"},
+		},
+		MaxTokens: ModelClaude3Haiku.MaxOutputTokens,
+	}, received)
+}
+
+func TestTrailingAssistantMessagesAreDropped(t *testing.T) {
+	c := &AnthropicClient{}
+	request, err := c.buildRequest([]db.Message{
+		db.CreateNewMessage(db.UserRoleName, "Hi", "ctx"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "Hello!", "ctx"),
+	}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []Message{{Role: "user", Content: "Hi"}}, request.Messages)
+	assert.Equal(t, "", request.System)
+
+	_, err = c.buildRequest([]db.Message{db.CreateNewMessage(db.AssistentRoleNeam, "Hello!", "ctx")}, nil, nil)
+	assert.EqualError(t, err, "no user message to send")
+}
+
+func TestStreamMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request MessagesRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.True(t, request.Stream)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []struct{ name, data string }{
+			{"message_start", `{"type": "message_start", "message": {"id": "msg_1", "role": "assistant", "content": [], "model": "claude-3-5-sonnet-20240620", "usage": {"input_tokens": 7, "output_tokens": 1}}}`},
+			{"content_block_start", `{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`},
+			{"ping", `{"type": "ping"}`},
+			{"content_block_delta", `{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}`},
+			{"content_block_delta", `{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "lo!"}}`},
+			{"content_block_stop", `{"type": "content_block_stop", "index": 0}`},
+			{"message_delta", `{"type": "message_delta", "delta": {"stop_reason": "max_tokens", "stop_sequence": null}, "usage": {"output_tokens": 2}}`},
+			{"message_stop", `{"type": "message_stop"}`},
+		} {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+		}
+	}))
+	defer server.Close()
+
+	c := NewDefaultAnthropicClient("key", nil)
+	c.Client.(*AnthropicClient).BaseURL = server.URL
+	c.Store = newTestStore(t)
+
+	chunks := make([]string, 0)
+	answer, err := c.StreamMessage("Hi", "ctx", func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello!", answer)
+	assert.Equal(t, []string{"Hel", "lo!"}, chunks)
+
+	stored, err := c.Store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            "claude-3-5-sonnet-20240620",
+		FinishReason:     "max_tokens",
+		PromptTokens:     7,
+		CompletionTokens: 2,
+		TotalTokens:      9,
+	}, stored[1].Metadata)
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "event: error\ndata: {\"type\": \"error\", \"error\": {\"type\": \"overloaded_error\", \"message\": \"Overloaded\"}}\n\n")
+	}))
+	defer server.Close()
+
+	c := &AnthropicClient{ApiKey: "key", BaseURL: server.URL}
+	_, err := c.StreamMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil, func(string) error { return nil })
+	assert.EqualError(t, err, "error event from Anthropic (overloaded_error): Overloaded")
+}
+
+func TestTruncatedStreamIsAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\": \"message_start\", \"message\": {\"id\": \"msg_1\", \"model\": \"claude-3-5-sonnet-20240620\"}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": \"Hel\"}}\n\n")
+	}))
+	defer server.Close()
+
+	c := NewDefaultAnthropicClient("key", nil)
+	c.Client.(*AnthropicClient).BaseURL = server.URL
+	c.Store = newTestStore(t)
+	_, err := c.StreamMessage("Hi", "ctx", func(string) error { return nil })
+	assert.EqualError(t, err, "error response from Anthropic (200): stream ended before message_stop")
+	assert.True(t, client.IsRetryable(err))
+
+	stored, err := c.Store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	assert.Empty(t, stored, "a truncated answer is not stored")
+}
+
+func TestApiErrorsAreReported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"type": "error", "error": {"type": "authentication_error", "message": "invalid x-api-key"}}`))
+	}))
+	defer server.Close()
+
+	c := &AnthropicClient{ApiKey: "key", BaseURL: server.URL}
+	_, err := c.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil)
+	assert.EqualError(t, err, "error response from Anthropic (401): invalid x-api-key")
+}
+
+func TestRequestOptions(t *testing.T) {
+	temperature := 0.3
+	c := &AnthropicClient{Model: ModelClaude3Haiku, MaxTokens: 1000}
+	request, err := c.buildRequest([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil,
+		&client.RequestOptions{Temperature: &temperature, MaxTokens: 10000, Stop: []string{"END"}, User: "u1"})
+	require.NoError(t, err)
+	assert.Equal(t, &temperature, request.Temperature)
+	assert.Equal(t, ModelClaude3Haiku.MaxOutputTokens, request.MaxTokens)
+	assert.Equal(t, []string{"END"}, request.StopSequences)
+	assert.Equal(t, &RequestMetadata{UserID: "u1"}, request.Metadata)
+
+	penalty := 0.5
+	_, err = c.buildRequest([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil, &client.RequestOptions{PresencePenalty: &penalty})
+	assert.EqualError(t, err, "anthropic does not support the options presence_penalty")
+}
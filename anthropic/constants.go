@@ -0,0 +1,47 @@
+package anthropic
+
+const API_URL = "https://api.anthropic.com/v1/messages"
+
+// APIVersion is sent as the anthropic-version header.
+const APIVersion = "2023-06-01"
+
+// ProviderName identifies Anthropic answers in db.MessageMetadata.
+const ProviderName = "anthropic"
+
+type ClaudeModel struct {
+	Name            string `json:"name"`
+	MaxTokens       int    `json:"max_tokens"`
+	MaxOutputTokens int    `json:"max_output_tokens"`
+}
+
+var ModelClaude3Opus = &ClaudeModel{
+	Name:            "claude-3-opus-20240229",
+	MaxTokens:       200000,
+	MaxOutputTokens: 4096,
+}
+
+var ModelClaude3Sonnet = &ClaudeModel{
+	Name:            "claude-3-sonnet-20240229",
+	MaxTokens:       200000,
+	MaxOutputTokens: 4096,
+}
+
+var ModelClaude3Haiku = &ClaudeModel{
+	Name:            "claude-3-haiku-20240307",
+	MaxTokens:       200000,
+	MaxOutputTokens: 4096,
+}
+
+var ModelClaude35Sonnet = &ClaudeModel{
+	Name:            "claude-3-5-sonnet-20240620",
+	MaxTokens:       200000,
+	MaxOutputTokens: 8192,
+}
+
+func GetClaudeModels() map[string]*ClaudeModel {
+	models := make(map[string]*ClaudeModel)
+	for _, model := range []*ClaudeModel{ModelClaude3Opus, ModelClaude3Sonnet, ModelClaude3Haiku, ModelClaude35Sonnet} {
+		models[model.Name] = model
+	}
+	return models
+}
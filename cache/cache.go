@@ -0,0 +1,355 @@
+// Package cache keeps answers of a model so that identical requests are not
+// sent (and paid for) twice.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/sirupsen/logrus"
+)
+
+// keyVersion is part of every key, changing it invalidates all entries.
+const keyVersion = 1
+
+// Entry is a cached answer.
+type Entry struct {
+	Role      string             `json:"role"`
+	Content   string             `json:"content"`
+	Metadata  db.MessageMetadata `json:"metadata"`
+	ToolCalls []db.ToolCall      `json:"tool_calls,omitempty"`
+	Parts     []db.ContentPart   `json:"parts,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	// ExpiresAt is zero for entries that do not expire.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// Backend stores entries by key. Get returns false when there is no entry.
+type Backend interface {
+	Get(key string) (Entry, bool, error)
+	Set(key string, entry Entry) error
+	Delete(key string) error
+}
+
+// Control decides how a request uses the cache.
+type Control int
+
+const (
+	// UseCache answers from the cache when possible and caches new answers.
+	UseCache Control = iota
+	// Bypass neither reads nor writes the cache.
+	Bypass
+	// Refresh asks the model and replaces the cached answer.
+	Refresh
+)
+
+type Stats struct {
+	Hits     int64
+	Misses   int64
+	Bypassed int64
+	// Refreshed counts requests that replaced a cached answer on purpose.
+	Refreshed int64
+	// Errors counts backend failures, the request then goes to the model.
+	Errors int64
+}
+
+// Client is an LllmChatClient that answers repeated requests from Backend.
+// Requests are the same when model, parameters, options, response format,
+// tools, context and the role, content, parts and tool calls of every message
+// are the same; message ids, timestamps and context ids do not matter.
+type Client struct {
+	Client  client.LllmChatClient
+	Backend Backend
+	// TTL is how long answers are kept, forever when zero.
+	TTL time.Duration
+	// Model and Parameters identify the settings of Client in the key, e.g.
+	// "openai:gpt-4" and the provider config. Requests with different
+	// settings must not share a Client unless they are distinguished here,
+	// Model is required.
+	Model      string
+	Parameters interface{}
+	Logger     *logrus.Logger
+
+	hits, misses, bypassed, refreshed, errors atomic.Int64
+}
+
+// ErrNoModel is returned for a Client without Model, which would share its
+// answers with every other model behind the same backend.
+var ErrNoModel = errors.New("cache client needs a model")
+
+func New(llmClient client.LllmChatClient, backend Backend, model string) (*Client, error) {
+	if model == "" {
+		return nil, ErrNoModel
+	}
+	return &Client{
+		Client:  llmClient,
+		Backend: backend,
+		Model:   model,
+	}, nil
+}
+
+// Unwrap returns the provider behind the cache.
+func (c *Client) Unwrap() client.LllmChatClient {
+	return c.Client
+}
+
+func (c *Client) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return c.SendMessagesWithControl(messages, context, UseCache)
+}
+
+func (c *Client) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	return c.StreamMessagesWithControl(messages, context, onChunk, UseCache)
+}
+
+func (c *Client) SendMessagesWithControl(messages []db.Message, context []string, control Control) ([]db.Message, error) {
+	return c.send(messages, context, variant{}, control, nil, c.Client.SendMessages)
+}
+
+// SendMessagesWithOptions passes the options to the model, requests with
+// different options do not share answers.
+func (c *Client) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	return c.sendWithOptions(messages, context, options, UseCache)
+}
+
+// SendMessagesWithFormat passes the response format to the model, requests
+// with different formats do not share answers.
+func (c *Client) SendMessagesWithFormat(messages []db.Message, context []string, format *client.ResponseFormat) ([]db.Message, error) {
+	return c.sendWithFormat(messages, context, format, UseCache)
+}
+
+// SendMessagesWithTools passes the tools to the model, requests with
+// different tools do not share answers. Tool calls of the answer are cached
+// with it.
+func (c *Client) SendMessagesWithTools(messages []db.Message, context []string, tools []client.Tool) ([]db.Message, error) {
+	return c.sendWithTools(messages, context, tools, UseCache)
+}
+
+func (c *Client) sendWithOptions(messages []db.Message, context []string, options *client.RequestOptions, control Control) ([]db.Message, error) {
+	send := func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithOptions(c.Client, messages, context, options, c.Logger)
+	}
+	return c.send(messages, context, variant{Options: options}, control, nil, send)
+}
+
+func (c *Client) sendWithFormat(messages []db.Message, context []string, format *client.ResponseFormat, control Control) ([]db.Message, error) {
+	send := func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithFormat(c.Client, messages, context, format)
+	}
+	return c.send(messages, context, variant{Format: format}, control, nil, send)
+}
+
+func (c *Client) sendWithTools(messages []db.Message, context []string, tools []client.Tool, control Control) ([]db.Message, error) {
+	send := func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithTools(c.Client, messages, context, tools)
+	}
+	return c.send(messages, context, variant{Tools: tools}, control, nil, send)
+}
+
+// StreamMessagesWithControl streams the answer of the model, a cached answer
+// is delivered as one chunk.
+func (c *Client) StreamMessagesWithControl(messages []db.Message, context []string, onChunk func(chunk string) error, control Control) ([]db.Message, error) {
+	stream := func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.StreamMessages(c.Client, messages, context, onChunk)
+	}
+	return c.send(messages, context, variant{}, control, onChunk, stream)
+}
+
+// WithControl returns a view of c for a client.Client whose requests should
+// all use control, e.g. a classifier that must never be answered from cache.
+// The view shares the backend and the stats of c and passes options,
+// formats and tools on like c.
+func (c *Client) WithControl(control Control) client.StreamingLllmChatClient {
+	return &controlled{cache: c, control: control}
+}
+
+type controlled struct {
+	cache   *Client
+	control Control
+}
+
+func (v *controlled) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return v.cache.SendMessagesWithControl(messages, context, v.control)
+}
+
+func (v *controlled) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	return v.cache.StreamMessagesWithControl(messages, context, onChunk, v.control)
+}
+
+func (v *controlled) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	return v.cache.sendWithOptions(messages, context, options, v.control)
+}
+
+func (v *controlled) SendMessagesWithFormat(messages []db.Message, context []string, format *client.ResponseFormat) ([]db.Message, error) {
+	return v.cache.sendWithFormat(messages, context, format, v.control)
+}
+
+func (v *controlled) SendMessagesWithTools(messages []db.Message, context []string, tools []client.Tool) ([]db.Message, error) {
+	return v.cache.sendWithTools(messages, context, tools, v.control)
+}
+
+// Unwrap returns the provider behind the cache.
+func (v *controlled) Unwrap() client.LllmChatClient {
+	return v.cache.Client
+}
+
+func (c *Client) Stats() Stats {
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Bypassed:  c.bypassed.Load(),
+		Refreshed: c.refreshed.Load(),
+		Errors:    c.errors.Load(),
+	}
+}
+
+func (c *Client) send(messages []db.Message, context []string, v variant, control Control, onChunk func(chunk string) error, send func([]db.Message, []string) ([]db.Message, error)) ([]db.Message, error) {
+	if control == Bypass || len(messages) == 0 {
+		c.bypassed.Add(1)
+		return send(messages, context)
+	}
+	key, err := c.key(messages, context, v)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	if control == Refresh {
+		c.refreshed.Add(1)
+	} else if entry, ok := c.get(key, now); ok {
+		c.hits.Add(1)
+		answer := db.CreateNewMessage(entry.Role, entry.Content, messages[0].ContextId)
+		answer.Metadata = entry.Metadata
+		answer.Metadata.Cached = true
+		answer.ToolCalls = entry.ToolCalls
+		answer.Parts = entry.Parts
+		if onChunk != nil {
+			if err := onChunk(answer.Content); err != nil {
+				return nil, err
+			}
+		}
+		return append(messages, answer), nil
+	} else {
+		c.misses.Add(1)
+	}
+
+	answers, err := send(messages, context)
+	if err != nil || len(answers) == 0 {
+		return answers, err
+	}
+	answer := answers[len(answers)-1]
+	entry := Entry{
+		Role:      answer.Role,
+		Content:   answer.Content,
+		Metadata:  answer.Metadata,
+		ToolCalls: answer.ToolCalls,
+		Parts:     answer.Parts,
+		CreatedAt: now,
+	}
+	if c.TTL > 0 {
+		entry.ExpiresAt = now.Add(c.TTL)
+	}
+	if err := c.Backend.Set(key, entry); err != nil {
+		c.backendError("store", err)
+	}
+	return answers, nil
+}
+
+func (c *Client) get(key string, now time.Time) (Entry, bool) {
+	entry, ok, err := c.Backend.Get(key)
+	if err != nil {
+		c.backendError("read", err)
+		return Entry{}, false
+	}
+	if !ok {
+		return Entry{}, false
+	}
+	if entry.expired(now) {
+		if err := c.Backend.Delete(key); err != nil {
+			c.backendError("delete", err)
+		}
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+func (c *Client) backendError(operation string, err error) {
+	c.errors.Add(1)
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"operation": operation,
+			"error":     err,
+		}).Warn("Response cache failed")
+	}
+}
+
+type keyMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	Parts      []db.ContentPart `json:"parts,omitempty"`
+	ToolCalls  []db.ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallId string           `json:"tool_call_id,omitempty"`
+}
+
+// variant holds what distinguishes requests besides model and messages. The
+// fields are omitted when empty so that keys of plain requests stay the same.
+type variant struct {
+	Options *client.RequestOptions `json:"options,omitempty"`
+	Format  *client.ResponseFormat `json:"format,omitempty"`
+	Tools   []client.Tool          `json:"tools,omitempty"`
+}
+
+type keyDocument struct {
+	Version    int          `json:"version"`
+	Model      string       `json:"model"`
+	Parameters interface{}  `json:"parameters"`
+	Context    []string     `json:"context"`
+	Messages   []keyMessage `json:"messages"`
+	variant
+}
+
+// Key returns the canonical hash of a request.
+func (c *Client) Key(messages []db.Message, context []string) (string, error) {
+	return c.key(messages, context, variant{})
+}
+
+func (c *Client) key(messages []db.Message, context []string, v variant) (string, error) {
+	if c.Model == "" {
+		return "", ErrNoModel
+	}
+	document := keyDocument{
+		Version:    keyVersion,
+		Model:      c.Model,
+		Parameters: c.Parameters,
+		Context:    context,
+		Messages:   make([]keyMessage, 0, len(messages)),
+		variant:    v,
+	}
+	for _, msg := range messages {
+		document.Messages = append(document.Messages, keyMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			Parts:      msg.Parts,
+			ToolCalls:  msg.ToolCalls,
+			ToolCallId: msg.ToolCallId,
+		})
+	}
+	// encoding/json writes struct fields in order and map keys sorted, so
+	// equal requests always give the same bytes.
+	data, err := json.Marshal(document)
+	if err != nil {
+		return "", fmt.Errorf("unable to build cache key: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,321 @@
+package cache
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingLlm struct {
+	calls int
+	err   error
+}
+
+func (l *countingLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	l.calls++
+	if l.err != nil {
+		return nil, l.err
+	}
+	last := messages[len(messages)-1]
+	answer := db.CreateNewMessage(db.AssistentRoleNeam, "label: "+last.Content, last.ContextId)
+	answer.Metadata = db.MessageMetadata{Provider: "fake", PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12}
+	return append(messages, answer), nil
+}
+
+func newCache(t *testing.T, llm client.LllmChatClient, backend Backend) *Client {
+	cached, err := New(llm, backend, "fake:model")
+	require.NoError(t, err)
+	return cached
+}
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestIdenticalRequestsAreAnsweredFromCache(t *testing.T) {
+	llm := &countingLlm{}
+	cached := newCache(t, llm, NewMemoryBackend(10))
+	c := &client.Client{Client: cached, Store: newTestStore(t)}
+
+	first, err := c.SendNoContextMessage("I love it")
+	require.NoError(t, err)
+	second, err := c.SendNoContextMessage("I love it")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, llm.calls)
+
+	_, err = c.SendNoContextMessage("I hate it")
+	require.NoError(t, err)
+	assert.Equal(t, 2, llm.calls)
+	assert.Equal(t, Stats{Hits: 1, Misses: 2}, cached.Stats())
+
+	messages, err := c.Store.GetMessagesByContextID(db.RandomContextId)
+	require.NoError(t, err)
+	require.Len(t, messages, 6)
+	assert.False(t, messages[1].Metadata.Cached)
+	assert.True(t, messages[3].Metadata.Cached)
+	assert.Equal(t, 12, messages[3].Metadata.TotalTokens)
+}
+
+func TestKeyIgnoresIdsAndTimestamps(t *testing.T) {
+	cached := newCache(t, &countingLlm{}, NewMemoryBackend(0))
+	a := db.CreateNewMessage(db.UserRoleName, "hello", "ctx-1")
+	b := db.CreateNewMessage(db.UserRoleName, "hello", "ctx-2")
+	b.Timestamp = a.Timestamp.Add(time.Hour)
+
+	keyA, err := cached.Key([]db.Message{a}, []string{"be brief"})
+	require.NoError(t, err)
+	keyB, err := cached.Key([]db.Message{b}, []string{"be brief"})
+	require.NoError(t, err)
+	assert.Equal(t, keyA, keyB)
+
+	keyContext, err := cached.Key([]db.Message{a}, []string{"be verbose"})
+	require.NoError(t, err)
+	assert.NotEqual(t, keyA, keyContext)
+
+	cached.Parameters = map[string]interface{}{"temperature": 0.2, "top_k": 40}
+	keyParameters, err := cached.Key([]db.Message{a}, []string{"be brief"})
+	require.NoError(t, err)
+	assert.NotEqual(t, keyA, keyParameters)
+	cached.Parameters = map[string]interface{}{"top_k": 40, "temperature": 0.2}
+	keyReordered, err := cached.Key([]db.Message{a}, []string{"be brief"})
+	require.NoError(t, err)
+	assert.Equal(t, keyParameters, keyReordered)
+}
+
+func TestControl(t *testing.T) {
+	llm := &countingLlm{}
+	cached := newCache(t, llm, NewMemoryBackend(10))
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "hello", "ctx")}
+
+	_, err := cached.SendMessagesWithControl(messages, nil, Bypass)
+	require.NoError(t, err)
+	_, err = cached.SendMessages(messages, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, llm.calls, "a bypassed request is not cached")
+
+	_, err = cached.WithControl(Refresh).SendMessages(messages, nil)
+	require.NoError(t, err)
+	answers, err := cached.SendMessages(messages, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, llm.calls)
+	assert.True(t, answers[1].Metadata.Cached)
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Bypassed: 1, Refreshed: 1}, cached.Stats())
+}
+
+func TestTTLAndErrors(t *testing.T) {
+	llm := &countingLlm{}
+	backend := NewMemoryBackend(10)
+	cached := newCache(t, llm, backend)
+	cached.TTL = time.Hour
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "hello", "ctx")}
+
+	llm.err = errors.New("provider is down")
+	_, err := cached.SendMessages(messages, nil)
+	assert.EqualError(t, err, "provider is down")
+	assert.Equal(t, 0, backend.Len(), "failures are not cached")
+
+	llm.err = nil
+	_, err = cached.SendMessages(messages, nil)
+	require.NoError(t, err)
+	key, err := cached.Key(messages, nil)
+	require.NoError(t, err)
+	entry, ok, err := backend.Get(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, time.Second)
+
+	entry.ExpiresAt = time.Now().Add(-time.Second)
+	require.NoError(t, backend.Set(key, entry))
+	_, err = cached.SendMessages(messages, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, llm.calls, "an expired entry is not used")
+}
+
+func TestMemoryBackendEvictsLeastRecentlyUsed(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	require.NoError(t, backend.Set("a", Entry{Content: "a"}))
+	require.NoError(t, backend.Set("b", Entry{Content: "b"}))
+	_, ok, _ := backend.Get("a")
+	require.True(t, ok)
+	require.NoError(t, backend.Set("c", Entry{Content: "c"}))
+
+	_, ok, _ = backend.Get("b")
+	assert.False(t, ok)
+	_, ok, _ = backend.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, backend.Len())
+}
+
+func TestStoreBackendSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	store, err := db.NewSQLiteStore(path)
+	require.NoError(t, err)
+	llm := &countingLlm{}
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "hello", "ctx")}
+
+	_, err = newCache(t, llm, NewStoreBackend(store)).SendMessages(messages, []string{"classify"})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	store, err = db.NewSQLiteStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	cached := newCache(t, llm, NewStoreBackend(store))
+	answers, err := cached.SendMessages(messages, []string{"classify"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, llm.calls)
+	assert.Equal(t, "label: hello", answers[1].Content)
+	assert.Equal(t, "fake", answers[1].Metadata.Provider)
+	assert.True(t, answers[1].Metadata.Cached)
+}
+
+func TestCachedAnswerIsStreamedAsOneChunk(t *testing.T) {
+	llm := &countingLlm{}
+	cached := newCache(t, llm, NewMemoryBackend(10))
+	c := &client.Client{Client: cached, Store: newTestStore(t)}
+
+	chunks := make([]string, 0)
+	onChunk := func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}
+	_, err := c.StreamMessage("hello", "", onChunk)
+	require.NoError(t, err)
+	_, err = c.StreamMessage("hello", "", onChunk)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"label: hello", "label: hello"}, chunks)
+	assert.Equal(t, 1, llm.calls)
+}
+
+func TestModelIsRequired(t *testing.T) {
+	_, err := New(&countingLlm{}, NewMemoryBackend(10), "")
+	assert.ErrorIs(t, err, ErrNoModel)
+
+	cached := &Client{Client: &countingLlm{}, Backend: NewMemoryBackend(10)}
+	_, err = cached.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "hello", "ctx")}, nil)
+	assert.ErrorIs(t, err, ErrNoModel)
+}
+
+func TestModelsDoNotShareAnswers(t *testing.T) {
+	backend := NewMemoryBackend(10)
+	llm := &countingLlm{}
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "hello", "ctx")}
+	gpt4, err := New(llm, backend, "openai:gpt-4")
+	require.NoError(t, err)
+	gpt35, err := New(llm, backend, "openai:gpt-3.5-turbo")
+	require.NoError(t, err)
+
+	_, err = gpt4.SendMessages(messages, nil)
+	require.NoError(t, err)
+	answers, err := gpt35.SendMessages(messages, nil)
+	require.NoError(t, err)
+	assert.False(t, answers[1].Metadata.Cached)
+	assert.Equal(t, 2, llm.calls)
+}
+
+// toolLlm calls a tool for every request.
+type toolLlm struct {
+	countingLlm
+	tools [][]client.Tool
+}
+
+func (l *toolLlm) SendMessagesWithTools(messages []db.Message, context []string, tools []client.Tool) ([]db.Message, error) {
+	l.tools = append(l.tools, tools)
+	answers, err := l.SendMessages(messages, context)
+	if err != nil {
+		return nil, err
+	}
+	answers[len(answers)-1].ToolCalls = []db.ToolCall{{ID: "call_1", Name: tools[0].Name, Arguments: "{}"}}
+	return answers, nil
+}
+
+func TestToolRequests(t *testing.T) {
+	llm := &toolLlm{}
+	cached := newCache(t, llm, NewMemoryBackend(10))
+	assert.True(t, client.SupportsTools(cached))
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "weather?", "ctx")}
+	weather := []client.Tool{{Name: "weather", Parameters: client.Schema{"type": "object"}}}
+
+	_, err := cached.SendMessagesWithTools(messages, nil, weather)
+	require.NoError(t, err)
+	answers, err := cached.SendMessagesWithTools(messages, nil, weather)
+	require.NoError(t, err)
+	assert.True(t, answers[1].Metadata.Cached)
+	assert.Equal(t, []db.ToolCall{{ID: "call_1", Name: "weather", Arguments: "{}"}}, answers[1].ToolCalls)
+	assert.Len(t, llm.tools, 1)
+
+	_, err = cached.SendMessages(messages, nil)
+	require.NoError(t, err)
+	_, err = cached.SendMessagesWithTools(messages, nil, []client.Tool{{Name: "time"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, llm.calls, "requests with other tools or without tools have their own answers")
+
+	// The tool results of a conversation are part of the key.
+	call := answers[1]
+	result := db.CreateNewMessage(db.ToolRoleName, "sunny", "ctx")
+	result.ToolCallId = "call_1"
+	keySunny, err := cached.Key(append(messages, call, result), nil)
+	require.NoError(t, err)
+	result.ToolCallId = "call_2"
+	keyOther, err := cached.Key(append(messages, call, result), nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, keySunny, keyOther)
+
+	plain := newCache(t, &countingLlm{}, NewMemoryBackend(10))
+	assert.False(t, client.SupportsTools(plain))
+	_, err = plain.SendMessagesWithTools(messages, nil, weather)
+	assert.EqualError(t, err, "*cache.countingLlm does not support native tool calls")
+}
+
+func TestControlledViewPassesRequestsOn(t *testing.T) {
+	llm := &toolLlm{}
+	cached := newCache(t, llm, NewMemoryBackend(10))
+	refresh := cached.WithControl(Refresh)
+	assert.Same(t, llm, refresh.(client.Unwrapper).Unwrap())
+	assert.True(t, client.SupportsTools(refresh))
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "weather?", "ctx")}
+	weather := []client.Tool{{Name: "weather", Parameters: client.Schema{"type": "object"}}}
+
+	for i := 0; i < 2; i++ {
+		answers, err := client.SendMessagesWithTools(refresh, messages, nil, weather)
+		require.NoError(t, err)
+		assert.False(t, answers[1].Metadata.Cached)
+	}
+	assert.Equal(t, [][]client.Tool{weather, weather}, llm.tools)
+	answers, err := cached.SendMessagesWithTools(messages, nil, weather)
+	require.NoError(t, err)
+	assert.True(t, answers[1].Metadata.Cached, "the refreshed answer is cached with its tools")
+
+	format := &client.ResponseFormat{Type: client.ResponseFormatJSONObject}
+	_, err = client.SendMessagesWithFormat(cached.WithControl(Bypass), messages, nil, format)
+	require.NoError(t, err)
+	assert.Equal(t, 3, llm.calls)
+	assert.Equal(t, Stats{Hits: 1, Bypassed: 1, Refreshed: 2}, cached.Stats())
+}
+
+func TestFormatIsPartOfTheKey(t *testing.T) {
+	llm := &countingLlm{}
+	cached := newCache(t, llm, NewMemoryBackend(10))
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "hello", "ctx")}
+	format := &client.ResponseFormat{Type: client.ResponseFormatJSONObject}
+
+	_, err := cached.SendMessages(messages, nil)
+	require.NoError(t, err)
+	answers, err := cached.SendMessagesWithFormat(messages, nil, format)
+	require.NoError(t, err)
+	assert.False(t, answers[1].Metadata.Cached)
+	answers, err = cached.SendMessagesWithFormat(messages, nil, format)
+	require.NoError(t, err)
+	assert.True(t, answers[1].Metadata.Cached)
+	assert.Equal(t, 2, llm.calls)
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// MemoryBackend keeps up to Capacity entries in memory and evicts the least
+// recently used one when it is full.
+type MemoryBackend struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry Entry
+}
+
+// NewMemoryBackend returns a backend for capacity entries, unlimited when
+// capacity is 0.
+func NewMemoryBackend(capacity int) *MemoryBackend {
+	return &MemoryBackend{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryBackend) Get(key string) (Entry, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.entries[key]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	m.order.MoveToFront(element)
+	return element.Value.(*memoryItem).entry, true, nil
+}
+
+func (m *MemoryBackend) Set(key string, entry Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*memoryItem).entry = entry
+		m.order.MoveToFront(element)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(&memoryItem{key: key, entry: entry})
+	if m.capacity > 0 && m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if element, ok := m.entries[key]; ok {
+		m.order.Remove(element)
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// StoreBackend keeps entries in the response_cache table of a db.SQLStore,
+// so they survive restarts and are shared by the processes using the
+// database.
+type StoreBackend struct {
+	Store db.ResponseCacheStore
+}
+
+func NewStoreBackend(store db.ResponseCacheStore) *StoreBackend {
+	return &StoreBackend{Store: store}
+}
+
+func (s *StoreBackend) Get(key string) (Entry, bool, error) {
+	cached, err := s.Store.GetCachedResponse(key)
+	if errors.Is(err, db.ErrCacheMiss) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	var entry Entry
+	if err := json.Unmarshal([]byte(cached.Value), &entry); err != nil {
+		return Entry{}, false, fmt.Errorf("invalid cached response %s: %v", key, err)
+	}
+	entry.CreatedAt = cached.CreatedAt
+	entry.ExpiresAt = cached.ExpiresAt
+	return entry, true, nil
+}
+
+func (s *StoreBackend) Set(key string, entry Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.Store.PutCachedResponse(db.CachedResponse{
+		Key:       key,
+		Value:     string(value),
+		CreatedAt: entry.CreatedAt,
+		ExpiresAt: entry.ExpiresAt,
+	})
+}
+
+func (s *StoreBackend) Delete(key string) error {
+	return s.Store.DeleteCachedResponse(key)
+}
@@ -1,21 +1,66 @@
 package client
 
 import (
+	"errors"
+
 	"github.com/assistant-ai/llmchat-client/db"
 	"github.com/sirupsen/logrus"
 )
 
+// Client sends messages to a model and keeps the conversation in a Store.
+//
+// A Client is safe for concurrent use. Different contexts never interfere,
+// but by default two messages sent to the same context at the same time both
+// read the history before either answer is stored, so neither of them sees
+// the other's turn. Set SerializeContexts to process messages of one context
+// one at a time within this Client. Processes that share a SQLite file should
+// use db.NewSQLiteStoreWithOptions with a busy timeout, turns of different
+// processes are then stored safely but are not serialized.
 type Client struct {
 	Client         LllmChatClient
 	ContextDepth   int
 	DefaultContext string
 	Logger         *logrus.Logger
+	// Store keeps contexts and message history, db.DefaultStore() is used
+	// when it is nil.
+	Store db.Store
+	// A turn (the user message and the answer) is stored only when the model
+	// answers. With KeepFailedMessages the user message of a failed turn is
+	// stored with db.MessageStatusFailed, it is never sent to the model again.
+	KeepFailedMessages bool
+	// SerializeContexts makes messages to the same context wait for the
+	// previous turn to be stored before the history is read.
+	SerializeContexts bool
+	// Formatter rewrites the messages sent to the provider, e.g. to tell
+	// the model when they were written. Messages are sent as they are when
+	// it is nil.
+	Formatter MessageFormatter
+
+	locks contextLocks
 }
 
 type LllmChatClient interface {
 	SendMessages(messages []db.Message, context []string) ([]db.Message, error)
 }
 
+// StreamingLllmChatClient is implemented by providers that can deliver the
+// answer in parts while the model is producing it. An error returned by
+// onChunk stops the stream and is returned by StreamMessages.
+type StreamingLllmChatClient interface {
+	LllmChatClient
+	StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error)
+}
+
+// TurnObserver is implemented by wrappers that act on a turn once it is
+// stored, such as memory.Client. Client notifies every TurnObserver among
+// its provider and the providers they wrap (see Unwrapper).
+type TurnObserver interface {
+	TurnStored(request db.Message, answer db.Message)
+}
+
+// sendFunc sends the history and system context of a turn to the provider.
+type sendFunc func(messages []db.Message, context []string) ([]db.Message, error)
+
 func (c *Client) SendNoContextMessage(message string) (string, error) {
 	return c.SendMessageWithContextDepth(message, db.RandomContextId, 0, false)
 }
@@ -29,32 +74,89 @@ func (c *Client) SendMessage(message string, inputContextId string) (string, err
 }
 
 func (c *Client) SendMessageWithContextDepth(message string, inputContextId string, contextDepth int, addAllSystemContext bool) (string, error) {
-	messages := make([]db.Message, 0)
-	contextId := inputContextId
+	_, answerMessage, err := c.sendTurn(newRequest(message), inputContextId, contextDepth, addAllSystemContext, c.Client.SendMessages)
+	if err != nil {
+		return "", err
+	}
+	return answerMessage.Content, nil
+}
+
+// SendMultipartMessage sends a message made of parts, e.g. text and images,
+// see db.NewImageFilePart. Providers that do not support images receive the
+// text parts only.
+func (c *Client) SendMultipartMessage(parts []db.ContentPart, inputContextId string) (string, error) {
+	request := db.CreateNewMultipartMessage(db.UserRoleName, "", parts...)
+	_, answerMessage, err := c.sendTurn(request, inputContextId, c.ContextDepth, true, c.Client.SendMessages)
+	if err != nil {
+		return "", err
+	}
+	return answerMessage.Content, nil
+}
+
+// newRequest is the user message of a turn, its context id is set when the
+// turn is prepared.
+func newRequest(message string) db.Message {
+	return db.CreateNewMessage(db.UserRoleName, message, "")
+}
+
+// sendTurn sends one user message and returns it together with the answer,
+// both as they were stored.
+func (c *Client) sendTurn(request db.Message, inputContextId string, contextDepth int, addAllSystemContext bool, send sendFunc) (db.Message, db.Message, error) {
 	if c.Logger != nil {
 		c.Logger.WithFields(logrus.Fields{
-			"message":           message,
-			"contextId":         contextId,
+			"message":           request.Content,
+			"contextId":         inputContextId,
 			"contextDepth":      contextDepth,
 			"addAllSystemConte": addAllSystemContext,
 		}).Debug("Send message")
 	}
+	store, err := c.store()
+	if err != nil {
+		return db.Message{}, db.Message{}, err
+	}
+	defer c.lockContext(inputContextId)()
+	t, err := c.prepareTurn(store, request, inputContextId, contextDepth, addAllSystemContext)
+	if err != nil {
+		return db.Message{}, db.Message{}, err
+	}
+	answers, err := send(c.format(t.messages, t.context))
+	if err != nil {
+		return db.Message{}, db.Message{}, c.failTurn(store, t, err)
+	}
+	answerMessage, err := c.commitTurn(store, t, answers)
+	if err != nil {
+		return db.Message{}, db.Message{}, err
+	}
+	c.notifyTurnStored(t.request, answerMessage)
+	return t.request, answerMessage, nil
+}
+
+// turn is a user message together with the history and system context that
+// are sent to the model with it. Nothing is stored until the model answers.
+type turn struct {
+	request  db.Message
+	messages []db.Message
+	context  []string
+}
+
+func (c *Client) prepareTurn(store db.Store, request db.Message, inputContextId string, contextDepth int, addAllSystemContext bool) (*turn, error) {
+	messages := make([]db.Message, 0)
+	contextId := inputContextId
 	context := make([]string, 0)
-	existContext, err := db.CheckIfContextExists(contextId)
+	existContext, err := store.CheckIfContextExists(contextId)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if !existContext {
-		db.CreateContext(contextId, "")
+		store.CreateContext(contextId, "")
 	}
 
 	if contextId == "" || contextId == db.RandomContextId {
 		contextId = db.RandomContextId
 	} else {
-		count := contextDepth
-		messagesFromDb, err := db.GetLastMessagesByContextID(contextId, count)
+		messagesFromDb, err := store.GetLastMessagesByContextID(contextId, contextDepth)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		messages = messagesFromDb
 	}
@@ -62,55 +164,102 @@ func (c *Client) SendMessageWithContextDepth(message string, inputContextId stri
 		if c.DefaultContext != "" {
 			context = append(context, c.DefaultContext)
 		}
-		userDefaultContextExist, err := db.CheckIfUserDefaultContextExists()
+		userDefaultContextExist, err := store.CheckIfContextExists(db.DefaultContextID)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		if userDefaultContextExist {
-			userDefaultContextMessage, err := db.GetUserDefaultContextMessage()
+			userDefaultContextMessage, err := store.GetContextMessage(db.DefaultContextID)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 			context = append(context, userDefaultContextMessage)
 		}
 	}
 
-	contextExist, err := db.CheckIfContextExists(contextId)
+	contextMessage, err := store.GetContextMessage(contextId)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"contextMessage": contextMessage,
+			"contextId":      contextId,
+		}).Debug("Context message")
+	}
+	if contextMessage != "" {
+		context = append(context, contextMessage)
 	}
 
-	contextMessage := ""
-	if contextExist {
-		contextMessage, err := db.GetContextMessage(contextId)
-		if err != nil {
-			return "", err
+	request.ContextId = contextId
+	return &turn{
+		request:  request,
+		messages: append(messages, request),
+		context:  context,
+	}, nil
+}
+
+func (c *Client) format(messages []db.Message, context []string) ([]db.Message, []string) {
+	if c.Formatter == nil {
+		return messages, context
+	}
+	return c.Formatter.Format(messages, context)
+}
+
+// commitTurn stores the user message and the answer in one transaction.
+func (c *Client) commitTurn(store db.Store, t *turn, answers []db.Message) (db.Message, error) {
+	if len(answers) == 0 {
+		return db.Message{}, errors.New("model returned no messages")
+	}
+	answerMessage := answers[len(answers)-1]
+	if err := store.StoreMessages(t.request, answerMessage); err != nil {
+		return db.Message{}, err
+	}
+	return answerMessage, nil
+}
+
+func (c *Client) notifyTurnStored(request db.Message, answer db.Message) {
+	for provider := c.Client; provider != nil; {
+		if observer, ok := provider.(TurnObserver); ok {
+			observer.TurnStored(request, answer)
 		}
-		if c.Logger != nil {
-			c.Logger.WithFields(logrus.Fields{
-				"contextMessage": contextMessage,
-				"contextId":      contextId,
-			}).Debug("Context message")
+		wrapper, ok := provider.(Unwrapper)
+		if !ok {
+			break
 		}
+		provider = wrapper.Unwrap()
 	}
+}
 
-	if contextMessage != "" {
-		context = append(context, contextMessage)
+// failTurn keeps the user message marked as failed when KeepFailedMessages is
+// set, and returns the original error of the model.
+func (c *Client) failTurn(store db.Store, t *turn, sendErr error) error {
+	if !c.KeepFailedMessages {
+		return sendErr
 	}
-	newMessage := db.CreateNewMessage(db.UserRoleName, message, contextId)
-	_, err = db.StoreMessage(newMessage)
-	if err != nil {
-		return "", err
+	failed := t.request
+	failed.Status = db.MessageStatusFailed
+	if _, err := store.StoreMessage(failed); err != nil && c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"contextId": failed.ContextId,
+			"error":     err,
+		}).Warn("Unable to store failed message")
 	}
-	messages = append(messages, newMessage)
-	answers, err := (c.Client).SendMessages(messages, context)
-	if err != nil {
-		return "", err
+	return sendErr
+}
+
+// lockContext serializes turns of a context when SerializeContexts is set.
+// The random context has no history, so it is never locked.
+func (c *Client) lockContext(contextId string) func() {
+	if !c.SerializeContexts || contextId == "" || contextId == db.RandomContextId {
+		return func() {}
 	}
-	answerMessage := answers[len(answers)-1]
-	_, err = db.StoreMessage(answerMessage)
-	if err != nil {
-		return "", err
+	return c.locks.lock(contextId)
+}
+
+func (c *Client) store() (db.Store, error) {
+	if c.Store != nil {
+		return c.Store, nil
 	}
-	return answerMessage.Content, nil
+	return db.DefaultStore()
 }
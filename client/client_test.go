@@ -0,0 +1,614 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/prompt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLlm struct {
+	mu       sync.Mutex
+	err      error
+	delay    time.Duration
+	received [][]db.Message
+}
+
+func (f *fakeLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	f.mu.Lock()
+	f.received = append(f.received, messages)
+	f.mu.Unlock()
+	time.Sleep(f.delay)
+	if f.err != nil {
+		return nil, f.err
+	}
+	last := messages[len(messages)-1]
+	return append(messages, db.CreateNewMessage(db.AssistentRoleNeam, "echo: "+last.Content, last.ContextId)), nil
+}
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestFailedTurnIsNotStored(t *testing.T) {
+	store := newTestStore(t)
+	llm := &fakeLlm{err: errors.New("provider is down")}
+	c := &Client{Client: llm, ContextDepth: 10, Store: store}
+
+	_, err := c.SendMessage("lost question", "ctx")
+	assert.EqualError(t, err, "provider is down")
+
+	messages, err := store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	llm.err = nil
+	answer, err := c.SendMessage("question", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "echo: question", answer)
+
+	messages, err = store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, db.UserRoleName, messages[0].Role)
+	assert.Equal(t, db.AssistentRoleNeam, messages[1].Role)
+}
+
+func TestKeepFailedMessagesExcludesThemFromHistory(t *testing.T) {
+	store := newTestStore(t)
+	llm := &fakeLlm{err: errors.New("provider is down")}
+	c := &Client{Client: llm, ContextDepth: 10, Store: store, KeepFailedMessages: true}
+
+	_, err := c.SendMessage("lost question", "ctx")
+	require.Error(t, err)
+
+	messages, err := store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, db.MessageStatusFailed, messages[0].Status)
+
+	llm.err = nil
+	_, err = c.SendMessage("question", "ctx")
+	require.NoError(t, err)
+
+	sent := llm.received[len(llm.received)-1]
+	require.Len(t, sent, 1, "the failed message must not be replayed")
+	assert.Equal(t, "question", sent[0].Content)
+}
+
+func TestContextMessageIsSent(t *testing.T) {
+	store := newTestStore(t)
+	require.NoError(t, store.UpdateContext("ctx", "be brief"))
+	var gotContext []string
+	c := &Client{Client: contextRecorder(func(context []string) { gotContext = context }), ContextDepth: 10, Store: store}
+
+	_, err := c.SendMessage("question", "ctx")
+	require.NoError(t, err)
+	assert.Contains(t, gotContext, "be brief")
+}
+
+type contextRecorder func(context []string)
+
+func (r contextRecorder) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	r(context)
+	return (&fakeLlm{}).SendMessages(messages, context)
+}
+
+func TestHistoryIsSentOldestFirst(t *testing.T) {
+	store := newTestStore(t)
+	llm := &fakeLlm{}
+	c := &Client{Client: llm, ContextDepth: 10, Store: store}
+
+	for _, question := range []string{"first", "second", "third"} {
+		_, err := c.SendMessage(question, "ctx")
+		require.NoError(t, err)
+	}
+
+	sent := llm.received[len(llm.received)-1]
+	contents := make([]string, 0, len(sent))
+	for _, m := range sent {
+		contents = append(contents, m.Content)
+	}
+	assert.Equal(t, []string{"first", "echo: first", "second", "echo: second", "third"}, contents)
+}
+
+func TestSerializeContextsUnderConcurrency(t *testing.T) {
+	store := newTestStore(t)
+	llm := &fakeLlm{delay: time.Millisecond}
+	c := &Client{Client: llm, ContextDepth: 1000, Store: store, SerializeContexts: true}
+
+	const goroutines = 8
+	const perGoroutine = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*perGoroutine)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				if _, err := c.SendMessage(fmt.Sprintf("g%d-%d", g, i), "shared"); err != nil {
+					errs <- err
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	messages, err := store.GetMessagesByContextID("shared")
+	require.NoError(t, err)
+	require.Len(t, messages, 2*goroutines*perGoroutine)
+	for i, m := range messages {
+		assert.Equal(t, int64(i+1), m.Seq)
+		if i%2 == 1 {
+			assert.Equal(t, "echo: "+messages[i-1].Content, m.Content, "answer must follow its question")
+		}
+	}
+
+	// Every request saw all turns stored before it.
+	seen := map[int]bool{}
+	for _, sent := range llm.received {
+		assert.Equal(t, 1, len(sent)%2)
+		seen[len(sent)] = true
+	}
+	assert.Len(t, seen, goroutines*perGoroutine)
+}
+
+func TestSharedSQLiteFileFromSeveralStores(t *testing.T) {
+	dbFilePath := filepath.Join(t.TempDir(), "messages.db")
+	const processes = 4
+	const perProcess = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, processes*perProcess+processes)
+	for p := 0; p < processes; p++ {
+		store, err := db.NewSQLiteStore(dbFilePath)
+		require.NoError(t, err)
+		defer store.Close()
+		c := &Client{Client: &fakeLlm{}, ContextDepth: 4, Store: store}
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProcess; i++ {
+				if _, err := c.SendMessage(fmt.Sprintf("p%d-%d", p, i), "shared"); err != nil {
+					errs <- err
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	store, err := db.NewSQLiteStore(dbFilePath)
+	require.NoError(t, err)
+	defer store.Close()
+	messages, err := store.GetMessagesByContextID("shared")
+	require.NoError(t, err)
+	require.Len(t, messages, 2*processes*perProcess)
+	for i, m := range messages {
+		assert.Equal(t, int64(i+1), m.Seq)
+	}
+}
+
+func TestSendTemplateMessageRecordsUsage(t *testing.T) {
+	store := newTestStore(t)
+	registry := prompt.NewRegistry(store)
+	_, err := registry.Register(prompt.Template{
+		Name:      "greeting",
+		Body:      "Say hello to {{.name}}",
+		Variables: []prompt.Variable{{Name: "name", Type: prompt.StringVariable, Required: true}},
+	})
+	require.NoError(t, err)
+	_, err = registry.Register(prompt.Template{Name: "system", Body: "You are terse."})
+	require.NoError(t, err)
+	c := &Client{Client: &fakeLlm{}, ContextDepth: 10, Store: store}
+
+	require.NoError(t, c.SetTemplateSystemPrompt(registry, "system", 0, nil, "ctx"))
+	contextMessage, err := store.GetContextMessage("ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "You are terse.", contextMessage)
+
+	answer, err := c.SendTemplateMessage(registry, "greeting", 0, map[string]interface{}{"name": "Bob"}, "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "echo: Say hello to Bob", answer)
+
+	usages, err := store.GetTemplateUsages("greeting")
+	require.NoError(t, err)
+	require.Len(t, usages, 1)
+	assert.Equal(t, 1, usages[0].TemplateVersion)
+	assert.JSONEq(t, `{"name": "Bob"}`, usages[0].Variables)
+	message, err := store.GetMessageByID(usages[0].MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, "Say hello to Bob", message.Content)
+
+	usages, err = store.GetTemplateUsages("system")
+	require.NoError(t, err)
+	require.Len(t, usages, 1)
+	assert.Equal(t, "", usages[0].MessageID)
+	assert.Equal(t, "ctx", usages[0].ContextId)
+}
+
+func TestStreamMessageFallsBackToOneChunk(t *testing.T) {
+	store := newTestStore(t)
+	c := &Client{Client: &fakeLlm{}, ContextDepth: 10, Store: store}
+
+	chunks := make([]string, 0)
+	answer, err := c.StreamMessage("question", "ctx", func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "echo: question", answer)
+	assert.Equal(t, []string{"echo: question"}, chunks)
+
+	_, err = c.StreamMessage("question", "ctx", func(chunk string) error {
+		return errors.New("client went away")
+	})
+	assert.EqualError(t, err, "client went away")
+	messages, err := store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	assert.Len(t, messages, 2, "a cancelled stream stores nothing")
+}
+
+func TestFallbackClientUsesNextProviderOnRetryableErrors(t *testing.T) {
+	store := newTestStore(t)
+	primary := &fakeLlm{err: NewAPIError("GPT", 503, "overloaded")}
+	secondary := &fakeLlm{}
+	fallback := NewFallbackClient(
+		FallbackProvider{Name: "openai", Client: primary},
+		FallbackProvider{Name: "vertex", Client: secondary},
+	)
+	c := &Client{Client: fallback, ContextDepth: 10, Store: store}
+
+	answer, err := c.SendMessage("question", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "echo: question", answer)
+	assert.Len(t, primary.received, 1)
+	assert.Len(t, secondary.received, 1)
+
+	messages, err := store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "vertex", messages[1].Metadata.Provider)
+
+	primary.err = NewAPIError("GPT", 400, "bad request")
+	_, err = c.SendMessage("question", "ctx")
+	assert.EqualError(t, err, "error response from GPT (400): bad request")
+	assert.Len(t, secondary.received, 1, "a request error is not retried elsewhere")
+
+	primary.err = NewAPIError("GPT", 429, "rate limited")
+	secondary.err = NewAPIError("PaLM", 500, "internal error")
+	_, err = c.SendMessage("question", "ctx")
+	assert.EqualError(t, err, "all providers failed: openai: error response from GPT (429): rate limited; vertex: error response from PaLM (500): internal error")
+}
+
+func TestFallbackCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	primary := &fakeLlm{err: NewAPIError("GPT", 502, "bad gateway")}
+	secondary := &fakeLlm{}
+	fallback := &FallbackClient{
+		Providers: []FallbackProvider{
+			{Name: "openai", Client: primary},
+			{Name: "vertex", Client: secondary},
+		},
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		now:              func() time.Time { return now },
+	}
+	send := func() {
+		_, err := fallback.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "question", "ctx")}, nil)
+		require.NoError(t, err)
+	}
+
+	send()
+	send()
+	assert.Len(t, primary.received, 2)
+	health := fallback.Health()
+	assert.Equal(t, CircuitOpen, health[0].State)
+	assert.Equal(t, 2, health[0].ConsecutiveFailures)
+	assert.Equal(t, CircuitClosed, health[1].State)
+
+	send()
+	assert.Len(t, primary.received, 2, "an open circuit skips the provider")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, fallback.Health()[0].State)
+	send()
+	assert.Len(t, primary.received, 3, "a half-open circuit lets one request through")
+	assert.Equal(t, CircuitOpen, fallback.Health()[0].State, "a failed trial opens the circuit again")
+
+	now = now.Add(time.Minute)
+	primary.err = nil
+	send()
+	assert.Len(t, primary.received, 4)
+	health = fallback.Health()
+	assert.Equal(t, CircuitClosed, health[0].State)
+	assert.Equal(t, 0, health[0].ConsecutiveFailures)
+}
+
+func TestFallbackTreatsNetworkErrorsAsRetryable(t *testing.T) {
+	_, netErr := net.Dial("tcp", "127.0.0.1:1")
+	require.Error(t, netErr)
+	assert.True(t, IsRetryable(fmt.Errorf("send: %w", netErr)))
+	assert.False(t, IsRetryable(errors.New("no candidates")))
+}
+
+type chunkThenFail struct{}
+
+func (chunkThenFail) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return nil, errors.New("not used")
+}
+
+func (chunkThenFail) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	if err := onChunk("Hel"); err != nil {
+		return nil, err
+	}
+	return nil, NewAPIError("Anthropic", 529, "overloaded")
+}
+
+func TestFallbackDoesNotSwitchProvidersMidStream(t *testing.T) {
+	secondary := &fakeLlm{}
+	fallback := NewFallbackClient(
+		FallbackProvider{Name: "anthropic", Client: chunkThenFail{}},
+		FallbackProvider{Name: "vertex", Client: secondary},
+	)
+	c := &Client{Client: fallback, ContextDepth: 10, Store: newTestStore(t)}
+
+	_, err := c.StreamMessage("question", "ctx", func(chunk string) error { return nil })
+	assert.EqualError(t, err, "error response from Anthropic (529): overloaded")
+	assert.Empty(t, secondary.received)
+}
+
+type review struct {
+	Sentiment string   `json:"sentiment" enum:"positive,negative" description:"Overall sentiment"`
+	Score     int      `json:"score"`
+	Topics    []string `json:"topics,omitempty"`
+	Summary   *string  `json:"summary"`
+}
+
+type scriptedLlm struct {
+	answers  []string
+	formats  []*ResponseFormat
+	received [][]db.Message
+	contexts [][]string
+}
+
+func (s *scriptedLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return s.SendMessagesWithFormat(messages, context, nil)
+}
+
+func (s *scriptedLlm) SendMessagesWithFormat(messages []db.Message, context []string, format *ResponseFormat) ([]db.Message, error) {
+	s.formats = append(s.formats, format)
+	s.received = append(s.received, messages)
+	s.contexts = append(s.contexts, context)
+	answer := s.answers[0]
+	s.answers = s.answers[1:]
+	return append(messages, db.CreateNewMessage(db.AssistentRoleNeam, answer, messages[0].ContextId)), nil
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema, err := JSONSchema(&review{})
+	require.NoError(t, err)
+	assert.Equal(t, Schema{
+		"type": "object",
+		"properties": Schema{
+			"sentiment": Schema{"type": "string", "enum": []interface{}{"positive", "negative"}, "description": "Overall sentiment"},
+			"score":     Schema{"type": "integer"},
+			"topics":    Schema{"type": "array", "items": Schema{"type": "string"}},
+			"summary":   Schema{"type": []string{"string", "null"}},
+		},
+		"required":             []string{"score", "sentiment"},
+		"additionalProperties": false,
+	}, schema)
+
+	valid := map[string]interface{}{"sentiment": "positive", "score": 4.0, "topics": []interface{}{"price"}}
+	assert.NoError(t, schema.Validate(valid))
+	assert.EqualError(t, schema.Validate(map[string]interface{}{"sentiment": "positive"}), "$.score is required")
+	assert.EqualError(t, schema.Validate(map[string]interface{}{"sentiment": "great", "score": 4.0}), "$.sentiment must be one of [positive negative]")
+	assert.EqualError(t, schema.Validate(map[string]interface{}{"sentiment": "positive", "score": 4.5}), "$.score must be an integer")
+	assert.EqualError(t, schema.Validate(map[string]interface{}{"sentiment": "positive", "score": 4.0, "topics": []interface{}{1.0}}), "$.topics[0] must be a string")
+	assert.EqualError(t, schema.Validate(map[string]interface{}{"sentiment": "positive", "score": 4.0, "mood": "good"}), "$.mood is not allowed")
+	assert.NoError(t, schema.Validate(map[string]interface{}{"sentiment": "positive", "score": 4.0, "summary": nil}))
+	assert.EqualError(t, schema.Validate(map[string]interface{}{"sentiment": "positive", "score": 4.0, "summary": 1.0}), "$.summary must be a string")
+}
+
+type audit struct {
+	Author string `json:"author"`
+	Note   string `json:"note"`
+}
+
+type attachment struct {
+	*audit
+	review
+	Note  string  `json:"note,omitempty"`
+	Data  []byte  `json:"data"`
+	Label *string `json:"label" enum:"a,b"`
+}
+
+func TestJSONSchemaFollowsEncodingJSON(t *testing.T) {
+	schema, err := JSONSchema(attachment{})
+	require.NoError(t, err)
+	properties := schema["properties"].(Schema)
+	assert.ElementsMatch(t, []string{"author", "note", "sentiment", "score", "topics", "summary", "data", "label"}, keys(properties))
+	assert.Equal(t, Schema{"type": "string"}, properties["note"], "the outer field wins")
+	assert.Equal(t, Schema{"type": "string", "description": "Base64 encoded bytes"}, properties["data"])
+	assert.Equal(t, Schema{"type": []string{"string", "null"}, "enum": []interface{}{"a", "b", nil}}, properties["label"])
+	assert.Equal(t, []string{"data", "score", "sentiment"}, schema["required"], "fields of an embedded pointer are optional")
+
+	label := "a"
+	value := attachment{audit: &audit{Author: "ann"}, review: review{Sentiment: "positive", Score: 3}, Data: []byte("hi"), Label: &label}
+	encoded, err := json.Marshal(value)
+	require.NoError(t, err)
+	var decoded interface{}
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.NoError(t, schema.Validate(decoded))
+
+	value.Label = nil
+	encoded, err = json.Marshal(value)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.NoError(t, schema.Validate(decoded))
+}
+
+func keys(schema Schema) []string {
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestSendStructuredRepromptsWithValidationError(t *testing.T) {
+	llm := &scriptedLlm{answers: []string{
+		`{"sentiment": "great", "score": 5}`,
+		"```json\n{\"sentiment\": \"positive\", \"score\": 5, \"topics\": [\"food\"]}\n```",
+	}}
+	c := &Client{Client: llm, Store: newTestStore(t)}
+
+	result, err := SendStructured[review](c, "Review: the food was great", "reviews", nil)
+	require.NoError(t, err)
+	assert.Equal(t, review{Sentiment: "positive", Score: 5, Topics: []string{"food"}}, result)
+
+	require.Len(t, llm.formats, 2)
+	assert.Equal(t, ResponseFormatJSONSchema, llm.formats[0].Type)
+	assert.Equal(t, "response", llm.formats[0].Name)
+	assert.Contains(t, llm.contexts[0][0], `"required":["score","sentiment"]`)
+	retry := llm.received[1]
+	require.Len(t, retry, 3)
+	assert.Equal(t, `{"sentiment": "great", "score": 5}`, retry[1].Content)
+	assert.Contains(t, retry[2].Content, "$.sentiment must be one of [positive negative]")
+
+	history, err := c.Store.GetMessagesByContextID("reviews")
+	require.NoError(t, err)
+	require.Len(t, history, 2, "failed attempts are not stored")
+	assert.Equal(t, `{"sentiment": "positive", "score": 5, "topics": ["food"]}`, history[1].Content)
+}
+
+func TestSendStructuredGivesUp(t *testing.T) {
+	llm := &scriptedLlm{answers: []string{"not JSON", `{"sentiment": "positive"}`}}
+	c := &Client{Client: llm, Store: newTestStore(t)}
+
+	_, err := SendStructured[review](c, "Review: meh", "reviews", &StructuredOptions{MaxRetries: 1})
+	var structuredErr *StructuredError
+	require.ErrorAs(t, err, &structuredErr)
+	assert.Equal(t, `{"sentiment": "positive"}`, structuredErr.Answer)
+	assert.EqualError(t, structuredErr.Err, "$.score is required")
+
+	history, err := c.Store.GetMessagesByContextID("reviews")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestFormatters(t *testing.T) {
+	utc := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	image := db.NewImageURLPart("https://example.com/cat.png", "")
+	history := []db.Message{
+		db.CreateNewMessage(db.UserRoleName, "Hi", "ctx"),
+		db.CreateNewMultipartMessage(db.UserRoleName, "ctx", image, db.NewTextPart("What is this?")),
+	}
+	history[0].Timestamp = utc.Add(-2 * time.Hour)
+	history[1].Timestamp = utc
+
+	messages, context := NoFormatter{}.Format(history, []string{"be brief"})
+	assert.Equal(t, history, messages)
+	assert.Equal(t, []string{"be brief"}, context)
+
+	messages, _ = (&TimestampPrefixFormatter{}).Format(history, nil)
+	assert.Equal(t, "2024-03-01 07:30:00: Hi", messages[0].Content)
+	assert.Equal(t, "2024-03-01 09:30:00: What is this?", messages[1].Parts[1].Text)
+	assert.Equal(t, "What is this?", history[1].Parts[1].Text, "the original messages are not changed")
+
+	berlin := time.FixedZone("CET", 3600)
+	messages, _ = NewZonedTimestampPrefixFormatter(berlin).Format(history, nil)
+	assert.Equal(t, "2024-03-01 08:30:00 CET: Hi", messages[0].Content)
+
+	note := &TimeNoteFormatter{Location: berlin, Now: func() time.Time { return utc.Add(time.Minute) }}
+	messages, context = note.Format(history, []string{"be brief"})
+	assert.Equal(t, history, messages)
+	assert.Equal(t, []string{"be brief", "The current time is 2024-03-01 10:31:00 CET. The previous message was sent 2h1m0s ago. The conversation started 2h1m0s ago."}, context)
+}
+
+func TestFormatterIsAppliedToSentMessagesOnly(t *testing.T) {
+	llm := &fakeLlm{}
+	c := &Client{Client: llm, Store: newTestStore(t), Formatter: &TimestampPrefixFormatter{Layout: "15:04"}}
+
+	_, err := c.SendMessage("Hello", "ctx")
+	require.NoError(t, err)
+	sent := llm.received[0]
+	assert.Regexp(t, `^\d\d:\d\d: Hello$`, sent[len(sent)-1].Content)
+
+	history, err := c.Store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "Hello", history[0].Content)
+}
+
+type optionsLlm struct {
+	fakeLlm
+	options []*RequestOptions
+}
+
+func (o *optionsLlm) SendMessagesWithOptions(messages []db.Message, context []string, options *RequestOptions) ([]db.Message, error) {
+	if err := options.Check("fake", nil, OptionTemperature, OptionStop); err != nil {
+		return nil, err
+	}
+	o.options = append(o.options, options)
+	return o.SendMessages(messages, context)
+}
+
+func TestRequestOptions(t *testing.T) {
+	temperature := 0.2
+	seed := 7
+	options := &RequestOptions{Temperature: &temperature, Stop: []string{"\n"}}
+	assert.Equal(t, []string{OptionTemperature, OptionStop}, options.Names())
+	assert.Empty(t, (*RequestOptions)(nil).Names())
+
+	llm := &optionsLlm{}
+	c := &Client{Client: llm, Store: newTestStore(t)}
+	answer, err := c.SendMessageWithOptions("Hello", "ctx", options)
+	require.NoError(t, err)
+	assert.Equal(t, "echo: Hello", answer)
+	assert.Equal(t, []*RequestOptions{options}, llm.options)
+
+	_, err = c.SendMessageWithOptions("Hello", "ctx", &RequestOptions{Temperature: &temperature, Seed: &seed, User: "u1"})
+	assert.EqualError(t, err, "fake does not support the options seed, user")
+	var unsupported *UnsupportedOptionsError
+	require.ErrorAs(t, err, &unsupported)
+	assert.Equal(t, []string{OptionSeed, OptionUser}, unsupported.Options)
+
+	chunks := make([]string, 0)
+	_, err = c.StreamMessageWithOptions("Hi", "ctx", &RequestOptions{Seed: &seed, IgnoreUnsupported: true}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"echo: Hi"}, chunks)
+}
+
+func TestRequestOptionsWithoutProviderSupport(t *testing.T) {
+	temperature := 0.2
+	c := &Client{Client: &fakeLlm{}, Store: newTestStore(t)}
+	_, err := c.SendMessageWithOptions("Hello", "ctx", &RequestOptions{Temperature: &temperature})
+	assert.EqualError(t, err, "*client.fakeLlm does not support the options temperature")
+
+	answer, err := c.SendMessageWithOptions("Hello", "ctx", &RequestOptions{Temperature: &temperature, IgnoreUnsupported: true})
+	require.NoError(t, err)
+	assert.Equal(t, "echo: Hello", answer)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// APIError is an error response of a provider API.
+type APIError struct {
+	// Provider names the API in the error message, e.g. "Gemini".
+	Provider   string
+	StatusCode int
+	Message    string
+	// Retryable is set when the same request may succeed later or with
+	// another provider: rate limits, timeouts and server errors.
+	Retryable bool
+}
+
+func NewAPIError(provider string, statusCode int, message string) *APIError {
+	return &APIError{
+		Provider:   provider,
+		StatusCode: statusCode,
+		Message:    message,
+		Retryable: statusCode == http.StatusRequestTimeout ||
+			statusCode == http.StatusTooManyRequests ||
+			statusCode >= http.StatusInternalServerError,
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error response from %s (%d): %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// IsRetryable reports whether err is a retryable APIError or a network error,
+// where the provider could not be reached at all.
+func IsRetryable(err error) bool {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.Retryable
+	}
+	var netError net.Error
+	return errors.As(err, &netError)
+}
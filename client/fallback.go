@@ -0,0 +1,272 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultFailureThreshold = 3
+const defaultOpenTimeout = 30 * time.Second
+
+// FallbackProvider is one provider of a FallbackClient.
+type FallbackProvider struct {
+	// Name identifies the provider in the health report and in the metadata
+	// of answers whose provider does not set one itself.
+	Name   string
+	Client LllmChatClient
+}
+
+// FallbackClient sends messages to the first available provider and moves on
+// to the next one when a provider fails with a retryable error (see
+// IsRetryable). Other errors, such as an invalid request, are returned right
+// away.
+//
+// Every provider has a circuit breaker: after FailureThreshold retryable
+// failures in a row it opens and the provider is skipped. Once OpenTimeout
+// has passed one request is let through, its success closes the circuit and
+// its failure opens it again.
+type FallbackClient struct {
+	Providers []FallbackProvider
+	// FailureThreshold defaults to 3 failures.
+	FailureThreshold int
+	// OpenTimeout defaults to 30 seconds.
+	OpenTimeout time.Duration
+	Logger      *logrus.Logger
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+	now      func() time.Time
+}
+
+func NewFallbackClient(providers ...FallbackProvider) *FallbackClient {
+	return &FallbackClient{Providers: providers}
+}
+
+// CircuitState is the state of the circuit breaker of a provider.
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+type ProviderHealth struct {
+	Name                string
+	State               CircuitState
+	ConsecutiveFailures int
+	LastError           error
+	OpenedAt            time.Time
+}
+
+type circuitBreaker struct {
+	state    CircuitState
+	failures int
+	lastErr  error
+	openedAt time.Time
+	// trial is set while the one request of a half-open circuit is running.
+	trial bool
+}
+
+func (f *FallbackClient) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return f.send(func(provider LllmChatClient) (bool, []db.Message, error) {
+		answers, err := provider.SendMessages(messages, context)
+		return false, answers, err
+	})
+}
+
+// SendMessagesWithFormat passes the format to providers with a JSON mode.
+func (f *FallbackClient) SendMessagesWithFormat(messages []db.Message, context []string, format *ResponseFormat) ([]db.Message, error) {
+	return f.send(func(provider LllmChatClient) (bool, []db.Message, error) {
+		answers, err := SendMessagesWithFormat(provider, messages, context, format)
+		return false, answers, err
+	})
+}
+
+// SendMessagesWithOptions passes the options to every provider it tries, a
+// provider that does not support them fails the request unless
+// IgnoreUnsupported is set.
+func (f *FallbackClient) SendMessagesWithOptions(messages []db.Message, context []string, options *RequestOptions) ([]db.Message, error) {
+	return f.send(func(provider LllmChatClient) (bool, []db.Message, error) {
+		answers, err := optionsFunc(provider, options, f.Logger)(messages, context)
+		return false, answers, err
+	})
+}
+
+func (f *FallbackClient) StreamMessagesWithOptions(messages []db.Message, context []string, options *RequestOptions, onChunk func(chunk string) error) ([]db.Message, error) {
+	return f.send(func(provider LllmChatClient) (bool, []db.Message, error) {
+		streamed := false
+		answers, err := streamOptionsFunc(provider, options, f.Logger, func(chunk string) error {
+			streamed = true
+			return onChunk(chunk)
+		})(messages, context)
+		return streamed, answers, err
+	})
+}
+
+// StreamMessages streams from the first available provider. Once a provider
+// has delivered a chunk its failure is returned, as the caller has already
+// shown part of its answer.
+func (f *FallbackClient) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	return f.send(func(provider LllmChatClient) (bool, []db.Message, error) {
+		streamed := false
+		answers, err := streamFunc(provider, func(chunk string) error {
+			streamed = true
+			return onChunk(chunk)
+		})(messages, context)
+		return streamed, answers, err
+	})
+}
+
+// send calls attempt with each available provider until one succeeds.
+// attempt reports whether a failure has already reached the caller.
+func (f *FallbackClient) send(attempt func(provider LllmChatClient) (bool, []db.Message, error)) ([]db.Message, error) {
+	if len(f.Providers) == 0 {
+		return nil, errors.New("fallback client has no providers")
+	}
+	failures := make([]string, 0, len(f.Providers))
+	for _, provider := range f.Providers {
+		if !f.allow(provider.Name) {
+			failures = append(failures, fmt.Sprintf("%s: circuit open", provider.Name))
+			continue
+		}
+		delivered, answers, err := attempt(provider.Client)
+		if err == nil {
+			f.recordSuccess(provider.Name)
+			if len(answers) > 0 && answers[len(answers)-1].Metadata.Provider == "" {
+				answers[len(answers)-1].Metadata.Provider = provider.Name
+			}
+			return answers, nil
+		}
+		if !IsRetryable(err) {
+			f.release(provider.Name)
+			return nil, err
+		}
+		f.recordFailure(provider.Name, err)
+		if delivered {
+			return nil, err
+		}
+		if f.Logger != nil {
+			f.Logger.WithFields(logrus.Fields{
+				"provider": provider.Name,
+				"error":    err,
+			}).Warn("Provider failed, trying the next one")
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", provider.Name, err))
+	}
+	return nil, fmt.Errorf("all providers failed: %s", strings.Join(failures, "; "))
+}
+
+// Health returns the circuit state of every provider.
+func (f *FallbackClient) Health() []ProviderHealth {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	health := make([]ProviderHealth, 0, len(f.Providers))
+	for _, provider := range f.Providers {
+		b := f.breaker(provider.Name)
+		health = append(health, ProviderHealth{
+			Name:                provider.Name,
+			State:               f.state(b),
+			ConsecutiveFailures: b.failures,
+			LastError:           b.lastErr,
+			OpenedAt:            b.openedAt,
+		})
+	}
+	return health
+}
+
+// allow reports whether a request may be sent to the provider. An open
+// circuit turns half-open after OpenTimeout and lets one request through.
+func (f *FallbackClient) allow(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.breaker(name)
+	b.state = f.state(b)
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+func (f *FallbackClient) recordSuccess(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.breaker(name)
+	b.state = CircuitClosed
+	b.failures = 0
+	b.lastErr = nil
+	b.trial = false
+}
+
+func (f *FallbackClient) recordFailure(name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.breaker(name)
+	b.failures++
+	b.lastErr = err
+	if b.trial || b.failures >= f.failureThreshold() {
+		b.state = CircuitOpen
+		b.openedAt = f.currentTime()
+	}
+	b.trial = false
+}
+
+// release ends a half-open trial that failed for reasons unrelated to the
+// health of the provider.
+func (f *FallbackClient) release(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.breaker(name).trial = false
+}
+
+func (f *FallbackClient) state(b *circuitBreaker) CircuitState {
+	if b.state == CircuitOpen && f.currentTime().Sub(b.openedAt) >= f.openTimeout() {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+func (f *FallbackClient) breaker(name string) *circuitBreaker {
+	if f.breakers == nil {
+		f.breakers = make(map[string]*circuitBreaker)
+	}
+	b, ok := f.breakers[name]
+	if !ok {
+		b = &circuitBreaker{state: CircuitClosed}
+		f.breakers[name] = b
+	}
+	return b
+}
+
+func (f *FallbackClient) failureThreshold() int {
+	if f.FailureThreshold <= 0 {
+		return defaultFailureThreshold
+	}
+	return f.FailureThreshold
+}
+
+func (f *FallbackClient) openTimeout() time.Duration {
+	if f.OpenTimeout <= 0 {
+		return defaultOpenTimeout
+	}
+	return f.OpenTimeout
+}
+
+func (f *FallbackClient) currentTime() time.Time {
+	if f.now == nil {
+		return time.Now()
+	}
+	return f.now()
+}
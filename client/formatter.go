@@ -0,0 +1,109 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// MessageFormatter rewrites the history and system context of a turn before
+// they are sent to the provider. The stored messages are not changed.
+type MessageFormatter interface {
+	Format(messages []db.Message, context []string) ([]db.Message, []string)
+}
+
+// NoFormatter sends messages as they are, it is what a Client without a
+// Formatter does.
+type NoFormatter struct{}
+
+func (NoFormatter) Format(messages []db.Message, context []string) ([]db.Message, []string) {
+	return messages, context
+}
+
+const DefaultTimestampLayout = "2006-01-02 15:04:05"
+
+// TimestampPrefixFormatter writes the time of every message in front of its
+// content, e.g. "2023-07-01 10:00:00: Hello". Messages with parts get the
+// time in front of their first text part.
+type TimestampPrefixFormatter struct {
+	// Layout is DefaultTimestampLayout when empty.
+	Layout string
+	// Location converts the times, they are written as stored when nil.
+	Location *time.Location
+}
+
+// NewTimestampPrefixFormatter writes the local time of messages.
+func NewTimestampPrefixFormatter() *TimestampPrefixFormatter {
+	return &TimestampPrefixFormatter{Location: time.Local}
+}
+
+// NewZonedTimestampPrefixFormatter writes the time of messages in location
+// together with the name of the zone, for users who are not in the zone of
+// the server.
+func NewZonedTimestampPrefixFormatter(location *time.Location) *TimestampPrefixFormatter {
+	return &TimestampPrefixFormatter{Layout: DefaultTimestampLayout + " MST", Location: location}
+}
+
+func (f *TimestampPrefixFormatter) Format(messages []db.Message, context []string) ([]db.Message, []string) {
+	formatted := make([]db.Message, len(messages))
+	for i, message := range messages {
+		prefix := f.timestamp(message.Timestamp) + ": "
+		message.Content = prefix + message.Content
+		if len(message.Parts) > 0 {
+			parts := make([]db.ContentPart, len(message.Parts))
+			copy(parts, message.Parts)
+			for j := range parts {
+				if parts[j].Type == db.ContentPartText {
+					parts[j].Text = prefix + parts[j].Text
+					break
+				}
+			}
+			message.Parts = parts
+		}
+		formatted[i] = message
+	}
+	return formatted, context
+}
+
+func (f *TimestampPrefixFormatter) timestamp(t time.Time) string {
+	layout := f.Layout
+	if layout == "" {
+		layout = DefaultTimestampLayout
+	}
+	if f.Location != nil {
+		t = t.In(f.Location)
+	}
+	return t.Format(layout)
+}
+
+// TimeNoteFormatter leaves the messages alone and tells the model the
+// current time and how long ago the conversation was last active in a note
+// in the system context.
+type TimeNoteFormatter struct {
+	// Location of the current time, time.Local when nil.
+	Location *time.Location
+	// Now is time.Now when nil.
+	Now func() time.Time
+}
+
+func (f *TimeNoteFormatter) Format(messages []db.Message, context []string) ([]db.Message, []string) {
+	now := time.Now
+	if f.Now != nil {
+		now = f.Now
+	}
+	location := f.Location
+	if location == nil {
+		location = time.Local
+	}
+	current := now()
+	note := fmt.Sprintf("The current time is %s.", current.In(location).Format(DefaultTimestampLayout+" MST"))
+	// The last message is the one being sent, the one before it shows how
+	// long the conversation has been idle.
+	if len(messages) > 1 {
+		previous := messages[len(messages)-2].Timestamp
+		note += fmt.Sprintf(" The previous message was sent %s ago.", current.Sub(previous).Round(time.Minute))
+		note += fmt.Sprintf(" The conversation started %s ago.", current.Sub(messages[0].Timestamp).Round(time.Minute))
+	}
+	return messages, append(context[:len(context):len(context)], note)
+}
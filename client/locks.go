@@ -0,0 +1,43 @@
+package client
+
+import "sync"
+
+// contextLocks hands out one mutex per context id. Mutexes are dropped once
+// nobody holds or waits for them, so the map does not grow with every context
+// the client has ever seen.
+type contextLocks struct {
+	mu    sync.Mutex
+	locks map[string]*contextLock
+}
+
+type contextLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until the context is free and returns the function that
+// releases it.
+func (l *contextLocks) lock(contextId string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*contextLock)
+	}
+	cl, ok := l.locks[contextId]
+	if !ok {
+		cl = &contextLock{}
+		l.locks[contextId] = cl
+	}
+	cl.refs++
+	l.mu.Unlock()
+
+	cl.Lock()
+	return func() {
+		cl.Unlock()
+		l.mu.Lock()
+		cl.refs--
+		if cl.refs == 0 {
+			delete(l.locks, contextId)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/sirupsen/logrus"
+)
+
+// Names of the request options, providers list the ones they support.
+const (
+	OptionTemperature      = "temperature"
+	OptionTopP             = "top_p"
+	OptionTopK             = "top_k"
+	OptionMaxTokens        = "max_tokens"
+	OptionStop             = "stop"
+	OptionSeed             = "seed"
+	OptionPresencePenalty  = "presence_penalty"
+	OptionFrequencyPenalty = "frequency_penalty"
+	OptionLogitBias        = "logit_bias"
+	OptionUser             = "user"
+)
+
+// RequestOptions are the generation settings of one request. Nil and zero
+// fields are left to the provider and the settings of the client.
+type RequestOptions struct {
+	Temperature *float64
+	TopP        *float64
+	TopK        *int
+	MaxTokens   int
+	Stop        []string
+	Seed        *int
+	// PresencePenalty and FrequencyPenalty discourage repeating topics and
+	// words, from -2 to 2 on OpenAI.
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	// LogitBias maps token ids of the model to a bias from -100 to 100.
+	LogitBias map[string]int
+	// User identifies the end user to the provider, e.g. for abuse
+	// monitoring.
+	User string
+	// IgnoreUnsupported logs a warning about options the provider does not
+	// support instead of failing the request.
+	IgnoreUnsupported bool
+}
+
+// OptionsLllmChatClient is implemented by providers that take per-request
+// options.
+type OptionsLllmChatClient interface {
+	LllmChatClient
+	SendMessagesWithOptions(messages []db.Message, context []string, options *RequestOptions) ([]db.Message, error)
+}
+
+// StreamingOptionsLllmChatClient is implemented by providers that can stream
+// with per-request options.
+type StreamingOptionsLllmChatClient interface {
+	OptionsLllmChatClient
+	StreamMessagesWithOptions(messages []db.Message, context []string, options *RequestOptions, onChunk func(chunk string) error) ([]db.Message, error)
+}
+
+// UnsupportedOptionsError is returned for options a provider cannot apply.
+type UnsupportedOptionsError struct {
+	Provider string
+	Options  []string
+}
+
+func (e *UnsupportedOptionsError) Error() string {
+	return fmt.Sprintf("%s does not support the options %s", e.Provider, strings.Join(e.Options, ", "))
+}
+
+// Names returns the names of the options that are set.
+func (o *RequestOptions) Names() []string {
+	names := make([]string, 0)
+	if o == nil {
+		return names
+	}
+	add := func(name string, set bool) {
+		if set {
+			names = append(names, name)
+		}
+	}
+	add(OptionTemperature, o.Temperature != nil)
+	add(OptionTopP, o.TopP != nil)
+	add(OptionTopK, o.TopK != nil)
+	add(OptionMaxTokens, o.MaxTokens > 0)
+	add(OptionStop, len(o.Stop) > 0)
+	add(OptionSeed, o.Seed != nil)
+	add(OptionPresencePenalty, o.PresencePenalty != nil)
+	add(OptionFrequencyPenalty, o.FrequencyPenalty != nil)
+	add(OptionLogitBias, len(o.LogitBias) > 0)
+	add(OptionUser, o.User != "")
+	return names
+}
+
+// Check reports the options that are set but not supported by a provider,
+// as an UnsupportedOptionsError or, with IgnoreUnsupported, as a warning.
+func (o *RequestOptions) Check(provider string, logger *logrus.Logger, supported ...string) error {
+	unsupported := make([]string, 0)
+	for _, name := range o.Names() {
+		found := false
+		for _, s := range supported {
+			if s == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	err := &UnsupportedOptionsError{Provider: provider, Options: unsupported}
+	if !o.IgnoreUnsupported {
+		return err
+	}
+	if logger != nil {
+		logger.WithFields(logrus.Fields{
+			"provider": provider,
+			"options":  unsupported,
+		}).Warn("Ignoring unsupported request options")
+	}
+	return nil
+}
+
+// SendMessageWithOptions sends a message like SendMessage with generation
+// settings for this request only.
+func (c *Client) SendMessageWithOptions(message string, inputContextId string, options *RequestOptions) (string, error) {
+	_, answerMessage, err := c.sendTurn(newRequest(message), inputContextId, c.ContextDepth, true, c.optionsFunc(options))
+	if err != nil {
+		return "", err
+	}
+	return answerMessage.Content, nil
+}
+
+// StreamMessageWithOptions streams the answer like StreamMessage with
+// generation settings for this request only.
+func (c *Client) StreamMessageWithOptions(message string, inputContextId string, options *RequestOptions, onChunk func(chunk string) error) (string, error) {
+	_, answerMessage, err := c.sendTurn(newRequest(message), inputContextId, c.ContextDepth, true, c.streamOptionsFunc(options, onChunk))
+	if err != nil {
+		return "", err
+	}
+	return answerMessage.Content, nil
+}
+
+// SendMessagesWithOptions sends messages to a provider with options, for
+// callers that keep the history themselves. Options of providers without
+// options support are checked like those of SendMessageWithOptions.
+func SendMessagesWithOptions(provider LllmChatClient, messages []db.Message, context []string, options *RequestOptions, logger *logrus.Logger) ([]db.Message, error) {
+	return optionsFunc(provider, options, logger)(messages, context)
+}
+
+// StreamMessagesWithOptions streams the answer of a provider like
+// SendMessagesWithOptions sends it.
+func StreamMessagesWithOptions(provider LllmChatClient, messages []db.Message, context []string, options *RequestOptions, logger *logrus.Logger, onChunk func(chunk string) error) ([]db.Message, error) {
+	return streamOptionsFunc(provider, options, logger, onChunk)(messages, context)
+}
+
+func (c *Client) optionsFunc(options *RequestOptions) sendFunc {
+	return optionsFunc(c.Client, options, c.Logger)
+}
+
+func (c *Client) streamOptionsFunc(options *RequestOptions, onChunk func(chunk string) error) sendFunc {
+	return streamOptionsFunc(c.Client, options, c.Logger, onChunk)
+}
+
+// optionsFunc sends with options, a provider without options support only
+// gets requests without options unless they may be ignored.
+func optionsFunc(provider LllmChatClient, options *RequestOptions, logger *logrus.Logger) sendFunc {
+	return func(messages []db.Message, context []string) ([]db.Message, error) {
+		if withOptions, ok := provider.(OptionsLllmChatClient); ok {
+			return withOptions.SendMessagesWithOptions(messages, context, options)
+		}
+		if err := options.Check(fmt.Sprintf("%T", provider), logger); err != nil {
+			return nil, err
+		}
+		return provider.SendMessages(messages, context)
+	}
+}
+
+func streamOptionsFunc(provider LllmChatClient, options *RequestOptions, logger *logrus.Logger, onChunk func(chunk string) error) sendFunc {
+	return func(messages []db.Message, context []string) ([]db.Message, error) {
+		if streaming, ok := provider.(StreamingOptionsLllmChatClient); ok {
+			return streaming.StreamMessagesWithOptions(messages, context, options, onChunk)
+		}
+		if _, ok := provider.(StreamingLllmChatClient); ok {
+			if err := options.Check(fmt.Sprintf("%T", provider), logger); err != nil {
+				return nil, err
+			}
+			return streamFunc(provider, onChunk)(messages, context)
+		}
+		answers, err := optionsFunc(provider, options, logger)(messages, context)
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) > 0 {
+			if err := onChunk(answers[len(answers)-1].Content); err != nil {
+				return nil, err
+			}
+		}
+		return answers, nil
+	}
+}
@@ -0,0 +1,380 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON schema as it is sent to the providers.
+type Schema map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+var bytesType = reflect.TypeOf([]byte{})
+
+// JSONSchema generates the schema of the JSON encoding of v, a struct or a
+// pointer to one. Field names follow the json tags and the fields of
+// embedded structs are promoted as encoding/json does. Fields tagged
+// omitempty and pointers are optional, pointers may also be null, every
+// other field is required. The tags `description:"..."` and `enum:"a,b,c"`
+// describe a field to the model.
+func JSONSchema(v interface{}) (Schema, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("unable to generate schema of nil")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return typeSchema(t, map[reflect.Type]bool{})
+}
+
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) (Schema, error) {
+	switch {
+	case t == timeType:
+		return Schema{"type": "string", "format": "date-time"}, nil
+	case t == rawMessageType:
+		return Schema{}, nil
+	case t == bytesType || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8):
+		return Schema{"type": "string", "description": "Base64 encoded bytes"}, nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), seen)
+	case reflect.Bool:
+		return Schema{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}, nil
+	case reflect.String:
+		return Schema{"type": "string"}, nil
+	case reflect.Interface:
+		return Schema{}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := typeSchema(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return Schema{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := typeSchema(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return Schema{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		return structSchema(t, seen)
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func structSchema(t reflect.Type, seen map[reflect.Type]bool) (Schema, error) {
+	if seen[t] {
+		return nil, fmt.Errorf("recursive type %s is not supported", t)
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	fields, err := structFields(t, seen, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	properties := Schema{}
+	required := make([]string, 0)
+	for _, field := range dominantFields(fields) {
+		properties[field.name] = field.schema
+		if !field.optional {
+			required = append(required, field.name)
+		}
+	}
+	sort.Strings(required)
+	return Schema{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+// schemaField is a property of a struct schema, depth is how deeply it is
+// embedded.
+type schemaField struct {
+	name     string
+	schema   Schema
+	optional bool
+	tagged   bool
+	depth    int
+}
+
+// structFields lists the properties of t and of the structs it embeds. The
+// fields of an embedded pointer are optional, it may be nil.
+func structFields(t reflect.Type, seen map[reflect.Type]bool, depth int, optional bool) ([]schemaField, error) {
+	fields := make([]schemaField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, tagged := field.Tag.Lookup("json")
+		options := strings.Split(tag, ",")
+		if options[0] == "-" {
+			continue
+		}
+		name := options[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct && embedded != timeType {
+				if seen[embedded] {
+					return nil, fmt.Errorf("recursive type %s is not supported", embedded)
+				}
+				seen[embedded] = true
+				promoted, err := structFields(embedded, seen, depth+1, optional || field.Type.Kind() == reflect.Ptr)
+				delete(seen, embedded)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, promoted...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		pointer := field.Type.Kind() == reflect.Ptr
+		schemaField := schemaField{name: name, optional: optional || pointer, tagged: tagged && options[0] != "", depth: depth}
+		for _, option := range options[1:] {
+			schemaField.optional = schemaField.optional || option == "omitempty"
+		}
+		schema, err := typeSchema(field.Type, seen)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			values := make([]interface{}, 0)
+			for _, value := range strings.Split(enum, ",") {
+				values = append(values, value)
+			}
+			schema["enum"] = values
+		}
+		if pointer {
+			schema = nullable(schema)
+		}
+		schemaField.schema = schema
+		fields = append(fields, schemaField)
+	}
+	return fields, nil
+}
+
+// dominantFields resolves fields with the same name like encoding/json: the
+// least embedded one wins, among equally embedded ones the only tagged one,
+// otherwise none of them.
+func dominantFields(fields []schemaField) []schemaField {
+	byName := make(map[string][]schemaField)
+	names := make([]string, 0)
+	for _, field := range fields {
+		if _, ok := byName[field.name]; !ok {
+			names = append(names, field.name)
+		}
+		byName[field.name] = append(byName[field.name], field)
+	}
+	dominant := make([]schemaField, 0, len(names))
+	for _, name := range names {
+		candidates := byName[name]
+		depth := candidates[0].depth
+		for _, field := range candidates {
+			if field.depth < depth {
+				depth = field.depth
+			}
+		}
+		shallowest := make([]schemaField, 0)
+		tagged := make([]schemaField, 0)
+		for _, field := range candidates {
+			if field.depth != depth {
+				continue
+			}
+			shallowest = append(shallowest, field)
+			if field.tagged {
+				tagged = append(tagged, field)
+			}
+		}
+		switch {
+		case len(shallowest) == 1:
+			dominant = append(dominant, shallowest[0])
+		case len(tagged) == 1:
+			dominant = append(dominant, tagged[0])
+		}
+	}
+	return dominant
+}
+
+// nullable lets a schema also accept null, which is how encoding/json
+// writes a nil pointer.
+func nullable(schema Schema) Schema {
+	if typeName, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typeName, "null"}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		schema["enum"] = append(enum, nil)
+	}
+	return schema
+}
+
+// Validate checks a decoded JSON value against the schema. It supports what
+// JSONSchema generates: types, properties, required, additionalProperties,
+// items and enum.
+func (s Schema) Validate(value interface{}) error {
+	return validate(s, value, "$")
+}
+
+func validate(schema map[string]interface{}, value interface{}, path string) error {
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %v", path, enum)
+		}
+	}
+	if _, single := schema["type"].(string); !single && schema["type"] != nil {
+		// A list of types such as ["string", "null"], the value has to match
+		// one of them.
+		var firstErr error
+		for _, typeName := range stringList(schema["type"]) {
+			alternative := make(map[string]interface{}, len(schema))
+			for key, value := range schema {
+				alternative[key] = value
+			}
+			alternative["type"] = typeName
+			err := validate(alternative, value, path)
+			if err == nil {
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "null":
+		if value != nil {
+			return fmt.Errorf("%s must be null", path)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		return validateObject(schema, object, path)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		if items, ok := asSchema(schema["items"]); ok {
+			for i, item := range array {
+				if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s must be a number", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	default:
+		return fmt.Errorf("%s has unsupported schema type %v", path, schema["type"])
+	}
+	return nil
+}
+
+func validateObject(schema map[string]interface{}, object map[string]interface{}, path string) error {
+	properties, _ := asSchema(schema["properties"])
+	for _, name := range stringList(schema["required"]) {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s.%s is required", path, name)
+		}
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := asSchema(properties[name])
+		if !ok {
+			if additional, ok := asSchema(schema["additionalProperties"]); ok {
+				property = additional
+			} else if schema["additionalProperties"] == false {
+				return fmt.Errorf("%s.%s is not allowed", path, name)
+			} else {
+				continue
+			}
+		}
+		if err := validate(property, object[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// asSchema accepts both Schema and the plain maps of a decoded schema.
+func asSchema(value interface{}) (map[string]interface{}, bool) {
+	switch schema := value.(type) {
+	case Schema:
+		return schema, true
+	case map[string]interface{}:
+		return schema, true
+	}
+	return nil, false
+}
+
+func stringList(value interface{}) []string {
+	switch list := value.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			if str, ok := item.(string); ok {
+				strs = append(strs, str)
+			}
+		}
+		return strs
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// StreamMessage sends a message like SendMessage and calls onChunk with parts
+// of the answer as they arrive. Providers that cannot stream deliver the
+// whole answer as one chunk. The turn is stored once the answer is complete.
+func (c *Client) StreamMessage(message string, inputContextId string, onChunk func(chunk string) error) (string, error) {
+	_, answerMessage, err := c.sendTurn(newRequest(message), inputContextId, c.ContextDepth, true, c.streamFunc(onChunk))
+	if err != nil {
+		return "", err
+	}
+	return answerMessage.Content, nil
+}
+
+func (c *Client) streamFunc(onChunk func(chunk string) error) sendFunc {
+	return streamFunc(c.Client, onChunk)
+}
+
+// StreamMessages streams the answer of a provider, for wrappers that pass
+// streaming on. Providers that cannot stream deliver the whole answer as one
+// chunk.
+func StreamMessages(provider LllmChatClient, messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	if streaming, ok := provider.(StreamingLllmChatClient); ok {
+		return streaming.StreamMessages(messages, context, onChunk)
+	}
+	answers, err := provider.SendMessages(messages, context)
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) > 0 {
+		if err := onChunk(answers[len(answers)-1].Content); err != nil {
+			return nil, err
+		}
+	}
+	return answers, nil
+}
+
+func streamFunc(provider LllmChatClient, onChunk func(chunk string) error) sendFunc {
+	return func(messages []db.Message, context []string) ([]db.Message, error) {
+		return StreamMessages(provider, messages, context, onChunk)
+	}
+}
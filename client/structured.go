@@ -0,0 +1,203 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ResponseFormatJSONObject asks for any valid JSON object.
+	ResponseFormatJSONObject = "json_object"
+	// ResponseFormatJSONSchema asks for JSON that matches a schema.
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat asks a provider for JSON answers.
+type ResponseFormat struct {
+	Type string
+	// Name and Schema describe the answer for ResponseFormatJSONSchema.
+	Name   string
+	Schema Schema
+	// Strict makes providers that support it follow the schema exactly,
+	// OpenAI then needs every property to be required.
+	Strict bool
+}
+
+// FormattingLllmChatClient is implemented by providers with a JSON mode.
+// Other providers get the schema as instructions only.
+type FormattingLllmChatClient interface {
+	LllmChatClient
+	SendMessagesWithFormat(messages []db.Message, context []string, format *ResponseFormat) ([]db.Message, error)
+}
+
+// StructuredOptions configures SendStructured, the zero value sends the
+// schema of T and re-prompts twice.
+type StructuredOptions struct {
+	// Type is ResponseFormatJSONSchema when empty.
+	Type string
+	// Name of the schema, "response" when empty.
+	Name   string
+	Strict bool
+	// MaxRetries is how many times an invalid answer is sent back with the
+	// validation error, DefaultStructuredRetries when zero and no retries
+	// when negative.
+	MaxRetries int
+}
+
+const DefaultStructuredRetries = 2
+
+// StructuredError is returned when no answer of the model was valid.
+type StructuredError struct {
+	// Answer is the last answer of the model.
+	Answer string
+	Err    error
+}
+
+func (e *StructuredError) Error() string {
+	return fmt.Sprintf("model did not answer with valid JSON: %v", e.Err)
+}
+
+func (e *StructuredError) Unwrap() error {
+	return e.Err
+}
+
+// SendStructured sends a message like SendMessage and decodes the answer
+// into T. The JSON schema of T is sent to the provider, answers that do not
+// match it are sent back to the model with the validation error. Only the
+// message and the valid answer are stored.
+func SendStructured[T any](c *Client, message string, inputContextId string, options *StructuredOptions) (T, error) {
+	var result T
+	if options == nil {
+		options = &StructuredOptions{}
+	}
+	format, err := structuredFormat(result, options)
+	if err != nil {
+		return result, err
+	}
+	attempts := 1 + options.MaxRetries
+	if options.MaxRetries == 0 {
+		attempts = 1 + DefaultStructuredRetries
+	} else if options.MaxRetries < 0 {
+		attempts = 1
+	}
+
+	send := func(messages []db.Message, context []string) ([]db.Message, error) {
+		context = append(context[:len(context):len(context)], formatInstructions(format))
+		var validationErr error
+		var answer db.Message
+		for attempt := 1; attempt <= attempts; attempt++ {
+			conversation := messages
+			if validationErr != nil {
+				correction := fmt.Sprintf("Your answer is not valid: %v. Answer again with the corrected JSON only.", validationErr)
+				conversation = append(append(messages[:len(messages):len(messages)], answer),
+					db.CreateNewMessage(db.UserRoleName, correction, messages[0].ContextId))
+			}
+			answers, err := SendMessagesWithFormat(c.Client, conversation, context, format)
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) == 0 {
+				return nil, errors.New("model returned no messages")
+			}
+			answer = answers[len(answers)-1]
+			answer.Content = extractJSON(answer.Content)
+			var decoded T
+			if validationErr = decodeStructured(answer.Content, format, &decoded); validationErr == nil {
+				result = decoded
+				return append(messages, answer), nil
+			}
+			if c.Logger != nil {
+				c.Logger.WithFields(logrus.Fields{
+					"attempt": attempt,
+					"error":   validationErr,
+				}).Debug("Invalid structured answer")
+			}
+		}
+		return nil, &StructuredError{Answer: answer.Content, Err: validationErr}
+	}
+
+	if _, _, err := c.sendTurn(newRequest(message), inputContextId, c.ContextDepth, true, send); err != nil {
+		var empty T
+		return empty, err
+	}
+	return result, nil
+}
+
+func structuredFormat(v interface{}, options *StructuredOptions) (*ResponseFormat, error) {
+	format := &ResponseFormat{
+		Type:   options.Type,
+		Name:   options.Name,
+		Strict: options.Strict,
+	}
+	if format.Type == "" {
+		format.Type = ResponseFormatJSONSchema
+	}
+	if format.Name == "" {
+		format.Name = "response"
+	}
+	if format.Type == ResponseFormatJSONSchema {
+		schema, err := JSONSchema(v)
+		if err != nil {
+			return nil, err
+		}
+		format.Schema = schema
+	}
+	return format, nil
+}
+
+// SendMessagesWithFormat sends messages to a provider with a response format,
+// for wrappers that pass the format on. Providers without a JSON mode get the
+// messages only and depend on the format instructions in the context.
+func SendMessagesWithFormat(provider LllmChatClient, messages []db.Message, context []string, format *ResponseFormat) ([]db.Message, error) {
+	if formatting, ok := provider.(FormattingLllmChatClient); ok {
+		return formatting.SendMessagesWithFormat(messages, context, format)
+	}
+	return provider.SendMessages(messages, context)
+}
+
+// formatInstructions tells the model about the format in the system
+// context, providers without a JSON mode depend on it and OpenAI requires
+// JSON to be mentioned.
+func formatInstructions(format *ResponseFormat) string {
+	if format.Schema == nil {
+		return "Answer with a JSON object only."
+	}
+	schema, _ := json.Marshal(format.Schema)
+	return "Answer with JSON only that matches this JSON schema: " + string(schema)
+}
+
+func decodeStructured(content string, format *ResponseFormat, result interface{}) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	if format.Schema != nil {
+		if err := format.Schema.Validate(value); err != nil {
+			return err
+		}
+	} else if _, ok := value.(map[string]interface{}); !ok {
+		return errors.New("answer is not a JSON object")
+	}
+	if err := json.Unmarshal([]byte(content), result); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// extractJSON removes a Markdown code fence around the answer.
+func extractJSON(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	if newline := strings.IndexByte(content, '\n'); newline >= 0 {
+		content = content[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/prompt"
+	"github.com/sirupsen/logrus"
+)
+
+// SendTemplateMessage renders a template from the registry (version 0 is the
+// latest) and sends it as the user message. The template version and the
+// variables are recorded next to the stored message when the store supports
+// it.
+func (c *Client) SendTemplateMessage(registry *prompt.Registry, name string, version int, values map[string]interface{}, inputContextId string) (string, error) {
+	rendered, err := registry.Render(name, version, values)
+	if err != nil {
+		return "", err
+	}
+	request, answerMessage, err := c.sendTurn(newRequest(rendered.Text), inputContextId, c.ContextDepth, true, c.Client.SendMessages)
+	if err != nil {
+		return "", err
+	}
+	if err := c.recordTemplateUsage(rendered, request.ID, request.ContextId); err != nil && c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"template":  rendered.TemplateName,
+			"messageId": request.ID,
+			"error":     err,
+		}).Warn("Unable to record template usage")
+	}
+	return answerMessage.Content, nil
+}
+
+// SetTemplateSystemPrompt renders a template and stores it as the system
+// prompt of the context, see db.UpdateContext.
+func (c *Client) SetTemplateSystemPrompt(registry *prompt.Registry, name string, version int, values map[string]interface{}, contextId string) error {
+	rendered, err := registry.Render(name, version, values)
+	if err != nil {
+		return err
+	}
+	store, err := c.store()
+	if err != nil {
+		return err
+	}
+	if err := store.UpdateContext(contextId, rendered.Text); err != nil {
+		return err
+	}
+	return c.recordTemplateUsage(rendered, "", contextId)
+}
+
+func (c *Client) recordTemplateUsage(rendered prompt.Rendered, messageId string, contextId string) error {
+	store, err := c.store()
+	if err != nil {
+		return err
+	}
+	templateStore, ok := store.(db.TemplateStore)
+	if !ok {
+		return errors.New("store does not support templates")
+	}
+	usage, err := rendered.Usage(messageId, contextId)
+	if err != nil {
+		return err
+	}
+	return templateStore.RecordTemplateUsage(usage)
+}
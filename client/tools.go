@@ -0,0 +1,64 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// Tool describes a function the model can call, Parameters is the JSON
+// schema of its arguments.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  Schema
+}
+
+// ToolLllmChatClient is implemented by providers that call tools natively.
+// When the model wants to call tools the answer has db.Message.ToolCalls,
+// the results are sent back in messages with db.ToolRoleName.
+type ToolLllmChatClient interface {
+	LllmChatClient
+	SendMessagesWithTools(messages []db.Message, context []string, tools []Tool) ([]db.Message, error)
+}
+
+// Unwrapper is implemented by clients that wrap a provider, such as caches.
+// They implement ToolLllmChatClient whatever they wrap, SupportsTools asks
+// the provider they wrap instead.
+type Unwrapper interface {
+	Unwrap() LllmChatClient
+}
+
+// SupportsTools reports whether provider, or the provider a wrapper wraps,
+// calls tools natively.
+func SupportsTools(provider LllmChatClient) bool {
+	if _, ok := provider.(ToolLllmChatClient); !ok {
+		return false
+	}
+	if wrapper, ok := provider.(Unwrapper); ok {
+		return SupportsTools(wrapper.Unwrap())
+	}
+	return true
+}
+
+// SendMessagesWithTools sends messages with tools to a provider, for
+// wrappers that pass the tools on.
+func SendMessagesWithTools(provider LllmChatClient, messages []db.Message, context []string, tools []Tool) ([]db.Message, error) {
+	withTools, ok := provider.(ToolLllmChatClient)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support native tool calls", provider)
+	}
+	return withTools.SendMessagesWithTools(messages, context, tools)
+}
+
+// SendTurn sends a message like SendMessage but leaves talking to the
+// provider to send, e.g. over several requests. send gets the history ending
+// with the message and the system context, the last message it returns is
+// stored as the answer.
+func (c *Client) SendTurn(message string, inputContextId string, send func(messages []db.Message, context []string) ([]db.Message, error)) (string, error) {
+	_, answerMessage, err := c.sendTurn(newRequest(message), inputContextId, c.ContextDepth, true, send)
+	if err != nil {
+		return "", err
+	}
+	return answerMessage.Content, nil
+}
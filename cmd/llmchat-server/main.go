@@ -0,0 +1,140 @@
+// Command llmchat-server serves models of any provider of this library with
+// the OpenAI chat completions protocol.
+//
+//	llmchat-server -model openai:gpt-4o -model local=ollama:llama3 \
+//		-fallback safe=openai:gpt-4o,vertex:chat-bison -api-key secret -sqlite conversations.db
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/registry"
+	"github.com/assistant-ai/llmchat-client/server"
+	"github.com/sirupsen/logrus"
+)
+
+// listFlag collects the values of a flag that can be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	var models, fallbacks, apiKeys listFlag
+	flag.Var(&models, "model", "model to serve as `[name=]provider:model`, the spec is the name when none is given; can be repeated")
+	flag.Var(&fallbacks, "fallback", "fallback chain to serve as `name=provider:model,provider:model`; can be repeated")
+	flag.Var(&apiKeys, "api-key", "API key clients must send, LLMCHAT_SERVER_API_KEYS takes a comma-separated list; can be repeated")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	sqlitePath := flag.String("sqlite", "", "SQLite file to store conversations of requests with the "+server.ConversationHeader+" header")
+	postgresDSN := flag.String("postgres", "", "PostgreSQL connection string to store conversations in instead of SQLite")
+	projectId := flag.String("project", "", "Google Cloud project for Vertex AI models, GOOGLE_CLOUD_PROJECT when empty")
+	location := flag.String("location", "", "Vertex AI region")
+	debug := flag.Bool("debug", false, "log requests")
+	flag.Parse()
+
+	logger := logrus.New()
+	if *debug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	if err := run(*addr, models, fallbacks, apiKeys, *sqlitePath, *postgresDSN, registry.Options{
+		ProjectId: *projectId,
+		Location:  *location,
+		Logger:    logger,
+	}, logger); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(addr string, models []string, fallbacks []string, apiKeys []string, sqlitePath string, postgresDSN string, options registry.Options, logger *logrus.Logger) error {
+	s := server.New()
+	s.Logger = logger
+	for _, model := range models {
+		name, spec, found := strings.Cut(model, "=")
+		if !found {
+			spec = name
+		}
+		c, err := registry.NewClient(spec, options)
+		if err != nil {
+			return err
+		}
+		provider, _, _ := registry.ParseSpec(spec)
+		s.Models = append(s.Models, server.Model{Name: name, Client: c.Client, OwnedBy: provider})
+	}
+	for _, fallback := range fallbacks {
+		name, specs, found := strings.Cut(fallback, "=")
+		if !found {
+			return fmt.Errorf("fallback %q needs a name, e.g. name=openai:gpt-4o,vertex:chat-bison", fallback)
+		}
+		c, err := registry.NewFallbackClient(strings.Split(specs, ","), options)
+		if err != nil {
+			return err
+		}
+		s.Models = append(s.Models, server.Model{Name: name, Client: c.Client, OwnedBy: "fallback"})
+	}
+	if len(s.Models) == 0 {
+		return errors.New("no models, add them with -model or -fallback")
+	}
+
+	s.APIKeys = apiKeys
+	for _, key := range strings.Split(os.Getenv("LLMCHAT_SERVER_API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			s.APIKeys = append(s.APIKeys, key)
+		}
+	}
+	if len(s.APIKeys) == 0 {
+		logger.Warn("No API keys configured, every client is accepted")
+	}
+
+	switch {
+	case postgresDSN != "":
+		store, err := db.NewPostgresStore(postgresDSN, db.DefaultPoolOptions)
+		if err != nil {
+			return fmt.Errorf("unable to connect to PostgreSQL: %v", err)
+		}
+		defer store.Close()
+		s.Store = store
+	case sqlitePath != "":
+		store, err := db.NewSQLiteStoreWithOptions(sqlitePath, db.DefaultSQLiteOptions)
+		if err != nil {
+			return fmt.Errorf("unable to open %s: %v", sqlitePath, err)
+		}
+		defer store.Close()
+		s.Store = store
+	}
+
+	httpServer := &http.Server{Addr: addr, Handler: s.Handler()}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Requests in flight are finished before the store is closed.
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	logger.WithFields(logrus.Fields{"addr": addr, "models": len(s.Models)}).Info("Serving chat completions")
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-shutdown
+	return nil
+}
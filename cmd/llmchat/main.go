@@ -0,0 +1,134 @@
+// Command llmchat chats with any model of the registry in the terminal.
+// Conversations are kept in the default SQLite store of the db package.
+//
+//	llmchat -model anthropic:claude-3-5-sonnet-20240620
+//	git diff | llmchat -model openai:gpt-4o "Write a commit message for this diff"
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/registry"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultModel = "openai:gpt-4o"
+
+func main() {
+	model := flag.String("model", "", "model as `provider:model`, LLMCHAT_MODEL or "+defaultModel+" when empty")
+	contextId := flag.String("context", "", "context to chat in, a new one when empty")
+	system := flag.String("system", "", "system prompt of the context")
+	noStream := flag.Bool("no-stream", false, "print answers once they are complete")
+	depth := flag.Int("depth", 10, "number of earlier messages sent with every message")
+	debug := flag.Bool("debug", false, "log requests")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [prompt]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Without a prompt and with a terminal on stdin llmchat starts a chat, type /help there.")
+		fmt.Fprintln(flag.CommandLine.Output(), "A prompt or piped input is answered once, the prompt comes before the input.")
+		fmt.Fprintln(flag.CommandLine.Output())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	if *debug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	spec := *model
+	if spec == "" {
+		spec = os.Getenv("LLMCHAT_MODEL")
+	}
+	if spec == "" {
+		spec = defaultModel
+	}
+	newClient := func(spec string) (*client.Client, error) {
+		return registry.NewClient(spec, registry.Options{ContextDepth: *depth, Logger: logger})
+	}
+	c, err := newClient(spec)
+	if err != nil {
+		fail(err)
+	}
+
+	r := &repl{
+		client:    c,
+		spec:      spec,
+		newClient: newClient,
+		contextId: *contextId,
+		stream:    !*noStream,
+		in:        bufio.NewReader(os.Stdin),
+		out:       os.Stdout,
+	}
+	prompt := strings.Join(flag.Args(), " ")
+	interactive := prompt == "" && isTerminal(os.Stdin)
+	if r.contextId == "" && !interactive {
+		// Pipelines do not share history unless they name a context, their
+		// system prompt must then not be stored with the random context.
+		r.contextId = db.RandomContextId
+		c.DefaultContext = *system
+	} else {
+		if r.contextId == "" {
+			r.contextId = "chat-" + time.Now().Format("20060102-150405")
+		}
+		if *system != "" {
+			if err := setSystemPrompt(r.contextId, *system); err != nil {
+				fail(err)
+			}
+		}
+	}
+	if !interactive {
+		if err := oneShot(r, prompt); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if err := r.run(); err != nil {
+		fail(err)
+	}
+}
+
+// oneShot answers the prompt followed by what is piped to stdin.
+func oneShot(r *repl, prompt string) error {
+	if !isTerminal(os.Stdin) {
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		if text := strings.TrimSpace(string(input)); text != "" {
+			prompt = strings.TrimSpace(prompt + "\n\n" + text)
+		}
+	}
+	if prompt == "" {
+		return fmt.Errorf("no prompt")
+	}
+	return r.send(prompt)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func setSystemPrompt(contextId string, prompt string) error {
+	exists, err := db.CheckIfContextExists(contextId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return db.CreateContext(contextId, prompt)
+	}
+	return db.UpdateContext(contextId, prompt)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "llmchat:", err)
+	os.Exit(1)
+}
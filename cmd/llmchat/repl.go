@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/registry"
+)
+
+const helpText = `Type a message and press Enter. End a line with \ to continue on the next
+one, or write several lines between two lines of """. !! sends your last
+message again and !n the nth message you typed in this session.
+
+/context [id]    show the context or switch to another one
+/list            list the contexts
+/rm <id>         remove a context and its messages
+/system [text]   show or set the system prompt of the context
+/history [n]     show the last n messages of the conversation, 10 by default
+/inputs          list the messages you typed in this session for !n
+/model [spec]    show or switch the model, e.g. /model anthropic:claude-3-opus-20240229
+/models [provider] list the known models, of one provider if given
+/stream on|off   print answers as they are written or once they are complete
+/help            show this help
+/exit            quit, as does Ctrl-D`
+
+// repl is the interactive chat.
+type repl struct {
+	client    *client.Client
+	spec      string
+	newClient func(spec string) (*client.Client, error)
+	contextId string
+	stream    bool
+	// inputs are the messages typed in this session, for !! and !n.
+	inputs []string
+	in     *bufio.Reader
+	out    io.Writer
+}
+
+func (r *repl) run() error {
+	fmt.Fprintf(r.out, "Chatting with %s in context %s, /help lists the commands.\n", r.spec, r.contextId)
+	for {
+		input, err := r.read()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		input = strings.TrimSpace(input)
+		if strings.HasPrefix(input, "/") {
+			quit, err := r.command(input)
+			if err != nil {
+				fmt.Fprintln(r.out, "error:", err)
+			}
+			if quit {
+				return nil
+			}
+		} else if input != "" {
+			if err := r.message(input); err != nil {
+				fmt.Fprintln(r.out, "error:", err)
+			}
+		}
+		if err != nil {
+			if input == "" {
+				fmt.Fprintln(r.out)
+			}
+			return nil
+		}
+	}
+}
+
+// read reads one message, which can span several lines.
+func (r *repl) read() (string, error) {
+	fmt.Fprint(r.out, "> ")
+	lines := make([]string, 0)
+	block := false
+	for {
+		line, err := r.in.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.TrimSpace(line) == `"""`:
+			if block {
+				return strings.Join(lines, "\n"), err
+			}
+			block = true
+		case block:
+			lines = append(lines, line)
+		case strings.HasSuffix(line, `\`):
+			lines = append(lines, strings.TrimSuffix(line, `\`))
+		default:
+			return strings.Join(append(lines, line), "\n"), err
+		}
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+		fmt.Fprint(r.out, ". ")
+	}
+}
+
+// message sends a typed message, or the earlier one !! or !n recalls.
+func (r *repl) message(input string) error {
+	message, err := r.recall(input)
+	if err != nil {
+		return err
+	}
+	if message != input {
+		fmt.Fprintln(r.out, message)
+	}
+	r.inputs = append(r.inputs, message)
+	return r.send(message)
+}
+
+// recall returns the message !! or !n refers to and any other input as it
+// is.
+func (r *repl) recall(input string) (string, error) {
+	if input == "!!" {
+		if len(r.inputs) == 0 {
+			return "", errors.New("no message to repeat yet")
+		}
+		return r.inputs[len(r.inputs)-1], nil
+	}
+	if !strings.HasPrefix(input, "!") {
+		return input, nil
+	}
+	n, err := strconv.Atoi(input[1:])
+	if err != nil {
+		return input, nil
+	}
+	if n <= 0 || n > len(r.inputs) {
+		return "", fmt.Errorf("there is no message %d, /inputs lists them", n)
+	}
+	return r.inputs[n-1], nil
+}
+
+func (r *repl) send(message string) error {
+	if !r.stream {
+		answer, err := r.client.SendMessage(message, r.contextId)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.out, answer)
+		return nil
+	}
+	_, err := r.client.StreamMessage(message, r.contextId, func(chunk string) error {
+		_, err := io.WriteString(r.out, chunk)
+		return err
+	})
+	fmt.Fprintln(r.out)
+	return err
+}
+
+// command runs a slash command and reports whether to quit.
+func (r *repl) command(input string) (bool, error) {
+	name, argument, _ := strings.Cut(input, " ")
+	argument = strings.TrimSpace(argument)
+	switch name {
+	case "/exit", "/quit":
+		return true, nil
+	case "/help":
+		fmt.Fprintln(r.out, helpText)
+	case "/context":
+		if argument != "" {
+			r.contextId = argument
+		}
+		fmt.Fprintln(r.out, "Context:", r.contextId)
+	case "/list":
+		return false, r.list()
+	case "/rm":
+		if argument == "" {
+			return false, errors.New("usage: /rm <context id>")
+		}
+		if err := db.RemoveContext(argument); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(r.out, "Removed", argument)
+	case "/system":
+		return false, r.system(argument)
+	case "/history":
+		return false, r.history(argument)
+	case "/inputs":
+		for i, input := range r.inputs {
+			fmt.Fprintf(r.out, "%d: %s\n", i+1, input)
+		}
+	case "/model":
+		return false, r.model(argument)
+	case "/models":
+		for _, model := range registry.Models(argument) {
+			fmt.Fprintln(r.out, model.Spec())
+		}
+	case "/stream":
+		switch argument {
+		case "on":
+			r.stream = true
+		case "off":
+			r.stream = false
+		default:
+			return false, errors.New("usage: /stream on|off")
+		}
+	default:
+		return false, fmt.Errorf("unknown command %s, /help lists the commands", name)
+	}
+	return false, nil
+}
+
+func (r *repl) list() error {
+	contextIds, err := db.GetContextIDs()
+	if err != nil {
+		return err
+	}
+	for _, contextId := range contextIds {
+		marker := " "
+		if contextId == r.contextId {
+			marker = "*"
+		}
+		fmt.Fprintln(r.out, marker, contextId)
+	}
+	return nil
+}
+
+func (r *repl) system(prompt string) error {
+	if prompt == "" {
+		exists, err := db.CheckIfContextExists(r.contextId)
+		if err != nil || !exists {
+			return err
+		}
+		current, err := db.GetContextMessage(r.contextId)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.out, current)
+		return nil
+	}
+	if err := setSystemPrompt(r.contextId, prompt); err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, "System prompt updated")
+	return nil
+}
+
+func (r *repl) history(argument string) error {
+	count := 10
+	if argument != "" {
+		n, err := strconv.Atoi(argument)
+		if err != nil || n <= 0 {
+			return errors.New("usage: /history [number of messages]")
+		}
+		count = n
+	}
+	messages, err := db.GetLastMessagesByContextID(r.contextId, count)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		fmt.Fprintf(r.out, "[%s] %s: %s\n", message.Timestamp.Local().Format("2006-01-02 15:04"), message.Role, message.Content)
+	}
+	return nil
+}
+
+func (r *repl) model(spec string) error {
+	if spec == "" {
+		fmt.Fprintln(r.out, "Model:", r.spec)
+		return nil
+	}
+	c, err := r.newClient(spec)
+	if err != nil {
+		return err
+	}
+	r.client = c
+	r.spec = spec
+	fmt.Fprintln(r.out, "Model:", spec)
+	return nil
+}
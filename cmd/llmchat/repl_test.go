@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoLlm repeats the last message and records the requests.
+type echoLlm struct {
+	requests [][]db.Message
+}
+
+func (e *echoLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	e.requests = append(e.requests, messages)
+	last := messages[len(messages)-1]
+	return append(messages, db.CreateNewMessage(db.AssistentRoleNeam, last.Content, last.ContextId)), nil
+}
+
+func newTestRepl(input string) (*repl, *strings.Builder) {
+	out := &strings.Builder{}
+	return &repl{
+		spec:      "openai:gpt-4o",
+		contextId: "test",
+		in:        bufio.NewReader(strings.NewReader(input)),
+		out:       out,
+	}, out
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{name: "line", input: "hello\nnext\n", want: "hello"},
+		{name: "continued lines", input: "first \\\nsecond\\\nthird\nnext\n", want: "first \nsecond\nthird"},
+		{name: "block", input: "\"\"\"\nline 1\n\n  line 2\n\"\"\"\nnext\n", want: "line 1\n\n  line 2"},
+		{name: "unterminated block", input: "\"\"\"\nline 1\nline 2", want: "line 1\nline 2", err: io.EOF},
+		{name: "last line without newline", input: "hello", want: "hello", err: io.EOF},
+		{name: "windows line endings", input: "hello\r\n", want: "hello"},
+		{name: "end of input", input: "", want: "", err: io.EOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := newTestRepl(test.input)
+			message, err := r.read()
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.want, message)
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		quit   bool
+		output string
+		err    string
+		check  func(t *testing.T, r *repl)
+	}{
+		{name: "exit", input: "/exit", quit: true},
+		{name: "quit", input: "/quit", quit: true},
+		{name: "help", input: "/help", output: helpText + "\n"},
+		{name: "show context", input: "/context", output: "Context: test\n"},
+		{name: "switch context", input: "/context  travel ", output: "Context: travel\n", check: func(t *testing.T, r *repl) {
+			assert.Equal(t, "travel", r.contextId)
+		}},
+		{name: "rm needs an id", input: "/rm", err: "usage: /rm <context id>"},
+		{name: "history needs a number", input: "/history many", err: "usage: /history [number of messages]"},
+		{name: "stream off", input: "/stream off", check: func(t *testing.T, r *repl) {
+			assert.False(t, r.stream)
+		}},
+		{name: "stream needs on or off", input: "/stream maybe", err: "usage: /stream on|off"},
+		{name: "show model", input: "/model", output: "Model: openai:gpt-4o\n"},
+		{name: "switch model", input: "/model anthropic:claude-3-opus-20240229", output: "Model: anthropic:claude-3-opus-20240229\n", check: func(t *testing.T, r *repl) {
+			assert.Equal(t, "anthropic:claude-3-opus-20240229", r.spec)
+			assert.NotNil(t, r.client)
+		}},
+		{name: "unknown model", input: "/model nobody:nothing", err: "unknown model", check: func(t *testing.T, r *repl) {
+			assert.Equal(t, "openai:gpt-4o", r.spec)
+		}},
+		{name: "inputs", input: "/inputs", output: "1: hello\n2: again\n"},
+		{name: "unknown command", input: "/nope", err: "unknown command /nope, /help lists the commands"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, out := newTestRepl("")
+			r.stream = true
+			r.inputs = []string{"hello", "again"}
+			r.newClient = func(spec string) (*client.Client, error) {
+				if spec == "nobody:nothing" {
+					return nil, errors.New("unknown model")
+				}
+				return &client.Client{}, nil
+			}
+			quit, err := r.command(test.input)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.quit, quit)
+			assert.Equal(t, test.output, out.String())
+			if test.check != nil {
+				test.check(t, r)
+			}
+		})
+	}
+}
+
+func TestRecallSendsEarlierMessages(t *testing.T) {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+	llm := &echoLlm{}
+	r, out := newTestRepl("hi\n!!\n!5\n!1\n!exclamation\n/inputs\n/exit\n")
+	r.client = &client.Client{Client: llm, ContextDepth: 10, Store: store}
+
+	require.NoError(t, r.run())
+	require.Len(t, llm.requests, 4)
+	for i, want := range []string{"hi", "hi", "hi", "!exclamation"} {
+		assert.Equal(t, want, llm.requests[i][len(llm.requests[i])-1].Content)
+	}
+	assert.Contains(t, out.String(), "error: there is no message 5, /inputs lists them\n")
+	assert.Contains(t, out.String(), "1: hi\n2: hi\n3: hi\n4: !exclamation\n")
+}
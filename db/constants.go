@@ -4,3 +4,7 @@ const RandomContextId = "random"
 const UserRoleName = "user"
 const SystemRoleName = "system"
 const AssistentRoleNeam = "assistant"
+const ToolRoleName = "tool"
+
+const MessageStatusOK = "ok"
+const MessageStatusFailed = "failed"
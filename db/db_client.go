@@ -1,91 +1,62 @@
 package db
 
 import (
-	"database/sql"
-	"log"
 	"path/filepath"
-
-	"github.com/google/uuid"
+	"sync"
 
 	"github.com/b0noi/go-utils/v2/fs"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const DefaultContextID = "defaultUserContext"
 
-var db *sql.DB
-
-func init() {
-	var err error
-	folderPath, err := fs.MaybeCreateProgramFolder("llmchat-client")
-	if err != nil {
-		log.Fatal(err)
-	}
-	dbFilePath := filepath.Join(folderPath, "messages.db")
-	db, err = sql.Open("sqlite3", dbFilePath)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	createMessagesTable := `CREATE TABLE IF NOT EXISTS messages (
-		id TEXT PRIMARY KEY,
-		context_id TEXT,
-		timestamp DATETIME,
-		role TEXT,
-		content TEXT
-	);`
-
-	_, err = db.Exec(createMessagesTable)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	createContextTable := `CREATE TABLE IF NOT EXISTS context (
-		context_id TEXT PRIMARY KEY,
-		context TEXT
-	);`
+var (
+	defaultStore    Store
+	defaultStoreErr error
+	defaultStoreMu  sync.Mutex
+)
 
-	_, err = db.Exec(createContextTable)
-	if err != nil {
-		log.Fatal(err)
+// DefaultStore returns the store used by the package level functions. Unless
+// SetDefaultStore was called it is a SQLite database in the llmchat-client
+// program folder, opened on first use.
+func DefaultStore() (Store, error) {
+	defaultStoreMu.Lock()
+	defer defaultStoreMu.Unlock()
+	if defaultStore == nil && defaultStoreErr == nil {
+		defaultStore, defaultStoreErr = openDefaultSQLiteStore()
 	}
+	return defaultStore, defaultStoreErr
 }
 
-func RemoveContext(contextId string) error {
-	if err := removeById(`DELETE FROM messages WHERE context_id = ?`, contextId); err != nil {
-		return err
-	}
-	if err := removeById(`DELETE FROM context WHERE context_id = ?`, contextId); err != nil {
-		return err
-	}
-	return nil
+// SetDefaultStore replaces the store used by the package level functions.
+func SetDefaultStore(s Store) {
+	defaultStoreMu.Lock()
+	defer defaultStoreMu.Unlock()
+	defaultStore = s
+	defaultStoreErr = nil
 }
 
-func removeById(query string, id string) error {
-	statement := query
-
-	result, err := db.Exec(statement, id)
+func openDefaultSQLiteStore() (Store, error) {
+	folderPath, err := fs.MaybeCreateProgramFolder("llmchat-client")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return NewSQLiteStore(filepath.Join(folderPath, "messages.db"))
+}
 
-	rowsAffected, err := result.RowsAffected()
+func RemoveContext(contextId string) error {
+	s, err := DefaultStore()
 	if err != nil {
 		return err
 	}
-
-	log.Printf("Removed %d objects with id %s", rowsAffected, id)
-	return nil
+	return s.RemoveContext(contextId)
 }
 
 func CheckIfContextExists(contextId string) (bool, error) {
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM context WHERE context_id=? LIMIT 1)", contextId).Scan(&exists)
+	s, err := DefaultStore()
 	if err != nil {
 		return false, err
 	}
-
-	return exists, nil
+	return s.CheckIfContextExists(contextId)
 }
 
 func CheckIfUserDefaultContextExists() (bool, error) {
@@ -101,162 +72,81 @@ func UpdateUserDefaultContext(context string) error {
 }
 
 func UpdateContext(contextId string, context string) error {
-	exist, err := CheckIfContextExists(contextId)
-	if err != nil {
-		return err
-	}
-	if !exist {
-		return CreateContext(contextId, context)
-	}
-	stmt, err := db.Prepare("UPDATE context SET context=? WHERE context_id=?")
+	s, err := DefaultStore()
 	if err != nil {
 		return err
 	}
-
-	_, err = stmt.Exec(context, contextId)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return s.UpdateContext(contextId, context)
 }
 
 func CreateContext(contextId string, context string) error {
-	stmt, err := db.Prepare("INSERT INTO context(context_id, context) VALUES(?, ?)")
+	s, err := DefaultStore()
 	if err != nil {
 		return err
 	}
-
-	_, err = stmt.Exec(contextId, context)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return s.CreateContext(contextId, context)
 }
 
 func StoreMessage(m Message) (string, error) {
-	context := m.ContextId
-	contextExists, err := CheckIfContextExists(context)
-	if err != nil {
-		return "", err
-	}
-	if !contextExists {
-		if err := CreateContext(context, ""); err != nil {
-			return "", err
-		}
-	}
-	if m.ID == "" {
-		m.ID = uuid.New().String()
-	}
-
-	stmt, err := db.Prepare("INSERT INTO messages(id, context_id, timestamp, role, content) VALUES(?, ?, ?, ?, ?)")
+	s, err := DefaultStore()
 	if err != nil {
 		return "", err
 	}
+	return s.StoreMessage(m)
+}
 
-	_, err = stmt.Exec(m.ID, m.ContextId, m.Timestamp, m.Role, m.Content)
+func StoreMessages(messages ...Message) error {
+	s, err := DefaultStore()
 	if err != nil {
-		return "", err
+		return err
 	}
-
-	return m.ID, nil
+	return s.StoreMessages(messages...)
 }
 
 func GetContextIDs() ([]string, error) {
-	rows, err := db.Query("SELECT context_id FROM context")
+	s, err := DefaultStore()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	contextIDs := []string{}
-	for rows.Next() {
-		var contextID string
-		err := rows.Scan(&contextID)
-		if err != nil {
-			return nil, err
-		}
-		contextIDs = append(contextIDs, contextID)
-	}
-
-	return contextIDs, nil
+	return s.GetContextIDs()
 }
 
 func GetMessageByID(id string) (Message, error) {
-	var m Message
-	err := db.QueryRow("SELECT id, context_id, timestamp, role, content FROM messages WHERE id=?", id).Scan(&m.ID, &m.ContextId, &m.Timestamp, &m.Role, &m.Content)
+	s, err := DefaultStore()
 	if err != nil {
 		return Message{}, err
 	}
-
-	return m, nil
+	return s.GetMessageByID(id)
 }
 
 func GetContextMessage(contextId string) (string, error) {
-	var m Message
-	m.Role = SystemRoleName
-	err := db.QueryRow("SELECT context_id, context FROM context WHERE context_id=?", contextId).Scan(&m.ContextId, &m.Content)
+	s, err := DefaultStore()
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			return "", nil
-		}
 		return "", err
 	}
-
-	return m.Content, nil
+	return s.GetContextMessage(contextId)
 }
 
 func DeleteMessageByID(id string) error {
-	stmt, err := db.Prepare("DELETE FROM messages WHERE id=?")
-	if err != nil {
-		return err
-	}
-
-	_, err = stmt.Exec(id)
+	s, err := DefaultStore()
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return s.DeleteMessageByID(id)
 }
 
 func GetLastMessagesByContextID(contextID string, count int) ([]Message, error) {
-	rows, err := db.Query("SELECT id, context_id, timestamp, role, content FROM messages WHERE context_id=? ORDER BY timestamp DESC LIMIT ?", contextID, count)
+	s, err := DefaultStore()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	messages := []Message{}
-	for rows.Next() {
-		var m Message
-		err := rows.Scan(&m.ID, &m.ContextId, &m.Timestamp, &m.Role, &m.Content)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, m)
-	}
-
-	return messages, nil
+	return s.GetLastMessagesByContextID(contextID, count)
 }
 
 func GetMessagesByContextID(contextID string) ([]Message, error) {
-	rows, err := db.Query("SELECT id, context_id, timestamp, role, content FROM messages WHERE context_id=? ORDER BY timestamp ASC", contextID)
+	s, err := DefaultStore()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	messages := []Message{}
-	for rows.Next() {
-		var m Message
-		err := rows.Scan(&m.ID, &m.ContextId, &m.Timestamp, &m.Role, &m.Content)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, m)
-	}
-
-	return messages, nil
+	return s.GetMessagesByContextID(contextID)
 }
@@ -1,9 +1,17 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetContextMessage(t *testing.T) {
@@ -32,7 +40,286 @@ func TestGetContextMessage(t *testing.T) {
 	}
 
 	// Assert context content
-	assert.Equal(t, contextID, message.ContextId, "Returned message has wrong context ID")
-	assert.Equal(t, SystemRoleName, message.Role, "Returned message has wrong role")
-	assert.Equal(t, expectedContext, message.Content, "Returned message has wrong content")
+	assert.Equal(t, expectedContext, message, "Returned message has wrong content")
+}
+
+func TestSQLiteStoreMigratesOldSchema(t *testing.T) {
+	dbFilePath := filepath.Join(t.TempDir(), "messages.db")
+	oldDb, err := sql.Open("sqlite3", dbFilePath)
+	require.NoError(t, err)
+	_, err = oldDb.Exec(`CREATE TABLE messages (id TEXT PRIMARY KEY, context_id TEXT, timestamp DATETIME, role TEXT, content TEXT);`)
+	require.NoError(t, err)
+	now := time.Now()
+	_, err = oldDb.Exec(`INSERT INTO messages VALUES ('old', 'ctx', ?, 'user', 'hello')`, now)
+	require.NoError(t, err)
+	_, err = oldDb.Exec(`INSERT INTO messages VALUES ('older', 'ctx', ?, 'user', 'hi')`, now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, oldDb.Close())
+
+	store, err := NewSQLiteStore(dbFilePath)
+	require.NoError(t, err)
+	defer store.Close()
+
+	m, err := store.GetMessageByID("old")
+	require.NoError(t, err)
+	assert.Equal(t, MessageStatusOK, m.Status)
+	assert.Equal(t, int64(2), m.Seq, "sequence numbers are backfilled by timestamp")
+
+	newMessage := CreateNewMessage(AssistentRoleNeam, "new", "ctx")
+	require.NoError(t, store.StoreMessages(newMessage))
+	history, err := store.GetLastMessagesByContextID("ctx", 10)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, []int64{1, 2, 3}, []int64{history[0].Seq, history[1].Seq, history[2].Seq})
+	assert.Equal(t, "older", history[0].ID)
+	assert.Equal(t, newMessage.ID, history[2].ID)
+
+	// Opening the migrated database again is a no-op.
+	again, err := NewSQLiteStore(dbFilePath)
+	require.NoError(t, err)
+	again.Close()
+}
+
+func TestMessagesAreOrderedBySequence(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	// Same timestamp for every message and one from a clock that is behind.
+	timestamp := time.Now()
+	messages := make([]Message, 0)
+	for _, content := range []string{"a", "b", "c", "d"} {
+		m := CreateNewMessage(UserRoleName, content, "ctx")
+		m.Timestamp = timestamp
+		messages = append(messages, m)
+	}
+	messages[3].Timestamp = timestamp.Add(-time.Hour)
+	require.NoError(t, store.StoreMessages(messages[0], messages[1]))
+	require.NoError(t, store.StoreMessages(messages[2]))
+	_, err = store.StoreMessage(messages[3])
+	require.NoError(t, err)
+
+	last, err := store.GetLastMessagesByContextID("ctx", 3)
+	require.NoError(t, err)
+	require.Len(t, last, 3)
+	assert.Equal(t, "b", last[0].Content)
+	assert.Equal(t, "c", last[1].Content)
+	assert.Equal(t, "d", last[2].Content)
+
+	all, err := store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, all, 4)
+	assert.Equal(t, "a", all[0].Content)
+	assert.Equal(t, "d", all[3].Content)
+}
+
+func TestResponseCache(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.GetCachedResponse("k1")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	now := time.Now()
+	require.NoError(t, store.PutCachedResponse(CachedResponse{Key: "k1", Value: "v1", ExpiresAt: now.Add(-time.Minute)}))
+	require.NoError(t, store.PutCachedResponse(CachedResponse{Key: "k2", Value: "v2"}))
+	require.NoError(t, store.PutCachedResponse(CachedResponse{Key: "k2", Value: "v2b", ExpiresAt: now.Add(time.Hour)}))
+
+	cached, err := store.GetCachedResponse("k2")
+	require.NoError(t, err)
+	assert.Equal(t, "v2b", cached.Value)
+	assert.WithinDuration(t, now.Add(time.Hour), cached.ExpiresAt, time.Second)
+
+	purged, err := store.PurgeExpiredResponses(now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = store.GetCachedResponse("k1")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	require.NoError(t, store.DeleteCachedResponse("k2"))
+	_, err = store.GetCachedResponse("k2")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func TestMultipartMessagesArePersisted(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	imagePath := filepath.Join(t.TempDir(), "cat.png")
+	require.NoError(t, os.WriteFile(imagePath, png, 0600))
+	image, err := NewImageFilePart(imagePath, ImageDetailLow)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", image.Image.MIMEType)
+	_, err = NewImageDataPart([]byte("just text"), ImageDetailAuto)
+	assert.Error(t, err)
+
+	message := CreateNewMultipartMessage(UserRoleName, "ctx",
+		NewTextPart("What is on these pictures?"), image, NewImageURLPart("https://example.com/dog.jpg", ""))
+	assert.Equal(t, "What is on these pictures?", message.Content)
+	assert.True(t, message.HasImages())
+	require.NoError(t, store.StoreMessages(message, CreateNewMessage(AssistentRoleNeam, "A cat and a dog.", "ctx")))
+
+	history, err := store.GetLastMessagesByContextID("ctx", 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, message.Parts, history[0].Parts)
+	assert.Equal(t, "data:image/png;base64,"+base64.StdEncoding.EncodeToString(png), history[0].Parts[1].Image.DataURL())
+	assert.Nil(t, history[1].Parts)
+}
+
+func TestVectorStore(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.UpsertVectors("docs",
+		Vector{ID: "a", Embedding: []float32{1, 0}, Content: "a", Metadata: map[string]string{"kind": "x"}},
+		Vector{ID: "b", Embedding: []float32{0.5, 0.5}, Content: "b"},
+	))
+	require.NoError(t, store.UpsertVectors("other", Vector{ID: "a", Embedding: []float32{0, 1}, Content: "other a"}))
+	require.NoError(t, store.UpsertVectors("docs", Vector{ID: "b", Embedding: []float32{0, -1}, Content: "new b"}))
+
+	v, err := store.GetVector("docs", "b")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0, -1}, v.Embedding)
+	assert.Equal(t, "new b", v.Content)
+	_, err = store.GetVector("docs", "c")
+	assert.ErrorIs(t, err, ErrVectorNotFound)
+
+	matches, err := store.SearchVectors("docs", []float32{1, 1}, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	assert.Equal(t, "a", matches[0].ID)
+	assert.InDelta(t, 0.7071, matches[0].Score, 1e-4)
+	assert.InDelta(t, -0.7071, matches[1].Score, 1e-4)
+
+	matches, err = store.SearchVectors("docs", []float32{1, 1}, 10, map[string]string{"kind": "y"})
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+	_, err = store.SearchVectors("docs", []float32{1, 1, 1}, 10, nil)
+	assert.EqualError(t, err, "vector a has 2 dimensions, the query has 3")
+
+	require.NoError(t, store.DeleteCollection("docs"))
+	matches, err = store.SearchVectors("docs", []float32{1, 1}, 10, nil)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+	_, err = store.GetVector("other", "a")
+	assert.NoError(t, err)
+}
+
+func TestContextCollections(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.AttachCollection("ctx", "manuals"))
+	require.NoError(t, store.AttachCollection("ctx", "faq"))
+	require.NoError(t, store.AttachCollection("ctx", "faq"))
+	require.NoError(t, store.AttachCollection("other", "faq"))
+	collections, err := store.GetContextCollections("ctx")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"faq", "manuals"}, collections)
+
+	require.NoError(t, store.DetachCollection("ctx", "manuals"))
+	collections, err = store.GetContextCollections("ctx")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"faq"}, collections)
+
+	require.NoError(t, store.CreateContext("ctx", "system"))
+	require.NoError(t, store.RemoveContext("ctx"))
+	collections, err = store.GetContextCollections("ctx")
+	require.NoError(t, err)
+	assert.Empty(t, collections)
+	collections, err = store.GetContextCollections("other")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"faq"}, collections)
+
+	require.NoError(t, store.UpsertVectors("docs",
+		Vector{ID: "a#0", Embedding: []float32{1}, Metadata: map[string]string{"document": "a"}},
+		Vector{ID: "a#1", Embedding: []float32{1}, Metadata: map[string]string{"document": "a"}},
+		Vector{ID: "b#0", Embedding: []float32{1}, Metadata: map[string]string{"document": "b"}},
+	))
+	require.NoError(t, store.DeleteVectorsByMetadata("docs", map[string]string{"document": "a"}))
+	matches, err := store.SearchVectors("docs", []float32{1}, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "b#0", matches[0].ID)
+}
+
+func TestAgentRuns(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.CreateRun(AgentRun{ID: "run", ContextId: "ctx", Goal: "weather?"}))
+	require.NoError(t, store.AddRunStep(AgentStep{RunId: "run", Seq: 1, Type: StepToolCall, Tool: "weather", CallId: "1", Arguments: `{"city":"Paris"}`}))
+	require.NoError(t, store.AddRunStep(AgentStep{RunId: "run", Seq: 2, Type: StepObservation, Tool: "weather", CallId: "1", Content: "sunny"}))
+
+	run, err := store.GetRun("run")
+	require.NoError(t, err)
+	assert.Equal(t, RunStatusRunning, run.Status)
+	assert.True(t, run.FinishedAt.IsZero())
+
+	require.NoError(t, store.FinishRun("run", RunStatusCompleted, "It is sunny.", ""))
+	run, err = store.GetRun("run")
+	require.NoError(t, err)
+	assert.Equal(t, RunStatusCompleted, run.Status)
+	assert.Equal(t, "It is sunny.", run.Answer)
+	assert.False(t, run.FinishedAt.IsZero())
+	assert.ErrorIs(t, store.FinishRun("missing", RunStatusFailed, "", "boom"), ErrRunNotFound)
+
+	steps, err := store.GetRunSteps("run")
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	assert.Equal(t, `{"city":"Paris"}`, steps[0].Arguments)
+	assert.Equal(t, "sunny", steps[1].Content)
+
+	runs, err := store.GetContextRuns("ctx")
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+
+	require.NoError(t, store.CreateContext("ctx", ""))
+	require.NoError(t, store.RemoveContext("ctx"))
+	_, err = store.GetRun("run")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+	steps, err = store.GetRunSteps("run")
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+}
+
+func TestConcurrentTemplateSaves(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+	testConcurrentTemplateSaves(t, store, "greeting")
+}
+
+// testConcurrentTemplateSaves saves a template from several goroutines at
+// once, every save must get its own consecutive version.
+func testConcurrentTemplateSaves(t *testing.T, store *SQLStore, name string) {
+	const saves = 8
+	versions := make([]int, saves)
+	errs := make([]error, saves)
+	var wg sync.WaitGroup
+	for i := 0; i < saves; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			saved, err := store.SaveTemplate(PromptTemplate{Name: name, Body: "Hello", Variables: "[]", Partials: "[]"})
+			versions[i], errs[i] = saved.Version, err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	sort.Ints(versions)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, versions)
+
+	stored, err := store.GetTemplateVersions(name)
+	require.NoError(t, err)
+	assert.Len(t, stored, saves)
 }
@@ -12,6 +12,57 @@ type Message struct {
 	Timestamp time.Time `json:"timestamp"`
 	Role      string    `json:"sender"`
 	Content   string    `json:"content"`
+	// Status is MessageStatusOK for regular messages and MessageStatusFailed
+	// for user messages whose request to the model failed.
+	Status string `json:"status"`
+	// Seq is the position of the message in its context, assigned by the
+	// store.
+	Seq int64 `json:"seq"`
+	// Metadata describes how an answer was produced, it is empty for user
+	// messages.
+	Metadata MessageMetadata `json:"metadata"`
+	// Parts are set for multi-part messages, e.g. text with images. Content
+	// then holds the text parts.
+	Parts []ContentPart `json:"parts,omitempty"`
+	// ToolCalls are the tools an answer asks to call. A message with
+	// ToolRoleName holds the result of the call with the id ToolCallId.
+	// Both are used within agent runs and are not stored with messages.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string     `json:"tool_call_id,omitempty"`
+}
+
+// ToolCall is a request of the model to call a tool, Arguments is JSON.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// MessageMetadata is what a provider reports about an answer.
+type MessageMetadata struct {
+	Provider         string `json:"provider,omitempty"`
+	Model            string `json:"model,omitempty"`
+	FinishReason     string `json:"finish_reason,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+	TotalTokens      int    `json:"total_tokens,omitempty"`
+	// Cached is set when the answer was taken from a response cache instead
+	// of the provider, the token counts are those of the original answer.
+	Cached bool `json:"cached,omitempty"`
+	// Citations are the document chunks that were given to the model for
+	// this answer.
+	Citations []Citation `json:"citations,omitempty"`
+	// Speaker is the name of the agent that wrote a message of a multi-agent
+	// conversation.
+	Speaker string `json:"speaker,omitempty"`
+}
+
+// Citation is a chunk of a document collection, see VectorStore.
+type Citation struct {
+	Collection string  `json:"collection"`
+	ID         string  `json:"id"`
+	Document   string  `json:"document,omitempty"`
+	Score      float64 `json:"score"`
 }
 
 func CreateNewMessage(role string, content string, contextId string) Message {
@@ -24,5 +75,6 @@ func CreateNewMessage(role string, content string, contextId string) Message {
 		Timestamp: time.Now(),
 		Role:      role,
 		Content:   content,
+		Status:    MessageStatusOK,
 	}
 }
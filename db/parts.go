@@ -0,0 +1,101 @@
+package db
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	ContentPartText  = "text"
+	ContentPartImage = "image"
+)
+
+// Detail levels of an image, they trade accuracy for tokens on providers
+// that support them.
+const (
+	ImageDetailAuto = "auto"
+	ImageDetailLow  = "low"
+	ImageDetailHigh = "high"
+)
+
+// ContentPart is one part of a multi-part message: text or an image.
+type ContentPart struct {
+	Type  string     `json:"type"`
+	Text  string     `json:"text,omitempty"`
+	Image *ImagePart `json:"image,omitempty"`
+}
+
+// ImagePart is an image given by URL or by its data. Data is stored with
+// the message, a URL only as a reference.
+type ImagePart struct {
+	URL      string `json:"url,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func NewTextPart(text string) ContentPart {
+	return ContentPart{Type: ContentPartText, Text: text}
+}
+
+func NewImageURLPart(url string, detail string) ContentPart {
+	return ContentPart{Type: ContentPartImage, Image: &ImagePart{URL: url, Detail: detail}}
+}
+
+// NewImageDataPart uses the image data, the MIME type is sniffed from it.
+func NewImageDataPart(data []byte, detail string) (ContentPart, error) {
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ContentPart{}, fmt.Errorf("data is not an image but %s", mimeType)
+	}
+	return ContentPart{Type: ContentPartImage, Image: &ImagePart{MIMEType: mimeType, Data: data, Detail: detail}}, nil
+}
+
+// NewImageFilePart reads a local image file.
+func NewImageFilePart(path string, detail string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, err
+	}
+	part, err := NewImageDataPart(data, detail)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return part, nil
+}
+
+// DataURL returns the image as a data: URL, or URL when the image has no
+// data.
+func (i *ImagePart) DataURL() string {
+	if len(i.Data) == 0 {
+		return i.URL
+	}
+	return "data:" + i.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(i.Data)
+}
+
+// CreateNewMultipartMessage creates a message from parts. Content is set to
+// the text parts, which is what providers without image support receive.
+func CreateNewMultipartMessage(role string, contextId string, parts ...ContentPart) Message {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == ContentPartText {
+			texts = append(texts, part.Text)
+		}
+	}
+	message := CreateNewMessage(role, strings.Join(texts, "\n"), contextId)
+	message.Parts = parts
+	return message
+}
+
+// HasImages reports whether the message has image parts.
+func (m Message) HasImages() bool {
+	for _, part := range m.Parts {
+		if part.Type == ContentPartImage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPostgresStore connects to LLMCHAT_TEST_POSTGRES_DSN if it is set,
+// otherwise it starts a throwaway local cluster when initdb and pg_ctl are on
+// the PATH. The test is skipped when neither is available.
+func newTestPostgresStore(t *testing.T) *SQLStore {
+	dsn := os.Getenv("LLMCHAT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		dsn = startLocalPostgres(t)
+	}
+	store, err := NewPostgresStore(dsn, DefaultPoolOptions)
+	if err != nil {
+		t.Skipf("PostgreSQL is not reachable: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func startLocalPostgres(t *testing.T) string {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		t.Skip("LLMCHAT_TEST_POSTGRES_DSN is not set and initdb is not installed")
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		t.Skip("LLMCHAT_TEST_POSTGRES_DSN is not set and pg_ctl is not installed")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	dataDir := filepath.Join(t.TempDir(), "pgdata")
+	if out, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "--auth=trust").CombinedOutput(); err != nil {
+		t.Skipf("initdb failed: %v: %s", err, out)
+	}
+	options := fmt.Sprintf("-p %d -k %s -h 127.0.0.1", port, dataDir)
+	if out, err := exec.Command(pgCtl, "-D", dataDir, "-o", options, "-w", "start").CombinedOutput(); err != nil {
+		t.Skipf("pg_ctl start failed: %v: %s", err, out)
+	}
+	t.Cleanup(func() {
+		exec.Command(pgCtl, "-D", dataDir, "-m", "immediate", "stop").Run()
+	})
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+}
+
+func TestPostgresStoreContexts(t *testing.T) {
+	store := newTestPostgresStore(t)
+	contextID := fmt.Sprintf("pg-context-%d", time.Now().UnixNano())
+	defer store.RemoveContext(contextID)
+
+	exists, err := store.CheckIfContextExists(contextID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, store.UpdateContext(contextID, "first"))
+	message, err := store.GetContextMessage(contextID)
+	require.NoError(t, err)
+	assert.Equal(t, "first", message)
+
+	require.NoError(t, store.UpdateContext(contextID, "second"))
+	message, err = store.GetContextMessage(contextID)
+	require.NoError(t, err)
+	assert.Equal(t, "second", message)
+
+	ids, err := store.GetContextIDs()
+	require.NoError(t, err)
+	assert.Contains(t, ids, contextID)
+
+	message, err = store.GetContextMessage(contextID + "-missing")
+	require.NoError(t, err)
+	assert.Equal(t, "", message)
+}
+
+func TestPostgresStoreMessages(t *testing.T) {
+	store := newTestPostgresStore(t)
+	contextID := fmt.Sprintf("pg-messages-%d", time.Now().UnixNano())
+	defer store.RemoveContext(contextID)
+
+	question := CreateNewMessage(UserRoleName, "question", contextID)
+	answer := CreateNewMessage(AssistentRoleNeam, "answer", contextID)
+	answer.Timestamp = question.Timestamp.Add(time.Second)
+	require.NoError(t, store.StoreMessages(question, answer))
+
+	exists, err := store.CheckIfContextExists(contextID)
+	require.NoError(t, err)
+	assert.True(t, exists, "storing a message creates its context")
+
+	stored, err := store.GetMessageByID(answer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "answer", stored.Content)
+	assert.Equal(t, AssistentRoleNeam, stored.Role)
+
+	all, err := store.GetMessagesByContextID(contextID)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, question.ID, all[0].ID)
+	assert.Equal(t, answer.ID, all[1].ID)
+
+	// A failing message rolls back the whole pair.
+	duplicate := CreateNewMessage(UserRoleName, "duplicate", contextID)
+	err = store.StoreMessages(duplicate, answer)
+	assert.Error(t, err)
+	_, err = store.GetMessageByID(duplicate.ID)
+	assert.Error(t, err)
+
+	require.NoError(t, store.DeleteMessageByID(question.ID))
+	last, err := store.GetLastMessagesByContextID(contextID, 5)
+	require.NoError(t, err)
+	require.Len(t, last, 1)
+	assert.Equal(t, answer.ID, last[0].ID)
+
+	require.NoError(t, store.RemoveContext(contextID))
+	exists, err = store.CheckIfContextExists(contextID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPostgresStoreConcurrentTemplateSaves(t *testing.T) {
+	store := newTestPostgresStore(t)
+	testConcurrentTemplateSaves(t, store, fmt.Sprintf("pg-template-%d", time.Now().UnixNano()))
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CachedResponse is an answer kept by a response cache. Value is owned by
+// the cache, ExpiresAt is zero for entries that do not expire.
+type CachedResponse struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ErrCacheMiss is returned when no response is cached under a key.
+var ErrCacheMiss = errors.New("no cached response")
+
+// ResponseCacheStore keeps cached responses next to the contexts. SQLStore
+// implements it.
+type ResponseCacheStore interface {
+	// GetCachedResponse returns the response stored under key, expired or
+	// not, or ErrCacheMiss.
+	GetCachedResponse(key string) (CachedResponse, error)
+	// PutCachedResponse stores r, replacing the response with the same key.
+	PutCachedResponse(r CachedResponse) error
+	DeleteCachedResponse(key string) error
+	// PurgeExpiredResponses deletes the responses that expired before now and
+	// returns how many there were.
+	PurgeExpiredResponses(now time.Time) (int64, error)
+}
+
+func (s *SQLStore) GetCachedResponse(key string) (CachedResponse, error) {
+	r := CachedResponse{Key: key}
+	var expiresAt sql.NullTime
+	err := s.queryRow("SELECT value, created_at, expires_at FROM response_cache WHERE cache_key=?", key).
+		Scan(&r.Value, &r.CreatedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CachedResponse{}, ErrCacheMiss
+	}
+	if err != nil {
+		return CachedResponse{}, err
+	}
+	if expiresAt.Valid {
+		r.ExpiresAt = expiresAt.Time
+	}
+	return r, nil
+}
+
+func (s *SQLStore) PutCachedResponse(r CachedResponse) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	// Times are stored in UTC, SQLite compares them as text.
+	expiresAt := sql.NullTime{Time: r.ExpiresAt.UTC(), Valid: !r.ExpiresAt.IsZero()}
+	_, err := s.exec(`INSERT INTO response_cache(cache_key, value, created_at, expires_at) VALUES(?, ?, ?, ?)
+		ON CONFLICT (cache_key) DO UPDATE SET value=excluded.value, created_at=excluded.created_at, expires_at=excluded.expires_at`,
+		r.Key, r.Value, r.CreatedAt.UTC(), expiresAt)
+	return err
+}
+
+func (s *SQLStore) DeleteCachedResponse(key string) error {
+	_, err := s.exec("DELETE FROM response_cache WHERE cache_key=?", key)
+	return err
+}
+
+func (s *SQLStore) PurgeExpiredResponses(now time.Time) (int64, error) {
+	result, err := s.exec("DELETE FROM response_cache WHERE expires_at IS NOT NULL AND expires_at < ?", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
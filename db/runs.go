@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Statuses of an agent run.
+const (
+	RunStatusRunning   = "running"
+	RunStatusCompleted = "completed"
+	RunStatusFailed    = "failed"
+	// RunStatusStepLimit ends runs that did not find an answer within their
+	// step limit.
+	RunStatusStepLimit = "step_limit"
+)
+
+// Types of the steps of an agent run.
+const (
+	// StepThought is what the model wrote before calling tools.
+	StepThought = "thought"
+	// StepToolCall is a tool the model asked to call, with Tool, CallId and
+	// Arguments set.
+	StepToolCall = "tool_call"
+	// StepRejected is a tool call that was not approved.
+	StepRejected = "rejected"
+	// StepObservation is the result of a tool call, or its error.
+	StepObservation = "observation"
+	StepAnswer      = "answer"
+)
+
+// AgentRun is one task an agent worked on in a context.
+type AgentRun struct {
+	ID        string    `json:"id"`
+	ContextId string    `json:"context_id"`
+	Goal      string    `json:"goal"`
+	Status    string    `json:"status"`
+	Answer    string    `json:"answer"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+	// FinishedAt is zero while the run is running.
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// AgentStep is a step of a run, Seq numbers the steps of a run from 1.
+type AgentStep struct {
+	RunId     string    `json:"run_id"`
+	Seq       int       `json:"seq"`
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	Tool      string    `json:"tool,omitempty"`
+	CallId    string    `json:"call_id,omitempty"`
+	Arguments string    `json:"arguments,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrRunNotFound is returned by GetRun for unknown ids.
+var ErrRunNotFound = errors.New("run not found")
+
+// RunStore keeps the runs of agents and their steps. SQLStore implements
+// it.
+type RunStore interface {
+	CreateRun(run AgentRun) error
+	AddRunStep(step AgentStep) error
+	FinishRun(id string, status string, answer string, errorMessage string) error
+	GetRun(id string) (AgentRun, error)
+	// GetRunSteps returns the steps of a run in order.
+	GetRunSteps(runId string) ([]AgentStep, error)
+	// GetContextRuns returns the runs of a context, oldest first.
+	GetContextRuns(contextId string) ([]AgentRun, error)
+}
+
+const runColumns = "id, context_id, goal, status, answer, error, created_at, finished_at"
+const runStepColumns = "run_id, seq, type, content, tool, call_id, arguments, created_at"
+
+func (s *SQLStore) CreateRun(run AgentRun) error {
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now()
+	}
+	if run.Status == "" {
+		run.Status = RunStatusRunning
+	}
+	finishedAt := sql.NullTime{Time: run.FinishedAt.UTC(), Valid: !run.FinishedAt.IsZero()}
+	_, err := s.exec("INSERT INTO agent_runs("+runColumns+") VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		run.ID, run.ContextId, run.Goal, run.Status, run.Answer, run.Error, run.CreatedAt.UTC(), finishedAt)
+	return err
+}
+
+func (s *SQLStore) AddRunStep(step AgentStep) error {
+	if step.CreatedAt.IsZero() {
+		step.CreatedAt = time.Now()
+	}
+	_, err := s.exec("INSERT INTO agent_steps("+runStepColumns+") VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		step.RunId, step.Seq, step.Type, step.Content, step.Tool, step.CallId, step.Arguments, step.CreatedAt.UTC())
+	return err
+}
+
+func (s *SQLStore) FinishRun(id string, status string, answer string, errorMessage string) error {
+	result, err := s.exec("UPDATE agent_runs SET status=?, answer=?, error=?, finished_at=? WHERE id=?",
+		status, answer, errorMessage, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return ErrRunNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) GetRun(id string) (AgentRun, error) {
+	run, err := scanRun(s.queryRow("SELECT "+runColumns+" FROM agent_runs WHERE id=?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AgentRun{}, ErrRunNotFound
+	}
+	return run, err
+}
+
+func (s *SQLStore) GetRunSteps(runId string) ([]AgentStep, error) {
+	rows, err := s.query("SELECT "+runStepColumns+" FROM agent_steps WHERE run_id=? ORDER BY seq", runId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	steps := []AgentStep{}
+	for rows.Next() {
+		var step AgentStep
+		if err := rows.Scan(&step.RunId, &step.Seq, &step.Type, &step.Content, &step.Tool, &step.CallId, &step.Arguments, &step.CreatedAt); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+func (s *SQLStore) GetContextRuns(contextId string) ([]AgentRun, error) {
+	rows, err := s.query("SELECT "+runColumns+" FROM agent_runs WHERE context_id=? ORDER BY created_at, id", contextId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []AgentRun{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func scanRun(row rowScanner) (AgentRun, error) {
+	var run AgentRun
+	var finishedAt sql.NullTime
+	if err := row.Scan(&run.ID, &run.ContextId, &run.Goal, &run.Status, &run.Answer, &run.Error, &run.CreatedAt, &finishedAt); err != nil {
+		return AgentRun{}, err
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = finishedAt.Time
+	}
+	return run, nil
+}
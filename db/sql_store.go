@@ -0,0 +1,384 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type dialect struct {
+	name string
+	// numberedParams is true for drivers that use $1, $2, ... instead of ?.
+	numberedParams bool
+	schema         []string
+	migrations     []migration
+	// migrationLock is executed inside the migration transaction so that
+	// several processes starting at once do not apply the same migration.
+	migrationLock string
+	// lockContextSuffix is appended to the select of the context row when
+	// the next sequence number is allocated, and of the template name row
+	// when the next template version is. SQLite already serializes writers
+	// so it needs nothing.
+	lockContextSuffix string
+}
+
+// migration changes the schema of databases created by older versions of the
+// library. Versions are applied in order and recorded in schema_migrations.
+type migration struct {
+	version    int
+	statements []string
+}
+
+const messageColumns = "id, context_id, timestamp, role, content, status, seq, metadata, parts"
+
+// SQLStore is a Store on top of database/sql. The same queries are used for
+// SQLite and PostgreSQL, only placeholders and the schema differ.
+//
+// Messages are ordered by a per-context sequence number that is allocated
+// when they are stored, so the order does not depend on clock precision or
+// on clocks of different hosts.
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func newSQLStore(sqlDb *sql.DB, d dialect) (*SQLStore, error) {
+	s := &SQLStore{
+		db:      sqlDb,
+		dialect: d,
+	}
+	for _, statement := range d.schema {
+		if _, err := sqlDb.Exec(statement); err != nil {
+			return nil, fmt.Errorf("unable to create %s schema: %v", d.name, err)
+		}
+	}
+	if err := s.migrate(); err != nil {
+		return nil, fmt.Errorf("unable to migrate %s schema: %v", d.name, err)
+	}
+	return s, nil
+}
+
+func (s *SQLStore) migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.dialect.migrationLock != "" {
+		if _, err := tx.Exec(s.dialect.migrationLock); err != nil {
+			return err
+		}
+	}
+	var current int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	for _, m := range s.dialect.migrations {
+		if m.version <= current {
+			continue
+		}
+		for _, statement := range m.statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("migration %d: %v", m.version, err)
+			}
+		}
+		if _, err := tx.Exec(s.rebind("INSERT INTO schema_migrations(version) VALUES(?)"), m.version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DB returns the underlying database handle.
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) rebind(query string) string {
+	if !s.dialect.numberedParams {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.rebind(query), args...)
+}
+
+func (s *SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.rebind(query), args...)
+}
+
+func (s *SQLStore) RemoveContext(contextId string) error {
+	if err := s.removeById(`DELETE FROM messages WHERE context_id = ?`, contextId); err != nil {
+		return err
+	}
+	if err := s.removeById(`DELETE FROM context WHERE context_id = ?`, contextId); err != nil {
+		return err
+	}
+	if _, err := s.exec(`DELETE FROM context_collections WHERE context_id = ?`, contextId); err != nil {
+		return err
+	}
+	if _, err := s.exec(`DELETE FROM agent_steps WHERE run_id IN (SELECT id FROM agent_runs WHERE context_id = ?)`, contextId); err != nil {
+		return err
+	}
+	if _, err := s.exec(`DELETE FROM agent_runs WHERE context_id = ?`, contextId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SQLStore) removeById(query string, id string) error {
+	result, err := s.exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Removed %d objects with id %s", rowsAffected, id)
+	return nil
+}
+
+func (s *SQLStore) CheckIfContextExists(contextId string) (bool, error) {
+	var exists bool
+	err := s.queryRow("SELECT EXISTS(SELECT 1 FROM context WHERE context_id=? LIMIT 1)", contextId).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (s *SQLStore) UpdateContext(contextId string, context string) error {
+	exist, err := s.CheckIfContextExists(contextId)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return s.CreateContext(contextId, context)
+	}
+	_, err = s.exec("UPDATE context SET context=? WHERE context_id=?", context, contextId)
+	return err
+}
+
+func (s *SQLStore) CreateContext(contextId string, context string) error {
+	_, err := s.exec("INSERT INTO context(context_id, context) VALUES(?, ?)", contextId, context)
+	return err
+}
+
+func (s *SQLStore) StoreMessage(m Message) (string, error) {
+	if m.ID == "" {
+		m.ID = uuid.New().String()
+	}
+	if err := s.StoreMessages(m); err != nil {
+		return "", err
+	}
+	return m.ID, nil
+}
+
+func (s *SQLStore) StoreMessages(messages ...Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	nextSeq := map[string]int64{}
+	for _, m := range messages {
+		if _, ok := nextSeq[m.ContextId]; !ok {
+			seq, err := s.allocateSeq(tx, m.ContextId)
+			if err != nil {
+				return err
+			}
+			nextSeq[m.ContextId] = seq
+		}
+		if m.ID == "" {
+			m.ID = uuid.New().String()
+		}
+		if m.Status == "" {
+			m.Status = MessageStatusOK
+		}
+		m.Seq = nextSeq[m.ContextId]
+		nextSeq[m.ContextId]++
+		metadata, err := json.Marshal(m.Metadata)
+		if err != nil {
+			return err
+		}
+		parts := []byte("[]")
+		if len(m.Parts) > 0 {
+			if parts, err = json.Marshal(m.Parts); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(s.rebind("INSERT INTO messages("+messageColumns+") VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+			m.ID, m.ContextId, m.Timestamp, m.Role, m.Content, m.Status, m.Seq, string(metadata), string(parts))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// allocateSeq creates the context if needed, locks it for the rest of the
+// transaction and returns the next free sequence number of its messages.
+func (s *SQLStore) allocateSeq(tx *sql.Tx, contextId string) (int64, error) {
+	if _, err := tx.Exec(s.rebind(s.insertIfMissingQuery("context", "context_id", "context")), contextId, ""); err != nil {
+		return 0, err
+	}
+	var locked string
+	if err := tx.QueryRow(s.rebind("SELECT context_id FROM context WHERE context_id=?"+s.dialect.lockContextSuffix), contextId).Scan(&locked); err != nil {
+		return 0, err
+	}
+	var seq int64
+	if err := tx.QueryRow(s.rebind("SELECT COALESCE(MAX(seq), 0) + 1 FROM messages WHERE context_id=?"), contextId).Scan(&seq); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// insertIfMissingQuery inserts a row unless one with the same key exists.
+// The key is the first column.
+func (s *SQLStore) insertIfMissingQuery(table string, columns ...string) string {
+	values := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	if s.dialect.numberedParams {
+		return "INSERT INTO " + table + "(" + strings.Join(columns, ", ") + ") VALUES(" + values + ") ON CONFLICT (" + columns[0] + ") DO NOTHING"
+	}
+	return "INSERT OR IGNORE INTO " + table + "(" + strings.Join(columns, ", ") + ") VALUES(" + values + ")"
+}
+
+func (s *SQLStore) GetContextIDs() ([]string, error) {
+	rows, err := s.query("SELECT context_id FROM context")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contextIDs := []string{}
+	for rows.Next() {
+		var contextID string
+		err := rows.Scan(&contextID)
+		if err != nil {
+			return nil, err
+		}
+		contextIDs = append(contextIDs, contextID)
+	}
+
+	return contextIDs, rows.Err()
+}
+
+func (s *SQLStore) GetMessageByID(id string) (Message, error) {
+	m, err := scanMessage(s.queryRow("SELECT "+messageColumns+" FROM messages WHERE id=?", id))
+	if err != nil {
+		return Message{}, err
+	}
+
+	return m, nil
+}
+
+func (s *SQLStore) GetContextMessage(contextId string) (string, error) {
+	var context string
+	err := s.queryRow("SELECT context FROM context WHERE context_id=?", contextId).Scan(&context)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return context, nil
+}
+
+func (s *SQLStore) DeleteMessageByID(id string) error {
+	_, err := s.exec("DELETE FROM messages WHERE id=?", id)
+	return err
+}
+
+// GetLastMessagesByContextID returns the history that is sent to the model:
+// the last count messages, oldest first. Messages of failed turns are skipped.
+func (s *SQLStore) GetLastMessagesByContextID(contextID string, count int) ([]Message, error) {
+	messages, err := s.queryMessages("SELECT "+messageColumns+" FROM messages WHERE context_id=? AND status<>? ORDER BY seq DESC LIMIT ?", contextID, MessageStatusFailed, count)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// GetMessagesByContextID returns every message of the context including
+// failed ones, callers can check Message.Status.
+func (s *SQLStore) GetMessagesByContextID(contextID string) ([]Message, error) {
+	return s.queryMessages("SELECT "+messageColumns+" FROM messages WHERE context_id=? ORDER BY seq ASC", contextID)
+}
+
+func (s *SQLStore) queryMessages(query string, args ...interface{}) ([]Message, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row rowScanner) (Message, error) {
+	var m Message
+	var metadata, parts string
+	if err := row.Scan(&m.ID, &m.ContextId, &m.Timestamp, &m.Role, &m.Content, &m.Status, &m.Seq, &metadata, &parts); err != nil {
+		return Message{}, err
+	}
+	if err := json.Unmarshal([]byte(metadata), &m.Metadata); err != nil {
+		return Message{}, fmt.Errorf("message %s has invalid metadata: %v", m.ID, err)
+	}
+	if parts != "[]" {
+		if err := json.Unmarshal([]byte(parts), &m.Parts); err != nil {
+			return Message{}, fmt.Errorf("message %s has invalid parts: %v", m.ID, err)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	schema: []string{
+		`CREATE TABLE IF NOT EXISTS messages (
+		id TEXT PRIMARY KEY,
+		context_id TEXT,
+		timestamp DATETIME,
+		role TEXT,
+		content TEXT
+	);`,
+		`CREATE TABLE IF NOT EXISTS context (
+		context_id TEXT PRIMARY KEY,
+		context TEXT
+	);`,
+	},
+	migrations: []migration{
+		{version: 1, statements: []string{
+			`ALTER TABLE messages ADD COLUMN status TEXT NOT NULL DEFAULT 'ok'`,
+		}},
+		{version: 2, statements: []string{
+			`ALTER TABLE messages ADD COLUMN seq INTEGER`,
+			`UPDATE messages SET seq = numbered.rn FROM (
+				SELECT rowid AS rid, ROW_NUMBER() OVER (PARTITION BY context_id ORDER BY timestamp, rowid) AS rn FROM messages
+			) AS numbered WHERE messages.rowid = numbered.rid`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS messages_context_seq_idx ON messages (context_id, seq)`,
+		}},
+		{version: 3, statements: []string{
+			`CREATE TABLE IF NOT EXISTS prompt_templates (
+				name TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				body TEXT NOT NULL,
+				variables TEXT NOT NULL,
+				partials TEXT NOT NULL,
+				created_at DATETIME,
+				PRIMARY KEY (name, version)
+			)`,
+			`CREATE TABLE IF NOT EXISTS template_usages (
+				message_id TEXT NOT NULL,
+				context_id TEXT NOT NULL,
+				template_name TEXT NOT NULL,
+				template_version INTEGER NOT NULL,
+				variables TEXT NOT NULL,
+				partials TEXT NOT NULL,
+				timestamp DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS template_usages_name_idx ON template_usages (template_name, template_version)`,
+		}},
+		{version: 4, statements: []string{
+			`ALTER TABLE messages ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}'`,
+		}},
+		{version: 5, statements: []string{
+			`CREATE TABLE IF NOT EXISTS response_cache (
+				cache_key TEXT PRIMARY KEY,
+				value TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME
+			)`,
+		}},
+		{version: 6, statements: []string{
+			`ALTER TABLE messages ADD COLUMN parts TEXT NOT NULL DEFAULT '[]'`,
+		}},
+		{version: 7, statements: []string{
+			`CREATE TABLE IF NOT EXISTS vectors (
+				collection TEXT NOT NULL,
+				id TEXT NOT NULL,
+				embedding BLOB NOT NULL,
+				content TEXT NOT NULL,
+				metadata TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (collection, id)
+			)`,
+		}},
+		{version: 8, statements: []string{
+			`CREATE TABLE IF NOT EXISTS context_collections (
+				context_id TEXT NOT NULL,
+				collection TEXT NOT NULL,
+				PRIMARY KEY (context_id, collection)
+			)`,
+		}},
+		{version: 9, statements: []string{
+			`CREATE TABLE IF NOT EXISTS agent_runs (
+				id TEXT PRIMARY KEY,
+				context_id TEXT NOT NULL,
+				goal TEXT NOT NULL,
+				status TEXT NOT NULL,
+				answer TEXT NOT NULL,
+				error TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				finished_at DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS agent_runs_context_idx ON agent_runs (context_id)`,
+			`CREATE TABLE IF NOT EXISTS agent_steps (
+				run_id TEXT NOT NULL,
+				seq INTEGER NOT NULL,
+				type TEXT NOT NULL,
+				content TEXT NOT NULL,
+				tool TEXT NOT NULL,
+				call_id TEXT NOT NULL,
+				arguments TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (run_id, seq)
+			)`,
+		}},
+		{version: 10, statements: []string{
+			`CREATE TABLE IF NOT EXISTS prompt_template_names (
+				name TEXT PRIMARY KEY
+			)`,
+			`INSERT INTO prompt_template_names (name) SELECT DISTINCT name FROM prompt_templates`,
+		}},
+	},
+}
+
+// SQLiteOptions configures how a SQLite database is opened.
+type SQLiteOptions struct {
+	// BusyTimeout is how long a statement waits for a lock held by another
+	// connection or process before failing with "database is locked".
+	BusyTimeout time.Duration
+	// WAL switches the database to write-ahead logging, readers then do not
+	// block the writer.
+	WAL bool
+}
+
+// DefaultSQLiteOptions allow several processes to use the same database file.
+var DefaultSQLiteOptions = SQLiteOptions{
+	BusyTimeout: 5 * time.Second,
+	WAL:         true,
+}
+
+// NewSQLiteStore opens (and creates if needed) a SQLite database at dbFilePath
+// with DefaultSQLiteOptions.
+func NewSQLiteStore(dbFilePath string) (*SQLStore, error) {
+	return NewSQLiteStoreWithOptions(dbFilePath, DefaultSQLiteOptions)
+}
+
+// NewSQLiteStoreWithOptions opens (and creates if needed) a SQLite database at
+// dbFilePath. Transactions take the write lock when they begin, so two
+// processes storing messages into one context wait for each other instead of
+// failing on lock upgrade.
+func NewSQLiteStoreWithOptions(dbFilePath string, options SQLiteOptions) (*SQLStore, error) {
+	params := url.Values{}
+	params.Set("_txlock", "immediate")
+	if options.BusyTimeout > 0 {
+		params.Set("_busy_timeout", fmt.Sprintf("%d", options.BusyTimeout.Milliseconds()))
+	}
+	if options.WAL {
+		params.Set("_journal_mode", "WAL")
+	}
+	sqlDb, err := sql.Open("sqlite3", dbFilePath+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	store, err := newSQLStore(sqlDb, sqliteDialect)
+	if err != nil {
+		sqlDb.Close()
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,27 @@
+package db
+
+// Store is the persistence layer for contexts and messages. The package level
+// functions operate on the default SQLite store, while servers that need a
+// shared database can create their own Store (for example with
+// NewPostgresStore) and pass it to client.Client.
+type Store interface {
+	CheckIfContextExists(contextId string) (bool, error)
+	CreateContext(contextId string, context string) error
+	UpdateContext(contextId string, context string) error
+	GetContextMessage(contextId string) (string, error)
+	GetContextIDs() ([]string, error)
+	RemoveContext(contextId string) error
+
+	StoreMessage(m Message) (string, error)
+	// StoreMessages writes all messages in a single transaction, either all
+	// of them are stored or none.
+	StoreMessages(messages ...Message) error
+	GetMessageByID(id string) (Message, error)
+	DeleteMessageByID(id string) error
+	// GetLastMessagesByContextID returns the last count messages that can be
+	// sent to the model, ordered from the oldest to the newest.
+	GetLastMessagesByContextID(contextID string, count int) ([]Message, error)
+	GetMessagesByContextID(contextID string) ([]Message, error)
+
+	Close() error
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PromptTemplate is a stored version of a named prompt template. Variables
+// and Partials are kept as JSON, the prompt package owns their format.
+type PromptTemplate struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Body      string    `json:"body"`
+	Variables string    `json:"variables"`
+	Partials  string    `json:"partials"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TemplateUsage records which template version produced a message or a
+// context's system prompt. MessageID is empty for system prompts. Variables
+// and Partials (the versions of included templates) are JSON.
+type TemplateUsage struct {
+	MessageID       string    `json:"message_id"`
+	ContextId       string    `json:"context_id"`
+	TemplateName    string    `json:"template_name"`
+	TemplateVersion int       `json:"template_version"`
+	Variables       string    `json:"variables"`
+	Partials        string    `json:"partials"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// ErrTemplateNotFound is returned when a template or version does not exist.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// TemplateStore keeps prompt templates next to the contexts that use them.
+// SQLStore implements it.
+type TemplateStore interface {
+	// SaveTemplate stores t as the next version of t.Name and returns it
+	// with Version and CreatedAt set.
+	SaveTemplate(t PromptTemplate) (PromptTemplate, error)
+	// GetTemplate returns the given version, or the latest one when version
+	// is 0.
+	GetTemplate(name string, version int) (PromptTemplate, error)
+	GetTemplateVersions(name string) ([]PromptTemplate, error)
+	RecordTemplateUsage(u TemplateUsage) error
+	GetTemplateUsages(name string) ([]TemplateUsage, error)
+}
+
+const templateColumns = "name, version, body, variables, partials, created_at"
+const templateUsageColumns = "message_id, context_id, template_name, template_version, variables, partials, timestamp"
+
+func (s *SQLStore) SaveTemplate(t PromptTemplate) (PromptTemplate, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+	defer tx.Rollback()
+
+	// The name row is locked so that concurrent saves of the same template
+	// get consecutive versions, like allocateSeq does for messages.
+	if _, err := tx.Exec(s.rebind(s.insertIfMissingQuery("prompt_template_names", "name")), t.Name); err != nil {
+		return PromptTemplate{}, err
+	}
+	var locked string
+	if err := tx.QueryRow(s.rebind("SELECT name FROM prompt_template_names WHERE name=?"+s.dialect.lockContextSuffix), t.Name).Scan(&locked); err != nil {
+		return PromptTemplate{}, err
+	}
+	if err := tx.QueryRow(s.rebind("SELECT COALESCE(MAX(version), 0) + 1 FROM prompt_templates WHERE name=?"), t.Name).Scan(&t.Version); err != nil {
+		return PromptTemplate{}, err
+	}
+	t.CreatedAt = time.Now()
+	_, err = tx.Exec(s.rebind("INSERT INTO prompt_templates("+templateColumns+") VALUES(?, ?, ?, ?, ?, ?)"),
+		t.Name, t.Version, t.Body, t.Variables, t.Partials, t.CreatedAt)
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+	return t, tx.Commit()
+}
+
+func (s *SQLStore) GetTemplate(name string, version int) (PromptTemplate, error) {
+	var row *sql.Row
+	if version == 0 {
+		row = s.queryRow("SELECT "+templateColumns+" FROM prompt_templates WHERE name=? ORDER BY version DESC LIMIT 1", name)
+	} else {
+		row = s.queryRow("SELECT "+templateColumns+" FROM prompt_templates WHERE name=? AND version=?", name, version)
+	}
+	var t PromptTemplate
+	err := row.Scan(&t.Name, &t.Version, &t.Body, &t.Variables, &t.Partials, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PromptTemplate{}, ErrTemplateNotFound
+	}
+	return t, err
+}
+
+func (s *SQLStore) GetTemplateVersions(name string) ([]PromptTemplate, error) {
+	rows, err := s.query("SELECT "+templateColumns+" FROM prompt_templates WHERE name=? ORDER BY version ASC", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []PromptTemplate{}
+	for rows.Next() {
+		var t PromptTemplate
+		if err := rows.Scan(&t.Name, &t.Version, &t.Body, &t.Variables, &t.Partials, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+func (s *SQLStore) RecordTemplateUsage(u TemplateUsage) error {
+	if u.Timestamp.IsZero() {
+		u.Timestamp = time.Now()
+	}
+	_, err := s.exec("INSERT INTO template_usages("+templateUsageColumns+") VALUES(?, ?, ?, ?, ?, ?, ?)",
+		u.MessageID, u.ContextId, u.TemplateName, u.TemplateVersion, u.Variables, u.Partials, u.Timestamp)
+	return err
+}
+
+func (s *SQLStore) GetTemplateUsages(name string) ([]TemplateUsage, error) {
+	rows, err := s.query("SELECT "+templateUsageColumns+" FROM template_usages WHERE template_name=? ORDER BY timestamp ASC", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []TemplateUsage{}
+	for rows.Next() {
+		var u TemplateUsage
+		if err := rows.Scan(&u.MessageID, &u.ContextId, &u.TemplateName, &u.TemplateVersion, &u.Variables, &u.Partials, &u.Timestamp); err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+	return usages, rows.Err()
+}
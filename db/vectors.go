@@ -0,0 +1,258 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Vector is an embedding together with the text it was computed from.
+type Vector struct {
+	ID        string            `json:"id"`
+	Embedding []float32         `json:"embedding"`
+	Content   string            `json:"content"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// VectorMatch is a search result, Score is the cosine similarity to the
+// query from -1 to 1.
+type VectorMatch struct {
+	Vector
+	Score float64 `json:"score"`
+}
+
+// VectorStore keeps vectors in named collections. SQLStore implements it.
+type VectorStore interface {
+	// UpsertVectors stores vectors, replacing those with the same id.
+	UpsertVectors(collection string, vectors ...Vector) error
+	// SearchVectors returns the k vectors most similar to query whose
+	// metadata contains every key and value of filter, best first.
+	SearchVectors(collection string, query []float32, k int, filter map[string]string) ([]VectorMatch, error)
+	GetVector(collection string, id string) (Vector, error)
+	DeleteVectors(collection string, ids ...string) error
+	// DeleteVectorsByMetadata deletes the vectors whose metadata contains
+	// every key and value of filter.
+	DeleteVectorsByMetadata(collection string, filter map[string]string) error
+	DeleteCollection(collection string) error
+}
+
+// ContextCollectionStore attaches vector collections to contexts. SQLStore
+// implements it.
+type ContextCollectionStore interface {
+	AttachCollection(contextId string, collection string) error
+	DetachCollection(contextId string, collection string) error
+	// GetContextCollections returns the collections attached to a context,
+	// ordered by name.
+	GetContextCollections(contextId string) ([]string, error)
+}
+
+// ErrVectorNotFound is returned by GetVector for unknown ids.
+var ErrVectorNotFound = errors.New("vector not found")
+
+func (s *SQLStore) UpsertVectors(collection string, vectors ...Vector) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, v := range vectors {
+		if v.ID == "" {
+			return errors.New("vector has no id")
+		}
+		if v.CreatedAt.IsZero() {
+			v.CreatedAt = time.Now()
+		}
+		metadata, err := json.Marshal(v.Metadata)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.rebind(`INSERT INTO vectors(collection, id, embedding, content, metadata, created_at) VALUES(?, ?, ?, ?, ?, ?)
+			ON CONFLICT (collection, id) DO UPDATE SET embedding=excluded.embedding, content=excluded.content, metadata=excluded.metadata, created_at=excluded.created_at`),
+			collection, v.ID, encodeEmbedding(v.Embedding), v.Content, string(metadata), v.CreatedAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SearchVectors compares the query with every vector of the collection, which
+// is fine for the few hundred thousand vectors a local store is meant for.
+func (s *SQLStore) SearchVectors(collection string, query []float32, k int, filter map[string]string) ([]VectorMatch, error) {
+	rows, err := s.query("SELECT id, embedding, content, metadata, created_at FROM vectors WHERE collection=? ORDER BY id", collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matches := make([]VectorMatch, 0)
+	for rows.Next() {
+		v, err := scanVector(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !matchesFilter(v.Metadata, filter) {
+			continue
+		}
+		if len(v.Embedding) != len(query) {
+			return nil, fmt.Errorf("vector %s has %d dimensions, the query has %d", v.ID, len(v.Embedding), len(query))
+		}
+		matches = append(matches, VectorMatch{Vector: v, Score: CosineSimilarity(query, v.Embedding)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if k > 0 && len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches, nil
+}
+
+func (s *SQLStore) GetVector(collection string, id string) (Vector, error) {
+	v, err := scanVector(s.queryRow("SELECT id, embedding, content, metadata, created_at FROM vectors WHERE collection=? AND id=?", collection, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Vector{}, ErrVectorNotFound
+		}
+		return Vector{}, err
+	}
+	return v, nil
+}
+
+func (s *SQLStore) DeleteVectors(collection string, ids ...string) error {
+	for _, id := range ids {
+		if _, err := s.exec("DELETE FROM vectors WHERE collection=? AND id=?", collection, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteVectorsByMetadata(collection string, filter map[string]string) error {
+	rows, err := s.query("SELECT id, metadata FROM vectors WHERE collection=?", collection)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id, metadata string
+		if err := rows.Scan(&id, &metadata); err != nil {
+			rows.Close()
+			return err
+		}
+		var values map[string]string
+		if err := json.Unmarshal([]byte(metadata), &values); err != nil {
+			rows.Close()
+			return fmt.Errorf("vector %s has invalid metadata: %v", id, err)
+		}
+		if matchesFilter(values, filter) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return s.DeleteVectors(collection, ids...)
+}
+
+func (s *SQLStore) DeleteCollection(collection string) error {
+	_, err := s.exec("DELETE FROM vectors WHERE collection=?", collection)
+	return err
+}
+
+func scanVector(row rowScanner) (Vector, error) {
+	var v Vector
+	var embedding []byte
+	var metadata string
+	if err := row.Scan(&v.ID, &embedding, &v.Content, &metadata, &v.CreatedAt); err != nil {
+		return Vector{}, err
+	}
+	var err error
+	if v.Embedding, err = decodeEmbedding(embedding); err != nil {
+		return Vector{}, fmt.Errorf("vector %s: %v", v.ID, err)
+	}
+	if err := json.Unmarshal([]byte(metadata), &v.Metadata); err != nil {
+		return Vector{}, fmt.Errorf("vector %s has invalid metadata: %v", v.ID, err)
+	}
+	return v, nil
+}
+
+// Embeddings are stored as little endian float32 values.
+func encodeEmbedding(embedding []float32) []byte {
+	data := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+func decodeEmbedding(data []byte) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid embedding of %d bytes", len(data))
+	}
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return embedding, nil
+}
+
+func matchesFilter(metadata map[string]string, filter map[string]string) bool {
+	for key, value := range filter {
+		if actual, ok := metadata[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// CosineSimilarity of two vectors of the same length, 0 when one of them is
+// zero.
+func CosineSimilarity(a []float32, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func (s *SQLStore) AttachCollection(contextId string, collection string) error {
+	_, err := s.exec("INSERT INTO context_collections(context_id, collection) VALUES(?, ?) ON CONFLICT (context_id, collection) DO NOTHING", contextId, collection)
+	return err
+}
+
+func (s *SQLStore) DetachCollection(contextId string, collection string) error {
+	_, err := s.exec("DELETE FROM context_collections WHERE context_id=? AND collection=?", contextId, collection)
+	return err
+}
+
+func (s *SQLStore) GetContextCollections(contextId string) ([]string, error) {
+	rows, err := s.query("SELECT collection FROM context_collections WHERE context_id=? ORDER BY collection", contextId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collections := make([]string, 0)
+	for rows.Next() {
+		var collection string
+		if err := rows.Scan(&collection); err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	return collections, rows.Err()
+}
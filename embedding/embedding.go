@@ -0,0 +1,125 @@
+// Package embedding computes embeddings of texts and searches them in a
+// db.VectorStore.
+package embedding
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// Embedder computes one embedding per input, in the order of the inputs.
+// Implementations split large inputs into batches the provider accepts.
+type Embedder interface {
+	Embed(inputs []string) ([][]float32, error)
+}
+
+// QueryEmbedder is implemented by embedders whose models embed search queries
+// differently from the documents they are compared with.
+type QueryEmbedder interface {
+	EmbedQuery(query string) ([]float32, error)
+}
+
+// EmbedQuery embeds a search query, with EmbedQuery when embedder is a
+// QueryEmbedder and with Embed otherwise.
+func EmbedQuery(embedder Embedder, query string) ([]float32, error) {
+	if queryEmbedder, ok := embedder.(QueryEmbedder); ok {
+		return queryEmbedder.EmbedQuery(query)
+	}
+	embeddings, err := embedder.Embed([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != 1 {
+		return nil, errors.New("embedder returned no embedding for the query")
+	}
+	return embeddings[0], nil
+}
+
+// Document is a text to index, Metadata can be used to filter searches.
+type Document struct {
+	ID       string
+	Content  string
+	Metadata map[string]string
+}
+
+// Index embeds documents into a collection of a vector store and searches
+// them by the meaning of a query.
+type Index struct {
+	Embedder   Embedder
+	Store      db.VectorStore
+	Collection string
+}
+
+func NewIndex(embedder Embedder, store db.VectorStore, collection string) *Index {
+	return &Index{
+		Embedder:   embedder,
+		Store:      store,
+		Collection: collection,
+	}
+}
+
+// Add embeds the documents and stores them, replacing documents with the same
+// id.
+func (i *Index) Add(documents ...Document) error {
+	if len(documents) == 0 {
+		return nil
+	}
+	inputs := make([]string, len(documents))
+	for j, document := range documents {
+		inputs[j] = document.Content
+	}
+	embeddings, err := i.Embedder.Embed(inputs)
+	if err != nil {
+		return err
+	}
+	if len(embeddings) != len(documents) {
+		return fmt.Errorf("got %d embeddings for %d documents", len(embeddings), len(documents))
+	}
+	vectors := make([]db.Vector, len(documents))
+	for j, document := range documents {
+		vectors[j] = db.Vector{
+			ID:        document.ID,
+			Embedding: embeddings[j],
+			Content:   document.Content,
+			Metadata:  document.Metadata,
+		}
+	}
+	return i.Store.UpsertVectors(i.Collection, vectors...)
+}
+
+// Search returns the k documents closest to query whose metadata matches
+// filter, best first.
+func (i *Index) Search(query string, k int, filter map[string]string) ([]db.VectorMatch, error) {
+	embedding, err := EmbedQuery(i.Embedder, query)
+	if err != nil {
+		return nil, err
+	}
+	return i.Store.SearchVectors(i.Collection, embedding, k, filter)
+}
+
+func (i *Index) Delete(ids ...string) error {
+	return i.Store.DeleteVectors(i.Collection, ids...)
+}
+
+// inBatches calls embed with batches of at most size inputs and joins the
+// results.
+func inBatches(inputs []string, size int, embed func(batch []string) ([][]float32, error)) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); start += size {
+		end := start + size
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		batch, err := embed(inputs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("got %d embeddings for %d inputs", len(batch), end-start)
+		}
+		embeddings = append(embeddings, batch...)
+	}
+	return embeddings, nil
+}
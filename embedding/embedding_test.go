@@ -0,0 +1,148 @@
+package embedding
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// keywordEmbedder embeds a text by the keywords it contains.
+type keywordEmbedder struct {
+	keywords []string
+}
+
+func (k *keywordEmbedder) Embed(inputs []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		embeddings[i] = make([]float32, len(k.keywords))
+		for j, keyword := range k.keywords {
+			embeddings[i][j] = float32(strings.Count(strings.ToLower(input), keyword))
+		}
+	}
+	return embeddings, nil
+}
+
+func TestIndexSearchesByMeaningAndMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	store, err := db.NewSQLiteStore(path)
+	require.NoError(t, err)
+	embedder := &keywordEmbedder{keywords: []string{"cat", "dog", "car"}}
+	index := NewIndex(embedder, store, "notes")
+
+	require.NoError(t, index.Add(
+		Document{ID: "1", Content: "My cat sleeps all day", Metadata: map[string]string{"lang": "en"}},
+		Document{ID: "2", Content: "The dog and the cat play", Metadata: map[string]string{"lang": "en"}},
+		Document{ID: "3", Content: "The car is red", Metadata: map[string]string{"lang": "en"}},
+		Document{ID: "4", Content: "Le cat est noir", Metadata: map[string]string{"lang": "fr"}},
+	))
+	require.NoError(t, store.Close())
+
+	store, err = db.NewSQLiteStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	index.Store = store
+
+	matches, err := index.Search("Where is my cat?", 2, map[string]string{"lang": "en"})
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	assert.Equal(t, "1", matches[0].ID)
+	assert.InDelta(t, 1.0, matches[0].Score, 1e-6)
+	assert.Equal(t, "2", matches[1].ID)
+	assert.Equal(t, map[string]string{"lang": "en"}, matches[1].Metadata)
+
+	require.NoError(t, index.Delete("1"))
+	matches, err = index.Search("cat", 0, nil)
+	require.NoError(t, err)
+	assert.Len(t, matches, 3)
+}
+
+func TestOpenAIEmbedderBatches(t *testing.T) {
+	requests := make([]openAIEmbeddingRequest, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		var request openAIEmbeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+		// The answer lists the embeddings in reverse order, the index
+		// tells which input they belong to.
+		data := make([]map[string]interface{}, 0)
+		for i := len(request.Input) - 1; i >= 0; i-- {
+			data = append(data, map[string]interface{}{"index": i, "embedding": []float32{float32(len(request.Input[i])), 1}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	embedder := NewOpenAIEmbedder("key", nil)
+	embedder.BaseURL = server.URL
+	embedder.BatchSize = 2
+	embedder.Dimensions = 256
+	embeddings, err := embedder.Embed([]string{"a", "bb", "ccc"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 1}, {2, 1}, {3, 1}}, embeddings)
+	assert.Equal(t, []openAIEmbeddingRequest{
+		{Model: OpenAIModelSmall, Input: []string{"a", "bb"}, Dimensions: 256},
+		{Model: OpenAIModelSmall, Input: []string{"ccc"}, Dimensions: 256},
+	}, requests)
+}
+
+func TestVertexEmbedder(t *testing.T) {
+	var received vertexRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/projects/project/locations/europe-west4/publishers/google/models/text-embedding-004:predict", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"predictions": [{"embeddings": {"values": [0.1, 0.2]}}, {"embeddings": {"values": [0.3, 0.4]}}]}`))
+	}))
+	defer server.Close()
+
+	embedder := NewVertexEmbedder("project", "europe-west4", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), nil)
+	embedder.BaseURL = server.URL
+	embedder.Dimensions = 2
+	embedder.TaskType = TaskRetrievalDocument
+	embeddings, err := embedder.Embed([]string{"first", "second"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{0.1, 0.2}, {0.3, 0.4}}, embeddings)
+	assert.Equal(t, vertexRequest{
+		Instances:  []vertexInstance{{Content: "first", TaskType: TaskRetrievalDocument}, {Content: "second", TaskType: TaskRetrievalDocument}},
+		Parameters: &vertexParameters{OutputDimensionality: 2},
+	}, received)
+}
+
+func TestVertexEmbedderTaskTypes(t *testing.T) {
+	var taskTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var received vertexRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		for _, instance := range received.Instances {
+			taskTypes = append(taskTypes, instance.TaskType)
+		}
+		w.Write([]byte(`{"predictions": [{"embeddings": {"values": [0.1, 0.2]}}]}`))
+	}))
+	defer server.Close()
+
+	embedder := NewVertexEmbedder("project", "", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), nil)
+	embedder.BaseURL = server.URL
+	_, err := embedder.Embed([]string{"document"})
+	require.NoError(t, err)
+	query, err := EmbedQuery(embedder, "query")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0.1, 0.2}, query)
+	assert.Equal(t, []string{TaskRetrievalDocument, TaskRetrievalQuery}, taskTypes)
+
+	taskTypes = nil
+	embedder.TaskType = TaskSemanticSimilarity
+	_, err = embedder.Embed([]string{"document"})
+	require.NoError(t, err)
+	_, err = embedder.EmbedQuery("query")
+	require.NoError(t, err)
+	assert.Equal(t, []string{TaskSemanticSimilarity, TaskSemanticSimilarity}, taskTypes, "symmetric tasks embed queries like documents")
+}
@@ -0,0 +1,131 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/sirupsen/logrus"
+)
+
+const OpenAIEmbeddingsURL = "https://api.openai.com/v1/embeddings"
+
+const (
+	OpenAIModelSmall = "text-embedding-3-small"
+	OpenAIModelLarge = "text-embedding-3-large"
+	OpenAIModelAda   = "text-embedding-ada-002"
+)
+
+// openAIMaxBatch is the number of inputs the API takes in one request.
+const openAIMaxBatch = 2048
+
+type OpenAIEmbedder struct {
+	ApiKey string
+	// Model is OpenAIModelSmall when empty.
+	Model string
+	// Dimensions shortens the embeddings of the text-embedding-3 models,
+	// the full size of the model is used when zero.
+	Dimensions int
+	// BatchSize is the number of inputs per request, 2048 when zero.
+	BatchSize int
+	// BaseURL overrides OpenAIEmbeddingsURL, e.g. for a proxy or a test
+	// server.
+	BaseURL string
+	Logger  *logrus.Logger
+}
+
+func NewOpenAIEmbedder(apiKey string, logger *logrus.Logger) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		ApiKey: apiKey,
+		Model:  OpenAIModelSmall,
+		Logger: logger,
+	}
+}
+
+type openAIEmbeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *OpenAIEmbedder) Embed(inputs []string) ([][]float32, error) {
+	size := e.BatchSize
+	if size <= 0 || size > openAIMaxBatch {
+		size = openAIMaxBatch
+	}
+	return inBatches(inputs, size, e.embedBatch)
+}
+
+func (e *OpenAIEmbedder) embedBatch(inputs []string) ([][]float32, error) {
+	model := e.Model
+	if model == "" {
+		model = OpenAIModelSmall
+	}
+	requestBody, err := json.Marshal(openAIEmbeddingRequest{Model: model, Input: inputs, Dimensions: e.Dimensions})
+	if err != nil {
+		return nil, err
+	}
+	url := OpenAIEmbeddingsURL
+	if e.BaseURL != "" {
+		url = e.BaseURL
+	}
+	if e.Logger != nil {
+		e.Logger.WithFields(logrus.Fields{
+			"model":  model,
+			"inputs": len(inputs),
+		}).Debug("OpenAI embeddings request")
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.ApiKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := &http.Client{}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response openAIEmbeddingResponse
+	if resp.StatusCode != http.StatusOK {
+		if json.Unmarshal(body, &response) == nil && response.Error != nil {
+			return nil, client.NewAPIError("OpenAI", resp.StatusCode, response.Error.Message)
+		}
+		return nil, client.NewAPIError("OpenAI", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	embeddings := make([][]float32, len(inputs))
+	for _, data := range response.Data {
+		if data.Index < 0 || data.Index >= len(inputs) {
+			return nil, fmt.Errorf("OpenAI returned an embedding for unknown input %d", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return nil, fmt.Errorf("OpenAI returned no embedding for input %d", i)
+		}
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,222 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/gcpauth"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	VertexModelTextEmbedding004 = "text-embedding-004"
+	VertexModelMultilingual002  = "text-multilingual-embedding-002"
+)
+
+const vertexDefaultLocation = "us-central1"
+
+// vertexMaxBatch is the number of instances text-embedding-004 takes in one
+// request.
+const vertexMaxBatch = 250
+
+// Task types tell Vertex AI what the embeddings are used for. Documents and
+// queries of a retrieval index should use RETRIEVAL_DOCUMENT and
+// RETRIEVAL_QUERY.
+const (
+	TaskRetrievalDocument  = "RETRIEVAL_DOCUMENT"
+	TaskRetrievalQuery     = "RETRIEVAL_QUERY"
+	TaskSemanticSimilarity = "SEMANTIC_SIMILARITY"
+	TaskClassification     = "CLASSIFICATION"
+	TaskClustering         = "CLUSTERING"
+)
+
+type VertexEmbedder struct {
+	// TokenSource issues Vertex AI access tokens. A request rejected with
+	// 401 is retried once with a new token.
+	TokenSource oauth2.TokenSource
+	ProjectId   string
+	// Location is us-central1 when empty.
+	Location string
+	// Model is VertexModelTextEmbedding004 when empty.
+	Model string
+	// Dimensions shortens the embeddings (outputDimensionality), the full
+	// size of the model is used when zero.
+	Dimensions int
+	// TaskType is sent with the inputs of Embed, TaskRetrievalDocument when
+	// empty. QueryTaskType is sent with the query of EmbedQuery; when empty
+	// it is TaskRetrievalQuery for retrieval documents and TaskType for
+	// symmetric tasks such as TaskSemanticSimilarity.
+	TaskType      string
+	QueryTaskType string
+	// BatchSize is the number of inputs per request, 250 when zero.
+	BatchSize int
+	// BaseURL overrides the endpoint derived from Location.
+	BaseURL string
+	Logger  *logrus.Logger
+
+	tokensMu sync.Mutex
+	tokens   *gcpauth.RefreshingTokenSource
+}
+
+func NewVertexEmbedder(projectId string, location string, tokenSource oauth2.TokenSource, logger *logrus.Logger) *VertexEmbedder {
+	return &VertexEmbedder{
+		TokenSource: tokenSource,
+		ProjectId:   projectId,
+		Location:    location,
+		Model:       VertexModelTextEmbedding004,
+		Logger:      logger,
+	}
+}
+
+type vertexInstance struct {
+	Content  string `json:"content"`
+	TaskType string `json:"task_type,omitempty"`
+}
+
+type vertexParameters struct {
+	OutputDimensionality int `json:"outputDimensionality,omitempty"`
+}
+
+type vertexRequest struct {
+	Instances  []vertexInstance  `json:"instances"`
+	Parameters *vertexParameters `json:"parameters,omitempty"`
+}
+
+type vertexResponse struct {
+	Predictions []struct {
+		Embeddings struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	} `json:"predictions"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *VertexEmbedder) Embed(inputs []string) ([][]float32, error) {
+	size := e.BatchSize
+	if size <= 0 || size > vertexMaxBatch {
+		size = vertexMaxBatch
+	}
+	return inBatches(inputs, size, func(batch []string) ([][]float32, error) {
+		return e.embedBatch(batch, e.documentTaskType())
+	})
+}
+
+// EmbedQuery embeds a search query with the query task type, Index.Search
+// and the rag and memory packages use it for their queries.
+func (e *VertexEmbedder) EmbedQuery(query string) ([]float32, error) {
+	embeddings, err := e.embedBatch([]string{query}, e.queryTaskType())
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != 1 {
+		return nil, errors.New("Vertex AI returned no embedding for the query")
+	}
+	return embeddings[0], nil
+}
+
+func (e *VertexEmbedder) documentTaskType() string {
+	if e.TaskType == "" {
+		return TaskRetrievalDocument
+	}
+	return e.TaskType
+}
+
+func (e *VertexEmbedder) queryTaskType() string {
+	if e.QueryTaskType != "" {
+		return e.QueryTaskType
+	}
+	if taskType := e.documentTaskType(); taskType != TaskRetrievalDocument {
+		return taskType
+	}
+	return TaskRetrievalQuery
+}
+
+func (e *VertexEmbedder) embedBatch(inputs []string, taskType string) ([][]float32, error) {
+	if e.TokenSource == nil {
+		return nil, errors.New("Vertex AI embedder needs a token source")
+	}
+	request := vertexRequest{Instances: make([]vertexInstance, len(inputs))}
+	for i, input := range inputs {
+		request.Instances[i] = vertexInstance{Content: input, TaskType: taskType}
+	}
+	if e.Dimensions > 0 {
+		request.Parameters = &vertexParameters{OutputDimensionality: e.Dimensions}
+	}
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	url := e.url()
+	if e.Logger != nil {
+		e.Logger.WithFields(logrus.Fields{
+			"url":    url,
+			"inputs": len(inputs),
+		}).Debug("Vertex AI embeddings request")
+	}
+
+	resp, err := e.tokenSource().Do(&http.Client{}, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var response vertexResponse
+	if resp.StatusCode != http.StatusOK {
+		if json.Unmarshal(body, &response) == nil && response.Error != nil {
+			return nil, client.NewAPIError("Vertex AI", resp.StatusCode, response.Error.Message)
+		}
+		return nil, client.NewAPIError("Vertex AI", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	embeddings := make([][]float32, len(response.Predictions))
+	for i, prediction := range response.Predictions {
+		embeddings[i] = prediction.Embeddings.Values
+	}
+	return embeddings, nil
+}
+
+func (e *VertexEmbedder) url() string {
+	location := e.Location
+	if location == "" {
+		location = vertexDefaultLocation
+	}
+	model := e.Model
+	if model == "" {
+		model = VertexModelTextEmbedding004
+	}
+	baseURL := e.BaseURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", location)
+	}
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/google/models/%s:predict", baseURL, e.ProjectId, location, model)
+}
+
+func (e *VertexEmbedder) tokenSource() *gcpauth.RefreshingTokenSource {
+	e.tokensMu.Lock()
+	defer e.tokensMu.Unlock()
+	if e.tokens == nil {
+		e.tokens = gcpauth.NewRefreshingTokenSource(e.TokenSource)
+	}
+	return e.tokens
+}
@@ -0,0 +1,171 @@
+// Package gcpauth provides OAuth access tokens for Google Cloud APIs used by
+// the Vertex AI providers.
+package gcpauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const CloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// tokenRefreshMargin is how long before expiry a cached token is replaced.
+const tokenRefreshMargin = 5 * time.Minute
+
+// DefaultTokenSource uses Application Default Credentials. Like the source of
+// TokenSourceFromFile it does not cache tokens, wrap it in a
+// RefreshingTokenSource.
+func DefaultTokenSource() (oauth2.TokenSource, error) {
+	ctx := context.Background()
+	creds, err := google.FindDefaultCredentials(ctx, CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find default credentials: %v", err)
+	}
+	return uncachedTokenSource(creds), nil
+}
+
+// TokenSourceFromFile uses a service account JSON key. Every call to Token of
+// the returned source fetches a new token.
+func TokenSourceFromFile(saFilePath string) (oauth2.TokenSource, error) {
+	ctx := context.Background()
+
+	// Read service account file content
+	jsonKey, err := ioutil.ReadFile(saFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account file: %v", err)
+	}
+
+	// Parse the credentials from the JSON key
+	creds, err := google.CredentialsFromJSON(ctx, jsonKey, CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service account credentials: %v", err)
+	}
+	return uncachedTokenSource(creds), nil
+}
+
+// uncachedTokenSource returns a source that fetches a new token on every call.
+// The source of google.Credentials keeps its token until it expires, so a
+// RefreshingTokenSource on top of it could neither refresh early nor replace
+// a rejected token. Credentials without JSON come from the metadata server.
+func uncachedTokenSource(creds *google.Credentials) oauth2.TokenSource {
+	if len(creds.JSON) == 0 {
+		return metadataTokenSource{}
+	}
+	return jsonTokenSource(creds.JSON)
+}
+
+// metadataTokenSource asks the metadata server for the token of the default
+// service account on every call.
+type metadataTokenSource struct{}
+
+func (metadataTokenSource) Token() (*oauth2.Token, error) {
+	query := url.Values{"scopes": {CloudPlatformScope}}
+	body, err := metadata.Get("instance/service-accounts/default/token?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("unable to get token from metadata server: %v", err)
+	}
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		return nil, fmt.Errorf("unable to parse token from metadata server: %v", err)
+	}
+	if res.AccessToken == "" {
+		return nil, fmt.Errorf("metadata server returned no access token")
+	}
+	return &oauth2.Token{
+		AccessToken: res.AccessToken,
+		TokenType:   res.TokenType,
+		Expiry:      time.Now().Add(time.Duration(res.ExpiresIn) * time.Second),
+	}, nil
+}
+
+type jsonTokenSource []byte
+
+func (s jsonTokenSource) Token() (*oauth2.Token, error) {
+	creds, err := google.CredentialsFromJSON(context.Background(), s, CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	return creds.TokenSource.Token()
+}
+
+// RefreshingTokenSource caches a token until shortly before it expires.
+// A token the API rejected can be dropped with Invalidate, the next call to
+// Token then asks the underlying source again. The underlying source must
+// not cache tokens itself, as an oauth2.ReuseTokenSource does.
+type RefreshingTokenSource struct {
+	base oauth2.TokenSource
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func NewRefreshingTokenSource(base oauth2.TokenSource) *RefreshingTokenSource {
+	return &RefreshingTokenSource{base: base}
+}
+
+func (s *RefreshingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && (s.token.Expiry.IsZero() || time.Until(s.token.Expiry) > tokenRefreshMargin) {
+		return s.token, nil
+	}
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain access token: %v", err)
+	}
+	s.token = token
+	return token, nil
+}
+
+// Invalidate drops the cached token if it is still the rejected one, a token
+// refreshed by a concurrent request is kept.
+func (s *RefreshingTokenSource) Invalidate(rejected *oauth2.Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == rejected {
+		s.token = nil
+	}
+}
+
+// Do sends the request made by newRequest with a token of the source. A
+// request rejected with 401 is sent once more with a new token. The response
+// is returned unread whatever its status.
+func (s *RefreshingTokenSource) Do(httpClient *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		token, err := s.Token()
+		if err != nil {
+			return nil, err
+		}
+		token.SetAuthHeader(req)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			s.Invalidate(token)
+			continue
+		}
+		return resp, nil
+	}
+}
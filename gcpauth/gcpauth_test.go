@@ -0,0 +1,114 @@
+package gcpauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2/google"
+)
+
+// newTokenServer issues a new access token for every request.
+func newTokenServer(t *testing.T) (*httptest.Server, *int32) {
+	var issued int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 3600}`, n)
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func writeServiceAccountFile(t *testing.T, tokenURL string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	account, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project",
+		"private_key_id": "key-1",
+		"private_key":    string(keyPEM),
+		"client_email":   "test@project.iam.gserviceaccount.com",
+		"token_uri":      tokenURL,
+	})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	require.NoError(t, os.WriteFile(path, account, 0600))
+	return path
+}
+
+func TestInvalidatedTokenIsFetchedAgain(t *testing.T) {
+	server, issued := newTokenServer(t)
+	base, err := TokenSourceFromFile(writeServiceAccountFile(t, server.URL))
+	require.NoError(t, err)
+	tokens := NewRefreshingTokenSource(base)
+
+	first, err := tokens.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", first.AccessToken)
+	cached, err := tokens.Token()
+	require.NoError(t, err)
+	assert.Same(t, first, cached)
+	assert.Equal(t, int32(1), atomic.LoadInt32(issued))
+
+	tokens.Invalidate(first)
+	second, err := tokens.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", second.AccessToken, "the base source is asked again")
+	assert.Equal(t, int32(2), atomic.LoadInt32(issued))
+}
+
+func TestDoRetriesWithNewToken(t *testing.T) {
+	tokenServer, _ := newTokenServer(t)
+	base, err := TokenSourceFromFile(writeServiceAccountFile(t, tokenServer.URL))
+	require.NoError(t, err)
+	tokens := NewRefreshingTokenSource(base)
+
+	var authorizations []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if len(authorizations) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer api.Close()
+
+	resp, err := tokens.Do(api.Client(), func() (*http.Request, error) {
+		return http.NewRequest("POST", api.URL, nil)
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, authorizations)
+}
+
+func TestMetadataTokensAreNotCached(t *testing.T) {
+	server, issued := newTokenServer(t)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+	tokens := NewRefreshingTokenSource(uncachedTokenSource(&google.Credentials{}))
+
+	first, err := tokens.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", first.AccessToken)
+
+	tokens.Invalidate(first)
+	second, err := tokens.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", second.AccessToken, "the metadata server is asked again")
+	assert.Equal(t, int32(2), atomic.LoadInt32(issued))
+}
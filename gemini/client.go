@@ -0,0 +1,465 @@
+package gemini
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/gcpauth"
+	"github.com/assistant-ai/llmchat-client/internal/sse"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const geminiUserRole = "user"
+const geminiModelRole = "model"
+
+// GeminiClient talks to Gemini either through Google AI Studio (when ApiKey
+// is set) or through Vertex AI with OAuth tokens from TokenSource.
+type GeminiClient struct {
+	ApiKey string
+	// TokenSource issues Vertex AI access tokens. Tokens are refreshed
+	// shortly before they expire and a request rejected with 401 is retried
+	// once with a new token.
+	TokenSource oauth2.TokenSource
+	ProjectId   string
+	// Location is the Vertex AI region, DefaultLocation when empty.
+	Location string
+	// BaseURL overrides the endpoint, e.g. for a proxy or a test server.
+	BaseURL          string
+	Model            *GeminiModel
+	GenerationConfig *GenerationConfig
+	SafetySettings   []SafetySetting
+	Logger           *logrus.Logger
+
+	tokensMu sync.Mutex
+	tokens   *gcpauth.RefreshingTokenSource
+}
+
+// NewGeminiClient creates a client for Google AI Studio.
+func NewGeminiClient(apiKey string, model *GeminiModel, logger *logrus.Logger) *client.Client {
+	return newClient(&GeminiClient{
+		ApiKey: apiKey,
+		Model:  model,
+		Logger: logger,
+	})
+}
+
+// NewVertexGeminiClient creates a Vertex AI client with Application Default
+// Credentials.
+func NewVertexGeminiClient(projectId string, location string, model *GeminiModel, logger *logrus.Logger) (*client.Client, error) {
+	tokenSource, err := gcpauth.DefaultTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	return NewVertexGeminiClientWithTokenSource(projectId, location, tokenSource, model, logger), nil
+}
+
+// NewVertexGeminiClientFromFile creates a Vertex AI client with a service
+// account JSON key.
+func NewVertexGeminiClientFromFile(projectId string, location string, serviceAccountJsonPath string, model *GeminiModel, logger *logrus.Logger) (*client.Client, error) {
+	tokenSource, err := gcpauth.TokenSourceFromFile(serviceAccountJsonPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewVertexGeminiClientWithTokenSource(projectId, location, tokenSource, model, logger), nil
+}
+
+func NewVertexGeminiClientWithTokenSource(projectId string, location string, tokenSource oauth2.TokenSource, model *GeminiModel, logger *logrus.Logger) *client.Client {
+	return newClient(&GeminiClient{
+		TokenSource: tokenSource,
+		ProjectId:   projectId,
+		Location:    location,
+		Model:       model,
+		Logger:      logger,
+	})
+}
+
+func newClient(geminiClient *GeminiClient) *client.Client {
+	return &client.Client{
+		Client:         geminiClient,
+		ContextDepth:   8,
+		DefaultContext: "",
+		Logger:         geminiClient.Logger,
+	}
+}
+
+// supportedOptions are the request options that map onto GenerationConfig.
+var supportedOptions = []string{
+	client.OptionTemperature,
+	client.OptionTopP,
+	client.OptionTopK,
+	client.OptionMaxTokens,
+	client.OptionStop,
+	client.OptionSeed,
+	client.OptionPresencePenalty,
+	client.OptionFrequencyPenalty,
+}
+
+func (c *GeminiClient) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return c.send(messages, context, nil, nil)
+}
+
+// SendMessagesWithOptions overrides GenerationConfig for one request.
+func (c *GeminiClient) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	return c.send(messages, context, nil, options)
+}
+
+// SendMessagesWithFormat asks for a JSON answer with the application/json
+// response MIME type. The schema itself reaches the model through the
+// context only, Gemini accepts a subset of JSON schema.
+func (c *GeminiClient) SendMessagesWithFormat(messages []db.Message, context []string, format *client.ResponseFormat) ([]db.Message, error) {
+	return c.send(messages, context, format, nil)
+}
+
+func (c *GeminiClient) send(messages []db.Message, context []string, format *client.ResponseFormat, options *client.RequestOptions) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, options)
+	if err != nil {
+		return nil, err
+	}
+	if format != nil {
+		config := GenerationConfig{}
+		if request.GenerationConfig != nil {
+			config = *request.GenerationConfig
+		}
+		config.ResponseMimeType = "application/json"
+		request.GenerationConfig = &config
+	}
+	resp, err := c.post(c.url(false), request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response GenerateContentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	answer := &answerBuilder{}
+	if err := answer.add(&response, nil); err != nil {
+		return nil, err
+	}
+	return answer.appendTo(messages, c.modelName())
+}
+
+// StreamMessages uses streamGenerateContent and calls onChunk with each part
+// of the answer.
+func (c *GeminiClient) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	return c.StreamMessagesWithOptions(messages, context, nil, onChunk)
+}
+
+func (c *GeminiClient) StreamMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions, onChunk func(chunk string) error) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, options)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post(c.url(true), request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	answer := &answerBuilder{}
+	events := sse.NewReader(resp.Body)
+	for {
+		event, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			if answer.metadata.FinishReason == "" {
+				// The last chunk carries the finish reason, without it the
+				// answer would be truncated.
+				return nil, &client.APIError{Provider: "Gemini", StatusCode: resp.StatusCode, Message: "stream ended without a finish reason", Retryable: true}
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var chunk GenerateContentResponse
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			return nil, fmt.Errorf("unable to parse Gemini stream event: %v", err)
+		}
+		if err := answer.add(&chunk, onChunk); err != nil {
+			return nil, err
+		}
+	}
+	return answer.appendTo(messages, c.modelName())
+}
+
+// answerBuilder collects the answer from one response or from the chunks of a
+// stream, the last chunk carries the finish reason and the usage.
+type answerBuilder struct {
+	text     strings.Builder
+	metadata db.MessageMetadata
+	received bool
+}
+
+func (a *answerBuilder) add(response *GenerateContentResponse, onChunk func(chunk string) error) error {
+	if len(response.Candidates) == 0 {
+		if response.PromptFeedback.BlockReason != "" {
+			return fmt.Errorf("Gemini blocked the prompt: %s", response.PromptFeedback.BlockReason)
+		}
+	} else {
+		a.received = true
+		candidate := response.Candidates[0]
+		for _, part := range candidate.Content.Parts {
+			a.text.WriteString(part.Text)
+			if onChunk != nil && part.Text != "" {
+				if err := onChunk(part.Text); err != nil {
+					return err
+				}
+			}
+		}
+		if candidate.FinishReason != "" {
+			a.metadata.FinishReason = candidate.FinishReason
+		}
+	}
+	if usage := response.UsageMetadata; usage.TotalTokenCount > 0 {
+		a.metadata.PromptTokens = usage.PromptTokenCount
+		a.metadata.CompletionTokens = usage.CandidatesTokenCount
+		a.metadata.TotalTokens = usage.TotalTokenCount
+	}
+	if response.ModelVersion != "" {
+		a.metadata.Model = response.ModelVersion
+	}
+	return nil
+}
+
+func (a *answerBuilder) appendTo(messages []db.Message, modelName string) ([]db.Message, error) {
+	if !a.received {
+		return nil, errors.New("no candidates in Gemini response")
+	}
+	newMessage := db.CreateNewMessage(db.AssistentRoleNeam, a.text.String(), messages[0].ContextId)
+	newMessage.Metadata = a.metadata
+	newMessage.Metadata.Provider = ProviderName
+	if newMessage.Metadata.Model == "" {
+		newMessage.Metadata.Model = modelName
+	}
+	return append(messages, newMessage), nil
+}
+
+// buildRequest sends context and system messages as the system instruction
+// and the conversation as alternating user and model turns.
+func (c *GeminiClient) buildRequest(messages []db.Message, context []string, options *client.RequestOptions) (*GenerateContentRequest, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("no messages to send")
+	}
+	if err := options.Check(ProviderName, c.Logger, supportedOptions...); err != nil {
+		return nil, err
+	}
+	system := make([]Part, 0)
+	for _, contextMsg := range context {
+		system = append(system, Part{Text: contextMsg})
+	}
+	contents := make([]Content, 0, len(messages))
+	for _, msg := range messages {
+		role := geminiUserRole
+		switch msg.Role {
+		case db.SystemRoleName:
+			system = append(system, Part{Text: msg.Content})
+			continue
+		case db.AssistentRoleNeam:
+			role = geminiModelRole
+		}
+		if len(contents) == 0 && role != geminiUserRole {
+			continue
+		}
+		parts, err := messageParts(msg)
+		if err != nil {
+			return nil, err
+		}
+		if len(contents) > 0 && contents[len(contents)-1].Role == role {
+			last := &contents[len(contents)-1]
+			last.Parts = append(last.Parts, parts...)
+			continue
+		}
+		contents = append(contents, Content{Role: role, Parts: parts})
+	}
+	if len(contents) == 0 {
+		return nil, errors.New("no user message to send")
+	}
+
+	request := &GenerateContentRequest{
+		Contents:         contents,
+		GenerationConfig: c.generationConfig(options),
+		SafetySettings:   c.SafetySettings,
+	}
+	if len(system) > 0 {
+		request.SystemInstruction = &Content{Parts: system}
+	}
+	return request, nil
+}
+
+// generationConfig is GenerationConfig with the options applied.
+func (c *GeminiClient) generationConfig(options *client.RequestOptions) *GenerationConfig {
+	if options == nil {
+		return c.GenerationConfig
+	}
+	config := GenerationConfig{}
+	if c.GenerationConfig != nil {
+		config = *c.GenerationConfig
+	}
+	if options.Temperature != nil {
+		config.Temperature = options.Temperature
+	}
+	if options.TopP != nil {
+		config.TopP = options.TopP
+	}
+	if options.TopK != nil {
+		config.TopK = options.TopK
+	}
+	if options.MaxTokens > 0 {
+		config.MaxOutputTokens = options.MaxTokens
+	}
+	if len(options.Stop) > 0 {
+		config.StopSequences = options.Stop
+	}
+	if options.Seed != nil {
+		config.Seed = options.Seed
+	}
+	if options.PresencePenalty != nil {
+		config.PresencePenalty = options.PresencePenalty
+	}
+	if options.FrequencyPenalty != nil {
+		config.FrequencyPenalty = options.FrequencyPenalty
+	}
+	return &config
+}
+
+// messageParts converts the parts of a multi-part message. Image data is sent
+// inline, an image URL as file data with the MIME type of its extension.
+func messageParts(msg db.Message) ([]Part, error) {
+	if len(msg.Parts) == 0 {
+		return []Part{{Text: msg.Content}}, nil
+	}
+	parts := make([]Part, 0, len(msg.Parts))
+	for _, part := range msg.Parts {
+		switch {
+		case part.Type == db.ContentPartText:
+			parts = append(parts, Part{Text: part.Text})
+		case part.Type == db.ContentPartImage && part.Image != nil && len(part.Image.Data) > 0:
+			parts = append(parts, Part{InlineData: &InlineData{
+				MimeType: part.Image.MIMEType,
+				Data:     base64.StdEncoding.EncodeToString(part.Image.Data),
+			}})
+		case part.Type == db.ContentPartImage && part.Image != nil:
+			mimeType := part.Image.MIMEType
+			if mimeType == "" {
+				mimeType = mime.TypeByExtension(path.Ext(part.Image.URL))
+			}
+			if mimeType == "" {
+				return nil, fmt.Errorf("unknown MIME type of image %s", part.Image.URL)
+			}
+			parts = append(parts, Part{FileData: &FileData{MimeType: mimeType, FileUri: part.Image.URL}})
+		default:
+			return nil, fmt.Errorf("unsupported message part %q", part.Type)
+		}
+	}
+	return parts, nil
+}
+
+func (c *GeminiClient) modelName() string {
+	if c.Model == nil {
+		return ModelGemini15Flash.Name
+	}
+	return c.Model.Name
+}
+
+func (c *GeminiClient) url(stream bool) string {
+	method := "generateContent"
+	if stream {
+		method = "streamGenerateContent?alt=sse"
+	}
+	if c.ApiKey != "" {
+		baseURL := c.BaseURL
+		if baseURL == "" {
+			baseURL = AIStudioURL
+		}
+		return fmt.Sprintf("%s/v1beta/models/%s:%s", baseURL, c.modelName(), method)
+	}
+	location := c.Location
+	if location == "" {
+		location = DefaultLocation
+	}
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", location)
+	}
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/google/models/%s:%s", baseURL, c.ProjectId, location, c.modelName(), method)
+}
+
+func (c *GeminiClient) tokenSource() *gcpauth.RefreshingTokenSource {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	if c.tokens == nil {
+		c.tokens = gcpauth.NewRefreshingTokenSource(c.TokenSource)
+	}
+	return c.tokens
+}
+
+// post sends the request and returns the response if it succeeded. Vertex AI
+// requests rejected with 401 are retried once with a new token.
+func (c *GeminiClient) post(url string, request *GenerateContentRequest) (*http.Response, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"url":         url,
+			"requestBody": string(requestBody),
+		}).Debug("Gemini request")
+	}
+	if c.ApiKey == "" && c.TokenSource == nil {
+		return nil, errors.New("Gemini client needs an API key or a token source")
+	}
+
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.ApiKey != "" {
+			req.Header.Set("x-goog-api-key", c.ApiKey)
+		}
+		return req, nil
+	}
+	httpClient := &http.Client{}
+	var resp *http.Response
+	if c.ApiKey != "" {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err = httpClient.Do(req)
+	} else {
+		resp, err = c.tokenSource().Do(httpClient, newRequest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var apiError errorResponse
+	if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
+		return nil, client.NewAPIError("Gemini", resp.StatusCode, apiError.Error.Message)
+	}
+	return nil, client.NewAPIError("Gemini", resp.StatusCode, string(body))
+}
@@ -0,0 +1,214 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSendMessagesWithApiKey(t *testing.T) {
+	var received GenerateContentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1beta/models/gemini-1.5-pro:generateContent", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("x-goog-api-key"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{
+			"candidates": [{"content": {"role": "model", "parts": [{"text": "Paris"}]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 1, "totalTokenCount": 13},
+			"modelVersion": "gemini-1.5-pro-002"
+		}`))
+	}))
+	defer server.Close()
+
+	temperature := 0.1
+	c := &GeminiClient{
+		ApiKey:           "key",
+		BaseURL:          server.URL,
+		Model:            ModelGemini15Pro,
+		GenerationConfig: &GenerationConfig{Temperature: &temperature, MaxOutputTokens: 100},
+		SafetySettings:   []SafetySetting{{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"}},
+	}
+	history := []db.Message{
+		db.CreateNewMessage(db.UserRoleName, "Hi", "ctx"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "Hello!", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "I am planning a trip.", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "Capital of France?", "ctx"),
+	}
+
+	result, err := c.SendMessages(history, []string{"Answer with one word."})
+	require.NoError(t, err)
+	require.Len(t, result, len(history)+1)
+	answer := result[len(result)-1]
+	assert.Equal(t, "Paris", answer.Content)
+	assert.Equal(t, db.AssistentRoleNeam, answer.Role)
+	assert.Equal(t, db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            "gemini-1.5-pro-002",
+		FinishReason:     "STOP",
+		PromptTokens:     12,
+		CompletionTokens: 1,
+		TotalTokens:      13,
+	}, answer.Metadata)
+
+	assert.Equal(t, &Content{Parts: []Part{{Text: "Answer with one word."}}}, received.SystemInstruction)
+	assert.Equal(t, []Content{
+		{Role: "user", Parts: []Part{{Text: "Hi"}}},
+		{Role: "model", Parts: []Part{{Text: "Hello!"}}},
+		{Role: "user", Parts: []Part{{Text: "I am planning a trip."}, {Text: "Capital of France?"}}},
+	}, received.Contents)
+	assert.Equal(t, c.GenerationConfig, received.GenerationConfig)
+	assert.Equal(t, c.SafetySettings, received.SafetySettings)
+}
+
+func TestTruncatedStreamIsAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"Hel\"}]}}]}\r\n\r\n")
+	}))
+	defer server.Close()
+
+	c := &GeminiClient{ApiKey: "key", BaseURL: server.URL}
+	_, err := c.StreamMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil, func(string) error { return nil })
+	assert.EqualError(t, err, "error response from Gemini (200): stream ended without a finish reason")
+	assert.True(t, client.IsRetryable(err))
+}
+
+func TestStreamMessagesOnVertex(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") == "Bearer expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": 401, "message": "invalid credentials"}}`))
+			return
+		}
+		assert.Equal(t, "/v1/projects/project/locations/europe-west4/publishers/google/models/gemini-1.5-flash:streamGenerateContent", r.URL.Path)
+		assert.Equal(t, "sse", r.URL.Query().Get("alt"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hel"}]}}]}`,
+			`{"candidates": [{"content": {"role": "model", "parts": [{"text": "lo"}]}}]}`,
+			`{"candidates": [{"content": {"role": "model", "parts": [{"text": "!"}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 3, "candidatesTokenCount": 2, "totalTokenCount": 5}}`,
+		} {
+			fmt.Fprintf(w, "data: %s\r\n\r\n", chunk)
+		}
+	}))
+	defer server.Close()
+
+	issued := 0
+	tokenSource := tokenSourceFunc(func() (*oauth2.Token, error) {
+		issued++
+		if issued == 1 {
+			return &oauth2.Token{AccessToken: "expired"}, nil
+		}
+		return &oauth2.Token{AccessToken: "fresh"}, nil
+	})
+	c := NewVertexGeminiClientWithTokenSource("project", "europe-west4", tokenSource, ModelGemini15Flash, nil)
+	c.Client.(*GeminiClient).BaseURL = server.URL
+	c.Store = newTestStore(t)
+
+	chunks := make([]string, 0)
+	answer, err := c.StreamMessage("Hi", "ctx", func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello!", answer)
+	assert.Equal(t, []string{"Hel", "lo", "!"}, chunks)
+	assert.Equal(t, []string{"Bearer expired", "Bearer fresh"}, tokens)
+
+	stored, err := c.Store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            "gemini-1.5-flash",
+		FinishReason:     "STOP",
+		PromptTokens:     3,
+		CompletionTokens: 2,
+		TotalTokens:      5,
+	}, stored[1].Metadata)
+}
+
+func TestBlockedPromptIsAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"promptFeedback": {"blockReason": "SAFETY"}}`))
+	}))
+	defer server.Close()
+
+	c := &GeminiClient{ApiKey: "key", BaseURL: server.URL}
+	_, err := c.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil)
+	assert.EqualError(t, err, "Gemini blocked the prompt: SAFETY")
+}
+
+func TestApiErrorsAreReported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"code": 400, "message": "API key not valid", "status": "INVALID_ARGUMENT"}}`))
+	}))
+	defer server.Close()
+
+	c := &GeminiClient{ApiKey: "key", BaseURL: server.URL}
+	_, err := c.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil)
+	assert.EqualError(t, err, "error response from Gemini (400): API key not valid")
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+func TestImagePartsAreSent(t *testing.T) {
+	var received GenerateContentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "A cat."}]}, "finishReason": "STOP"}]}`))
+	}))
+	defer server.Close()
+
+	image, err := db.NewImageDataPart([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), db.ImageDetailAuto)
+	require.NoError(t, err)
+	message := db.CreateNewMultipartMessage(db.UserRoleName, "ctx",
+		db.NewTextPart("What is this?"), image, db.NewImageURLPart("gs://bucket/dog.jpg", ""))
+	c := &GeminiClient{ApiKey: "key", BaseURL: server.URL, Model: ModelGemini15Flash}
+	_, err = c.SendMessages([]db.Message{message}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Content{{Role: "user", Parts: []Part{
+		{Text: "What is this?"},
+		{InlineData: &InlineData{MimeType: "image/png", Data: "iVBORw0KGgoAAAANSUhEUg=="}},
+		{FileData: &FileData{MimeType: "image/jpeg", FileUri: "gs://bucket/dog.jpg"}},
+	}}}, received.Contents)
+}
+
+func TestRequestOptionsOverrideGenerationConfig(t *testing.T) {
+	temperature := 0.1
+	override := 0.9
+	seed := 3
+	c := &GeminiClient{GenerationConfig: &GenerationConfig{Temperature: &temperature, MaxOutputTokens: 100}}
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}
+
+	request, err := c.buildRequest(messages, nil, &client.RequestOptions{Temperature: &override, Seed: &seed, Stop: []string{"."}})
+	require.NoError(t, err)
+	assert.Equal(t, &GenerationConfig{Temperature: &override, MaxOutputTokens: 100, Seed: &seed, StopSequences: []string{"."}}, request.GenerationConfig)
+	assert.Equal(t, 0.1, *c.GenerationConfig.Temperature, "the client config is not changed")
+
+	_, err = c.buildRequest(messages, nil, &client.RequestOptions{User: "u1"})
+	assert.EqualError(t, err, "gemini does not support the options user")
+}
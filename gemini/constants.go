@@ -0,0 +1,40 @@
+package gemini
+
+// ProviderName identifies Gemini answers in db.MessageMetadata.
+const ProviderName = "gemini"
+
+const DefaultLocation = "us-central1"
+
+const AIStudioURL = "https://generativelanguage.googleapis.com"
+
+type GeminiModel struct {
+	Name            string `json:"name"`
+	MaxInputTokens  int    `json:"max_input_tokens"`
+	MaxOutputTokens int    `json:"max_output_tokens"`
+}
+
+var ModelGemini10Pro = &GeminiModel{
+	Name:            "gemini-1.0-pro",
+	MaxInputTokens:  30720,
+	MaxOutputTokens: 8192,
+}
+
+var ModelGemini15Pro = &GeminiModel{
+	Name:            "gemini-1.5-pro",
+	MaxInputTokens:  2097152,
+	MaxOutputTokens: 8192,
+}
+
+var ModelGemini15Flash = &GeminiModel{
+	Name:            "gemini-1.5-flash",
+	MaxInputTokens:  1048576,
+	MaxOutputTokens: 8192,
+}
+
+func GetGeminiModels() map[string]*GeminiModel {
+	models := make(map[string]*GeminiModel)
+	for _, model := range []*GeminiModel{ModelGemini10Pro, ModelGemini15Pro, ModelGemini15Flash} {
+		models[model.Name] = model
+	}
+	return models
+}
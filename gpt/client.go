@@ -20,6 +20,8 @@ type GptClient struct {
 	Model     *GPTModel
 	MaxTokens int
 	Logger    *logrus.Logger
+	// BaseURL overrides API_URL, e.g. for a proxy or a test server.
+	BaseURL string
 }
 
 func NewDefaultGptClient(openAiKey string, logger *logrus.Logger) *client.Client {
@@ -56,7 +58,45 @@ func NewGptClientFromFile(openAiKeyFlePath string, contextDepth int, model *GPTM
 	return NewGptClient(strings.ReplaceAll(string(b), "\n", ""), contextDepth, model, defaultContext, maxTokens, logger), nil
 }
 
+// supportedOptions are the request options of the chat completions API.
+var supportedOptions = []string{
+	client.OptionTemperature,
+	client.OptionTopP,
+	client.OptionMaxTokens,
+	client.OptionStop,
+	client.OptionSeed,
+	client.OptionPresencePenalty,
+	client.OptionFrequencyPenalty,
+	client.OptionLogitBias,
+	client.OptionUser,
+}
+
 func (g *GptClient) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return g.send(messages, context, nil, nil, nil)
+}
+
+// SendMessagesWithFormat sets response_format of the request when the model
+// has a JSON mode, other models get the format instructions of the context
+// only.
+func (g *GptClient) SendMessagesWithFormat(messages []db.Message, context []string, format *client.ResponseFormat) ([]db.Message, error) {
+	return g.send(messages, context, format, nil, nil)
+}
+
+// SendMessagesWithOptions supports every option but top_k.
+func (g *GptClient) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	if err := options.Check(ProviderName, g.Logger, supportedOptions...); err != nil {
+		return nil, err
+	}
+	return g.send(messages, context, nil, options, nil)
+}
+
+// SendMessagesWithTools offers the tools as functions, the answer has
+// ToolCalls when the model calls them.
+func (g *GptClient) SendMessagesWithTools(messages []db.Message, context []string, tools []client.Tool) ([]db.Message, error) {
+	return g.send(messages, context, nil, nil, tools)
+}
+
+func (g *GptClient) send(messages []db.Message, context []string, format *client.ResponseFormat, options *client.RequestOptions, tools []client.Tool) ([]db.Message, error) {
 	contextId := messages[0].ContextId
 	for _, contextMsg := range context {
 		if g.Logger != nil {
@@ -66,7 +106,7 @@ func (g *GptClient) SendMessages(messages []db.Message, context []string) ([]db.
 		}
 		messages = append(messages, db.CreateNewMessage(db.SystemRoleName, contextMsg, contextId))
 	}
-	requestBody, err := g.prepareGPTRequestBody(messages)
+	requestBody, err := g.prepareGPTRequestBody(messages, format, options, tools)
 	if err != nil {
 		return nil, err
 	}
@@ -82,13 +122,32 @@ func (g *GptClient) SendMessages(messages []db.Message, context []string) ([]db.
 func addGPTResponse(response *GptChatCompletionMessage, messages []db.Message) ([]db.Message, error) {
 	gpt4Text := response.Choices[0].Message.Content
 	newMessage := db.CreateNewMessage(db.AssistentRoleNeam, gpt4Text, messages[0].ContextId)
+	for _, call := range response.Choices[0].Message.ToolCalls {
+		newMessage.ToolCalls = append(newMessage.ToolCalls, db.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	newMessage.Metadata = db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            response.Model,
+		FinishReason:     response.Choices[0].FinishReason,
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+	}
 	messages = append(messages, newMessage)
 
 	return messages, nil
 }
 
 func (g *GptClient) sendGPTRequest(requestBody []byte) (*GptChatCompletionMessage, error) {
-	req, err := http.NewRequest("POST", API_URL, bytes.NewBuffer(requestBody))
+	url := API_URL
+	if g.BaseURL != "" {
+		url = g.BaseURL
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, err
 	}
@@ -96,8 +155,8 @@ func (g *GptClient) sendGPTRequest(requestBody []byte) (*GptChatCompletionMessag
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.OpenAiKey))
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	httpClient := &http.Client{}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -109,6 +168,13 @@ func (g *GptClient) sendGPTRequest(requestBody []byte) (*GptChatCompletionMessag
 		return nil, err
 	}
 	var decodedString = string(bodyBytes)
+	if resp.StatusCode != http.StatusOK {
+		var apiError gptErrorResponse
+		if json.Unmarshal(bodyBytes, &apiError) == nil && apiError.Error.Message != "" {
+			return nil, client.NewAPIError("GPT", resp.StatusCode, apiError.Error.Message)
+		}
+		return nil, client.NewAPIError("GPT", resp.StatusCode, decodedString)
+	}
 	reader := strings.NewReader(decodedString)
 	if err := json.NewDecoder(reader).Decode(&response); err != nil {
 		return nil, err
@@ -121,21 +187,45 @@ func (g *GptClient) sendGPTRequest(requestBody []byte) (*GptChatCompletionMessag
 	return &response, nil
 }
 
-func sumOfTokensAcrossAllMessages(messages []map[string]string) int {
-	tokens := 0
+// Images are billed by size, imageTokens is what a low detail image costs and
+// an upper estimate for the other detail levels.
+const (
+	lowDetailImageTokens = 85
+	imageTokens          = 765
+)
+
+func sumOfTokensAcrossAllMessages(messages []map[string]interface{}) int {
+	chars := 0
+	images := 0
 	for _, message := range messages {
-		tokens += len(message["content"])
-		tokens += len(message["role"])
+		chars += len(message["role"].(string))
+		switch content := message["content"].(type) {
+		case string:
+			chars += len(content)
+		case []map[string]interface{}:
+			for _, part := range content {
+				if text, ok := part["text"].(string); ok {
+					chars += len(text)
+				} else if image, ok := part["image_url"].(map[string]string); ok && image["detail"] == db.ImageDetailLow {
+					images += lowDetailImageTokens
+				} else {
+					images += imageTokens
+				}
+			}
+		}
 	}
-	return tokens / 3
+	return chars/3 + images
 }
 
-func (g *GptClient) prepareGPTRequestBody(messages []db.Message) ([]byte, error) {
+func (g *GptClient) prepareGPTRequestBody(messages []db.Message, format *client.ResponseFormat, options *client.RequestOptions, tools []client.Tool) ([]byte, error) {
 	gptMessages := convertMessagesToMaps(messages)
 	tokens := sumOfTokensAcrossAllMessages(gptMessages)
 	maxTokens := g.MaxTokens
+	if options != nil && options.MaxTokens > 0 {
+		maxTokens = options.MaxTokens
+	}
 	model := g.Model
-	if tokens+g.MaxTokens >= g.Model.MaxTokens {
+	if tokens+maxTokens >= g.Model.MaxTokens {
 		maxTokens = model.MaxTokens - tokens
 		// Disabled since GPT4 big is not yet available
 		// if g.Model == ModelGPT4 && tokens > g.Model.MaxTokens/2 {
@@ -148,12 +238,20 @@ func (g *GptClient) prepareGPTRequestBody(messages []db.Message) ([]byte, error)
 		return nil, errors.New("Not enough tokens")
 	}
 
-	requestBody, err := json.Marshal(map[string]interface{}{
+	body := map[string]interface{}{
 		"messages":   gptMessages,
 		"max_tokens": maxTokens,
 		"n":          1,
 		"model":      model.Name,
-	})
+	}
+	if format := responseFormat(model, format); format != nil {
+		body["response_format"] = format
+	}
+	if len(tools) > 0 {
+		body["tools"] = convertTools(tools)
+	}
+	addOptions(body, options)
+	requestBody, err := json.Marshal(body)
 
 	if g.Logger != nil {
 		g.Logger.WithFields(logrus.Fields{
@@ -168,18 +266,127 @@ func (g *GptClient) prepareGPTRequestBody(messages []db.Message) ([]byte, error)
 	return requestBody, nil
 }
 
-func convertMessagesToMaps(messages []db.Message) []map[string]string {
-	gptMessages := make([]map[string]string, len(messages))
+func addOptions(body map[string]interface{}, options *client.RequestOptions) {
+	if options == nil {
+		return
+	}
+	if options.Temperature != nil {
+		body["temperature"] = *options.Temperature
+	}
+	if options.TopP != nil {
+		body["top_p"] = *options.TopP
+	}
+	if len(options.Stop) > 0 {
+		body["stop"] = options.Stop
+	}
+	if options.Seed != nil {
+		body["seed"] = *options.Seed
+	}
+	if options.PresencePenalty != nil {
+		body["presence_penalty"] = *options.PresencePenalty
+	}
+	if options.FrequencyPenalty != nil {
+		body["frequency_penalty"] = *options.FrequencyPenalty
+	}
+	if len(options.LogitBias) > 0 {
+		body["logit_bias"] = options.LogitBias
+	}
+	if options.User != "" {
+		body["user"] = options.User
+	}
+}
+
+// responseFormat returns the response_format the model accepts, json_schema
+// falls back to json_object on models without structured outputs.
+func responseFormat(model *GPTModel, format *client.ResponseFormat) map[string]interface{} {
+	if format == nil || !model.JSONMode {
+		return nil
+	}
+	if format.Type != client.ResponseFormatJSONSchema || !model.JSONSchema {
+		return map[string]interface{}{"type": client.ResponseFormatJSONObject}
+	}
+	return map[string]interface{}{
+		"type": client.ResponseFormatJSONSchema,
+		"json_schema": map[string]interface{}{
+			"name":   format.Name,
+			"schema": format.Schema,
+			"strict": format.Strict,
+		},
+	}
+}
+
+func convertMessagesToMaps(messages []db.Message) []map[string]interface{} {
+	gptMessages := make([]map[string]interface{}, len(messages))
 
 	for i, message := range messages {
-		formattedTimestamp := message.Timestamp.Format("2006-01-02 15:04:05")
-		combinedContent := fmt.Sprintf("%s: %s", formattedTimestamp, message.Content)
+		var content interface{} = message.Content
+		if len(message.Parts) > 0 {
+			content = convertParts(message.Parts)
+		}
 
-		gptMessages[i] = map[string]string{
+		gptMessages[i] = map[string]interface{}{
 			"role":    message.Role,
-			"content": combinedContent,
+			"content": content,
+		}
+		if len(message.ToolCalls) > 0 {
+			gptMessages[i]["tool_calls"] = convertToolCalls(message.ToolCalls)
+		}
+		if message.ToolCallId != "" {
+			gptMessages[i]["tool_call_id"] = message.ToolCallId
 		}
 	}
 
 	return gptMessages
 }
+
+// convertParts converts a multi-part message to content parts.
+func convertParts(parts []db.ContentPart) []map[string]interface{} {
+	gptParts := make([]map[string]interface{}, 0, len(parts))
+	for _, part := range parts {
+		switch {
+		case part.Type == db.ContentPartText:
+			gptParts = append(gptParts, map[string]interface{}{"type": "text", "text": part.Text})
+		case part.Type == db.ContentPartImage && part.Image != nil:
+			imageURL := map[string]string{"url": part.Image.DataURL()}
+			if part.Image.Detail != "" {
+				imageURL["detail"] = part.Image.Detail
+			}
+			gptParts = append(gptParts, map[string]interface{}{"type": "image_url", "image_url": imageURL})
+		}
+	}
+	return gptParts
+}
+
+func convertTools(tools []client.Tool) []map[string]interface{} {
+	gptTools := make([]map[string]interface{}, len(tools))
+	for i, tool := range tools {
+		parameters := tool.Parameters
+		if parameters == nil {
+			parameters = client.Schema{"type": "object", "properties": client.Schema{}}
+		}
+		gptTools[i] = map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  parameters,
+			},
+		}
+	}
+	return gptTools
+}
+
+func convertToolCalls(calls []db.ToolCall) []map[string]interface{} {
+	gptCalls := make([]map[string]interface{}, len(calls))
+	for i, call := range calls {
+		gptCalls[i] = map[string]interface{}{
+			"id":   call.ID,
+			"type": "function",
+			"function": map[string]string{
+				"name":      call.Name,
+				"arguments": call.Arguments,
+			},
+		}
+	}
+	return gptCalls
+}
@@ -1,16 +1,27 @@
 package gpt
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSendMessage(t *testing.T) {
 	// Replace the API key with your OpenAI API key
 	apiKeyFilePath := filepath.Join(os.Getenv("HOME"), ".open-ai.key")
+	if _, err := os.Stat(apiKeyFilePath); err != nil {
+		t.Skipf("OpenAI key file is not available: %v", err)
+	}
 	contextDepth := 5
-	client, err := NewGptClientFromFile(apiKeyFilePath, contextDepth, ModelGPT4, "", 8000)
+	client, err := NewGptClientFromFile(apiKeyFilePath, contextDepth, ModelGPT4, "", 8000, nil)
 
 	if err != nil {
 		t.Fatalf("Failed to send message: %v", err)
@@ -31,3 +42,129 @@ func TestSendMessage(t *testing.T) {
 		t.Fatalf("Assistant response is empty")
 	}
 }
+
+func TestMultipartMessagesAreSentAsContentParts(t *testing.T) {
+	image, err := db.NewImageDataPart([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), db.ImageDetailLow)
+	require.NoError(t, err)
+	message := db.CreateNewMultipartMessage(db.UserRoleName, "ctx",
+		db.NewTextPart("What is this?"), image, db.NewImageURLPart("https://example.com/dog.jpg", ""))
+
+	gptMessages := convertMessagesToMaps([]db.Message{message})
+	body, err := json.Marshal(gptMessages)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"role": "user", "content": [
+		{"type": "text", "text": "What is this?"},
+		{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgoAAAANSUhEUg==", "detail": "low"}},
+		{"type": "image_url", "image_url": {"url": "https://example.com/dog.jpg"}}
+	]}]`, string(body))
+	assert.Equal(t, (len("user")+len("What is this?"))/3+lowDetailImageTokens+imageTokens, sumOfTokensAcrossAllMessages(gptMessages))
+}
+
+func TestSendMessagesWithFormat(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"model": "gpt-4o", "choices": [{"message": {"role": "assistant", "content": "{\"ok\": true}"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	gpt4o := &GPTModel{Name: "gpt-4o", MaxTokens: 128000, JSONMode: true, JSONSchema: true}
+	g := &GptClient{OpenAiKey: "key", Model: gpt4o, MaxTokens: 100, BaseURL: server.URL}
+	format := &client.ResponseFormat{Type: client.ResponseFormatJSONSchema, Name: "answer", Schema: client.Schema{"type": "object"}, Strict: true}
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "Is it ok?", "ctx")}
+	answers, err := g.SendMessagesWithFormat(messages, nil, format)
+	require.NoError(t, err)
+	assert.Equal(t, `{"ok": true}`, answers[len(answers)-1].Content)
+
+	assert.Equal(t, map[string]interface{}{
+		"type":        "json_schema",
+		"json_schema": map[string]interface{}{"name": "answer", "schema": map[string]interface{}{"type": "object"}, "strict": true},
+	}, received["response_format"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"role": "user", "content": "Is it ok?"}}, received["messages"])
+
+	// Models without structured outputs get JSON mode, models without JSON
+	// mode the instructions in the context only.
+	g.Model = ModelGPT4Turbo
+	_, err = g.SendMessagesWithFormat(messages, nil, format)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"type": "json_object"}, received["response_format"])
+
+	g.Model = ModelGPT4
+	_, err = g.SendMessagesWithFormat(messages, nil, format)
+	require.NoError(t, err)
+	assert.NotContains(t, received, "response_format")
+}
+
+func TestSendMessagesWithOptions(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"model": "gpt-4", "choices": [{"message": {"role": "assistant", "content": "Hi"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	temperature := 0.5
+	seed := 42
+	penalty := 0.1
+	options := &client.RequestOptions{
+		Temperature:     &temperature,
+		MaxTokens:       50,
+		Stop:            []string{"END"},
+		Seed:            &seed,
+		PresencePenalty: &penalty,
+		LogitBias:       map[string]int{"50256": -100},
+		User:            "user-1",
+	}
+	g := &GptClient{OpenAiKey: "key", Model: ModelGPT4, MaxTokens: 1000, BaseURL: server.URL}
+	_, err := g.SendMessagesWithOptions([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hello", "ctx")}, nil, options)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, received["temperature"])
+	assert.Equal(t, 50.0, received["max_tokens"])
+	assert.Equal(t, []interface{}{"END"}, received["stop"])
+	assert.Equal(t, 42.0, received["seed"])
+	assert.Equal(t, 0.1, received["presence_penalty"])
+	assert.NotContains(t, received, "frequency_penalty")
+	assert.Equal(t, map[string]interface{}{"50256": -100.0}, received["logit_bias"])
+	assert.Equal(t, "user-1", received["user"])
+
+	topK := 40
+	_, err = g.SendMessagesWithOptions([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hello", "ctx")}, nil, &client.RequestOptions{TopK: &topK})
+	assert.EqualError(t, err, "openai does not support the options top_k")
+}
+
+func TestSendMessagesWithTools(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"model": "gpt-4", "choices": [{"message": {"role": "assistant", "content": null,
+			"tool_calls": [{"id": "call_2", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Rome\"}"}}]},
+			"finish_reason": "tool_calls"}]}`))
+	}))
+	defer server.Close()
+
+	call := db.CreateNewMessage(db.AssistentRoleNeam, "", "ctx")
+	call.ToolCalls = []db.ToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`}}
+	result := db.CreateNewMessage(db.ToolRoleName, "sunny", "ctx")
+	result.ToolCallId = "call_1"
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "Weather in Paris and Rome?", "ctx"), call, result}
+	tools := []client.Tool{{Name: "weather", Description: "Current weather", Parameters: client.Schema{"type": "object"}}}
+
+	g := &GptClient{OpenAiKey: "key", Model: ModelGPT4, MaxTokens: 1000, BaseURL: server.URL}
+	answers, err := g.SendMessagesWithTools(messages, nil, tools)
+	require.NoError(t, err)
+	assert.Equal(t, []db.ToolCall{{ID: "call_2", Name: "weather", Arguments: `{"city":"Rome"}`}}, answers[len(answers)-1].ToolCalls)
+	assert.Equal(t, "tool_calls", answers[len(answers)-1].Metadata.FinishReason)
+
+	body, err := json.Marshal(received["messages"])
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"role": "user", "content": "Weather in Paris and Rome?"},
+		{"role": "assistant", "content": "", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":\"Paris\"}"}}]},
+		{"role": "tool", "content": "sunny", "tool_call_id": "call_1"}
+	]`, string(body))
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"type":     "function",
+		"function": map[string]interface{}{"name": "weather", "description": "Current weather", "parameters": map[string]interface{}{"type": "object"}},
+	}}, received["tools"])
+}
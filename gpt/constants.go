@@ -2,9 +2,16 @@ package gpt
 
 const API_URL = "https://api.openai.com/v1/chat/completions"
 
+// ProviderName identifies OpenAI answers in db.MessageMetadata.
+const ProviderName = "openai"
+
 type GPTModel struct {
 	Name      string `json:"name"`
 	MaxTokens int    `json:"max_tokens"`
+	// JSONMode is set for models that accept response_format json_object,
+	// JSONSchema for those that also accept json_schema.
+	JSONMode   bool `json:"json_mode"`
+	JSONSchema bool `json:"json_schema"`
 }
 
 var ModelGPT4 = &GPTModel{
@@ -21,6 +28,14 @@ var ModelGPT4Big = &GPTModel{
 var ModelGPT4Turbo = &GPTModel{
 	Name:      "gpt-4-1106-preview",
 	MaxTokens: 128000,
+	JSONMode:  true,
+}
+
+var ModelGPT4o = &GPTModel{
+	Name:       "gpt-4o",
+	MaxTokens:  128000,
+	JSONMode:   true,
+	JSONSchema: true,
 }
 
 var ModelGPT4Vision = &GPTModel{
@@ -2,6 +2,8 @@ package gpt
 
 import "strings"
 
+// Deprecated: use registry.Models(gpt.ProviderName), the keys are aliases of
+// the registry models.
 func GetLlmClientGptModels() map[string]*GPTModel {
 	Models := make(map[string]*GPTModel)
 	Models["gpt3Turbo"] = ModelGPT3Turbo
@@ -9,7 +11,8 @@ func GetLlmClientGptModels() map[string]*GPTModel {
 	Models["gpt4"] = ModelGPT4
 	Models["gpt4Big"] = ModelGPT4Big
 	Models["gpt4Turbo"] = ModelGPT4Turbo
-	Models["gpt4Vision"] = ModelGPT4Vision 
+	Models["gpt4Vision"] = ModelGPT4Vision
+	Models["gpt4o"] = ModelGPT4o
 	return Models
 }
 
@@ -22,6 +25,8 @@ func GetListOfModels() []string {
 	return keys
 }
 
+// Deprecated: use registry.Lookup, which accepts these keys as well as the
+// OpenAI model names.
 func IsModelGPTValid(modelName string) bool {
 	if notInList(modelName, GetListOfModels()) {
 		return false
@@ -0,0 +1,95 @@
+// Package sse reads server-sent events as sent by the streaming endpoints of
+// the model APIs, and writes them for the server.
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Event is one server-sent event. Data lines of an event are joined with
+// "\n".
+type Event struct {
+	Event string
+	Data  string
+}
+
+type Reader struct {
+	scanner *bufio.Scanner
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	// Events carry whole JSON documents, the default 64KB line limit is too
+	// small for long answers.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next event, or io.EOF when the stream ends.
+func (r *Reader) Next() (Event, error) {
+	var event Event
+	data := make([]string, 0)
+	hasFields := false
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			if hasFields {
+				event.Data = strings.Join(data, "\n")
+				return event, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+			hasFields = true
+		case "data":
+			data = append(data, value)
+			hasFields = true
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	if hasFields {
+		event.Data = strings.Join(data, "\n")
+		return event, nil
+	}
+	return Event{}, io.EOF
+}
+
+// Writer writes events and flushes them, e.g. to an http.ResponseWriter, so
+// that the client gets every event at once.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(event Event) error {
+	var b strings.Builder
+	if event.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event.Event)
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	if _, err := io.WriteString(w.w, b.String()); err != nil {
+		return err
+	}
+	if flusher, ok := w.w.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	return nil
+}
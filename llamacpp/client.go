@@ -0,0 +1,284 @@
+package llamacpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/internal/sse"
+	"github.com/sirupsen/logrus"
+)
+
+// LlamaCppClient talks to the /completion endpoint of a llama.cpp server. The
+// conversation is rendered into a single prompt with Template.
+type LlamaCppClient struct {
+	// BaseURL of the server, DefaultBaseURL when empty.
+	BaseURL string
+	// Template is the chat format of the loaded model, TemplateChatML when
+	// nil.
+	Template   *ChatTemplate
+	Parameters Parameters
+	Logger     *logrus.Logger
+}
+
+func NewLlamaCppClient(baseURL string, template *ChatTemplate, logger *logrus.Logger) *client.Client {
+	return &client.Client{
+		Client: &LlamaCppClient{
+			BaseURL:  baseURL,
+			Template: template,
+			Logger:   logger,
+		},
+		ContextDepth:   8,
+		DefaultContext: "",
+		Logger:         logger,
+	}
+}
+
+// supportedOptions are the request options that map onto Parameters.
+var supportedOptions = []string{
+	client.OptionTemperature,
+	client.OptionTopP,
+	client.OptionTopK,
+	client.OptionMaxTokens,
+	client.OptionStop,
+	client.OptionSeed,
+	client.OptionPresencePenalty,
+	client.OptionFrequencyPenalty,
+}
+
+func (c *LlamaCppClient) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return c.SendMessagesWithOptions(messages, context, nil)
+}
+
+// SendMessagesWithOptions overrides Parameters for one request, stop
+// sequences are added to those of the template.
+func (c *LlamaCppClient) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, false, options)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return appendAnswer(messages, response.Content, &response), nil
+}
+
+// StreamMessages reads the answer as server-sent events and calls onChunk with
+// the content of each of them.
+func (c *LlamaCppClient) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	return c.StreamMessagesWithOptions(messages, context, nil, onChunk)
+}
+
+func (c *LlamaCppClient) StreamMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions, onChunk func(chunk string) error) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, true, options)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	events := sse.NewReader(resp.Body)
+	for {
+		event, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			// The connection was cut, the answer would be truncated.
+			return nil, &client.APIError{Provider: "llama.cpp", StatusCode: resp.StatusCode, Message: "stream ended before the answer was done", Retryable: true}
+		}
+		if err != nil {
+			return nil, err
+		}
+		var apiError errorResponse
+		if json.Unmarshal([]byte(event.Data), &apiError) == nil && apiError.Error.Message != "" {
+			return nil, fmt.Errorf("error response from llama.cpp: %s", apiError.Error.Message)
+		}
+		var chunk CompletionResponse
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			return nil, fmt.Errorf("unable to parse llama.cpp stream event: %v", err)
+		}
+		if chunk.Content != "" {
+			text.WriteString(chunk.Content)
+			if err := onChunk(chunk.Content); err != nil {
+				return nil, err
+			}
+		}
+		if chunk.Stop {
+			return appendAnswer(messages, text.String(), &chunk), nil
+		}
+	}
+}
+
+func appendAnswer(messages []db.Message, content string, last *CompletionResponse) []db.Message {
+	finishReason := "stop"
+	if last.StoppedLimit {
+		finishReason = "length"
+	}
+	newMessage := db.CreateNewMessage(db.AssistentRoleNeam, content, messages[0].ContextId)
+	newMessage.Metadata = db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            last.Model,
+		FinishReason:     finishReason,
+		PromptTokens:     last.TokensEvaluated,
+		CompletionTokens: last.TokensPredicted,
+		TotalTokens:      last.TokensEvaluated + last.TokensPredicted,
+	}
+	return append(messages, newMessage)
+}
+
+func (c *LlamaCppClient) buildRequest(messages []db.Message, context []string, stream bool, options *client.RequestOptions) (*CompletionRequest, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("no messages to send")
+	}
+	if err := options.Check(ProviderName, c.Logger, supportedOptions...); err != nil {
+		return nil, err
+	}
+	template := c.Template
+	if template == nil {
+		template = TemplateChatML
+	}
+	request := &CompletionRequest{
+		Prompt:      template.Render(messages, context),
+		Stop:        template.Stop,
+		Stream:      stream,
+		CachePrompt: true,
+		Parameters:  c.Parameters,
+	}
+	if options == nil {
+		return request, nil
+	}
+	if options.Temperature != nil {
+		request.Temperature = options.Temperature
+	}
+	if options.TopP != nil {
+		request.TopP = options.TopP
+	}
+	if options.TopK != nil {
+		request.TopK = options.TopK
+	}
+	if options.MaxTokens > 0 {
+		request.NPredict = options.MaxTokens
+	}
+	if len(options.Stop) > 0 {
+		request.Stop = append(append([]string{}, template.Stop...), options.Stop...)
+	}
+	if options.Seed != nil {
+		request.Seed = options.Seed
+	}
+	if options.PresencePenalty != nil {
+		request.PresencePenalty = options.PresencePenalty
+	}
+	if options.FrequencyPenalty != nil {
+		request.FrequencyPenalty = options.FrequencyPenalty
+	}
+	return request, nil
+}
+
+// Render builds the prompt: the context as system turns, the history and the
+// start of the answer.
+func (t *ChatTemplate) Render(messages []db.Message, context []string) string {
+	var prompt strings.Builder
+	prompt.WriteString(t.Prefix)
+	for _, contextMsg := range context {
+		fmt.Fprintf(&prompt, t.System, contextMsg)
+	}
+	for _, msg := range messages {
+		switch msg.Role {
+		case db.SystemRoleName:
+			fmt.Fprintf(&prompt, t.System, msg.Content)
+		case db.AssistentRoleNeam:
+			fmt.Fprintf(&prompt, t.Assistant, msg.Content)
+		default:
+			fmt.Fprintf(&prompt, t.User, msg.Content)
+		}
+	}
+	prompt.WriteString(t.Generation)
+	return prompt.String()
+}
+
+// ListModels returns the models served by the server, usually the one it
+// was started with.
+func (c *LlamaCppClient) ListModels() ([]ModelInfo, error) {
+	resp, err := http.Get(c.baseURL() + "/v1/models")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var models modelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, err
+	}
+	return models.Data, nil
+}
+
+// GetListOfModels returns the ids of the served models.
+func (c *LlamaCppClient) GetListOfModels() ([]string, error) {
+	models, err := c.ListModels()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, model.ID)
+	}
+	return ids, nil
+}
+
+func (c *LlamaCppClient) baseURL() string {
+	if c.BaseURL == "" {
+		return DefaultBaseURL
+	}
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+func (c *LlamaCppClient) post(request *CompletionRequest) (*http.Response, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"requestBody": string(requestBody),
+		}).Debug("llama.cpp request")
+	}
+	resp, err := http.Post(c.baseURL()+"/completion", "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var apiError errorResponse
+	if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
+		return client.NewAPIError("llama.cpp", resp.StatusCode, apiError.Error.Message)
+	}
+	return client.NewAPIError("llama.cpp", resp.StatusCode, string(body))
+}
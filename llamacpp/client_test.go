@@ -0,0 +1,151 @@
+package llamacpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSendMessagesRendersTemplate(t *testing.T) {
+	var received CompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/completion", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"content": "Paris", "model": "llama-3-8b.gguf", "stop": true, "stopped_word": true, "tokens_evaluated": 40, "tokens_predicted": 2}`))
+	}))
+	defer server.Close()
+
+	temperature := 0.2
+	c := &LlamaCppClient{
+		BaseURL:    server.URL,
+		Template:   TemplateLlama3,
+		Parameters: Parameters{NPredict: 64, Temperature: &temperature},
+	}
+	history := []db.Message{
+		db.CreateNewMessage(db.UserRoleName, "Hi", "ctx"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "Hello!", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "Capital of France?", "ctx"),
+	}
+	result, err := c.SendMessages(history, []string{"Answer with one word."})
+	require.NoError(t, err)
+	require.Len(t, result, 4)
+	assert.Equal(t, "Paris", result[3].Content)
+	assert.Equal(t, db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            "llama-3-8b.gguf",
+		FinishReason:     "stop",
+		PromptTokens:     40,
+		CompletionTokens: 2,
+		TotalTokens:      42,
+	}, result[3].Metadata)
+
+	assert.Equal(t, "<|begin_of_text|>"+
+		"<|start_header_id|>system<|end_header_id|>\n\nAnswer with one word.<|eot_id|>"+
+		"<|start_header_id|>user<|end_header_id|>\n\nHi<|eot_id|>"+
+		"<|start_header_id|>assistant<|end_header_id|>\n\nHello!<|eot_id|>"+
+		"<|start_header_id|>user<|end_header_id|>\n\nCapital of France?<|eot_id|>"+
+		"<|start_header_id|>assistant<|end_header_id|>\n\n", received.Prompt)
+	assert.Equal(t, []string{"<|eot_id|>"}, received.Stop)
+	assert.Equal(t, 64, received.NPredict)
+	assert.Equal(t, &temperature, received.Temperature)
+	assert.True(t, received.CachePrompt)
+	assert.False(t, received.Stream)
+}
+
+func TestCustomTemplate(t *testing.T) {
+	template := &ChatTemplate{
+		System:     "### System:\n%s\n\n",
+		User:       "### User:\n%s\n\n",
+		Assistant:  "### Assistant:\n%s\n\n",
+		Generation: "### Assistant:\n",
+	}
+	prompt := template.Render([]db.Message{db.CreateNewMessage(db.UserRoleName, "100% sure?", "ctx")}, []string{"Be kind."})
+	assert.Equal(t, "### System:\nBe kind.\n\n### User:\n100% sure?\n\n### Assistant:\n", prompt)
+}
+
+func TestStreamMessages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request CompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.True(t, request.Stream)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"content\": \"Hel\", \"stop\": false}\n\n")
+		fmt.Fprint(w, "data: {\"content\": \"lo!\", \"stop\": false}\n\n")
+		fmt.Fprint(w, "data: {\"content\": \"\", \"stop\": true, \"model\": \"phi-3.gguf\", \"stopped_limit\": true, \"tokens_evaluated\": 9, \"tokens_predicted\": 2}\n\n")
+	}))
+	defer server.Close()
+
+	c := NewLlamaCppClient(server.URL, nil, nil)
+	c.Store = newTestStore(t)
+	chunks := make([]string, 0)
+	answer, err := c.StreamMessage("Hi", "ctx", func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello!", answer)
+	assert.Equal(t, []string{"Hel", "lo!"}, chunks)
+
+	stored, err := c.Store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "length", stored[1].Metadata.FinishReason)
+	assert.Equal(t, 11, stored[1].Metadata.TotalTokens)
+}
+
+func TestTruncatedStreamIsAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"content\": \"Hel\", \"stop\": false}\n\n")
+	}))
+	defer server.Close()
+
+	c := NewLlamaCppClient(server.URL, nil, nil)
+	c.Store = newTestStore(t)
+	_, err := c.StreamMessage("Hi", "ctx", func(string) error { return nil })
+	assert.EqualError(t, err, "error response from llama.cpp (200): stream ended before the answer was done")
+	assert.True(t, client.IsRetryable(err))
+
+	stored, err := c.Store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	assert.Empty(t, stored, "a truncated answer is not stored")
+}
+
+func TestErrorsAreReported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"code": 400, "message": "the request exceeds the available context size", "type": "invalid_request_error"}}`))
+	}))
+	defer server.Close()
+
+	c := &LlamaCppClient{BaseURL: server.URL}
+	_, err := c.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil)
+	assert.EqualError(t, err, "error response from llama.cpp (400): the request exceeds the available context size")
+}
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/models", r.URL.Path)
+		w.Write([]byte(`{"object": "list", "data": [{"id": "models/llama-3-8b.gguf", "object": "model", "owned_by": "llamacpp", "created": 1717000000}]}`))
+	}))
+	defer server.Close()
+
+	c := &LlamaCppClient{BaseURL: server.URL}
+	ids, err := c.GetListOfModels()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"models/llama-3-8b.gguf"}, ids)
+}
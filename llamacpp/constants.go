@@ -0,0 +1,56 @@
+package llamacpp
+
+const DefaultBaseURL = "http://localhost:8080"
+
+// ProviderName identifies llama.cpp answers in db.MessageMetadata.
+const ProviderName = "llamacpp"
+
+// ChatTemplate turns a conversation into the prompt format the model was
+// trained on. System, User and Assistant are fmt formats with one %s for the
+// message content, Generation starts the answer of the assistant.
+type ChatTemplate struct {
+	Name       string
+	Prefix     string
+	System     string
+	User       string
+	Assistant  string
+	Generation string
+	// Stop ends the answer, usually the end of turn token.
+	Stop []string
+}
+
+var TemplateChatML = &ChatTemplate{
+	Name:       "chatml",
+	System:     "<|im_start|>system\n%s<|im_end|>\n",
+	User:       "<|im_start|>user\n%s<|im_end|>\n",
+	Assistant:  "<|im_start|>assistant\n%s<|im_end|>\n",
+	Generation: "<|im_start|>assistant\n",
+	Stop:       []string{"<|im_end|>"},
+}
+
+var TemplateLlama3 = &ChatTemplate{
+	Name:       "llama3",
+	Prefix:     "<|begin_of_text|>",
+	System:     "<|start_header_id|>system<|end_header_id|>\n\n%s<|eot_id|>",
+	User:       "<|start_header_id|>user<|end_header_id|>\n\n%s<|eot_id|>",
+	Assistant:  "<|start_header_id|>assistant<|end_header_id|>\n\n%s<|eot_id|>",
+	Generation: "<|start_header_id|>assistant<|end_header_id|>\n\n",
+	Stop:       []string{"<|eot_id|>"},
+}
+
+var TemplateZephyr = &ChatTemplate{
+	Name:       "zephyr",
+	System:     "<|system|>\n%s</s>\n",
+	User:       "<|user|>\n%s</s>\n",
+	Assistant:  "<|assistant|>\n%s</s>\n",
+	Generation: "<|assistant|>\n",
+	Stop:       []string{"</s>"},
+}
+
+func GetChatTemplates() map[string]*ChatTemplate {
+	templates := make(map[string]*ChatTemplate)
+	for _, template := range []*ChatTemplate{TemplateChatML, TemplateLlama3, TemplateZephyr} {
+		templates[template.Name] = template
+	}
+	return templates
+}
@@ -0,0 +1,56 @@
+package llamacpp
+
+// Parameters are the sampling settings of the completion endpoint. Zero
+// fields are not sent and the server defaults apply.
+type Parameters struct {
+	NPredict      int      `json:"n_predict,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+}
+
+type CompletionRequest struct {
+	Prompt string   `json:"prompt"`
+	Stop   []string `json:"stop,omitempty"`
+	Stream bool     `json:"stream"`
+	// CachePrompt lets the server reuse the evaluated prompt of the previous
+	// request, which is the conversation so far.
+	CachePrompt bool `json:"cache_prompt"`
+	Parameters
+}
+
+// CompletionResponse is the whole answer, or one event of a streamed answer
+// where the last event has Stop set and carries the statistics.
+type CompletionResponse struct {
+	Content         string `json:"content"`
+	Model           string `json:"model"`
+	Stop            bool   `json:"stop"`
+	StoppedEOS      bool   `json:"stopped_eos"`
+	StoppedWord     bool   `json:"stopped_word"`
+	StoppedLimit    bool   `json:"stopped_limit"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+	TokensPredicted int    `json:"tokens_predicted"`
+}
+
+type ModelInfo struct {
+	ID      string `json:"id"`
+	OwnedBy string `json:"owned_by"`
+	Created int64  `json:"created"`
+}
+
+type modelsResponse struct {
+	Data []ModelInfo `json:"data"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
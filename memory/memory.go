@@ -0,0 +1,343 @@
+// Package memory lets a model recall earlier conversations. Every exchange,
+// a user message together with its answer, is embedded into a collection of
+// a db.VectorStore, and the exchanges closest to a new message are given to
+// the model as system context, also those of other contexts and those that
+// are older than the context depth of the client.
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/embedding"
+	"github.com/sirupsen/logrus"
+)
+
+// Metadata keys of the stored exchanges.
+const (
+	MetadataContextId = "context_id"
+	MetadataAnswerId  = "answer_id"
+	MetadataTimestamp = "timestamp"
+)
+
+const (
+	DefaultCollection = "memory"
+	DefaultTopK       = 5
+	DefaultMaxTokens  = 1000
+)
+
+// Memory stores exchanges and recalls the ones relevant to a message.
+type Memory struct {
+	Embedder embedding.Embedder
+	Store    db.VectorStore
+	// Collection of the exchanges, DefaultCollection when empty. Use one
+	// collection per user to keep their memories apart.
+	Collection string
+	// TopK is the most exchanges recalled per message, DefaultTopK when zero.
+	TopK int
+	// MinScore drops exchanges less similar to the message than this.
+	MinScore float64
+	// MaxTokens limits the estimated size of the recalled exchanges,
+	// DefaultMaxTokens when zero.
+	MaxTokens int
+	// Eligible decides which contexts are recalled from, every context when
+	// nil, see OnlyContexts and ExceptContexts.
+	Eligible func(contextId string) bool
+	Logger   *logrus.Logger
+}
+
+func NewMemory(embedder embedding.Embedder, store db.VectorStore, logger *logrus.Logger) *Memory {
+	return &Memory{
+		Embedder: embedder,
+		Store:    store,
+		Logger:   logger,
+	}
+}
+
+// OnlyContexts makes only the given contexts eligible.
+func OnlyContexts(contextIds ...string) func(contextId string) bool {
+	set := toSet(contextIds)
+	return func(contextId string) bool {
+		return set[contextId]
+	}
+}
+
+// ExceptContexts makes every context but the given ones eligible.
+func ExceptContexts(contextIds ...string) func(contextId string) bool {
+	set := toSet(contextIds)
+	return func(contextId string) bool {
+		return !set[contextId]
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// Recollection is an exchange recalled for a message.
+type Recollection struct {
+	// ID is the id of the user message of the exchange.
+	ID        string
+	ContextId string
+	Timestamp time.Time
+	Content   string
+	Score     float64
+}
+
+// Remember stores an exchange, it replaces an exchange with the same
+// question.
+func (m *Memory) Remember(question db.Message, answer db.Message) error {
+	content := exchangeContent(question, answer)
+	embeddings, err := m.Embedder.Embed([]string{content})
+	if err != nil {
+		return err
+	}
+	if len(embeddings) != 1 {
+		return errors.New("embedder returned no embedding for the exchange")
+	}
+	return m.Store.UpsertVectors(m.collection(), db.Vector{
+		ID:        question.ID,
+		Embedding: embeddings[0],
+		Content:   content,
+		Metadata: map[string]string{
+			MetadataContextId: question.ContextId,
+			MetadataAnswerId:  answer.ID,
+			MetadataTimestamp: question.Timestamp.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// Import remembers the exchanges of every context of store that are not
+// remembered yet and returns how many were added, e.g. to recall
+// conversations from before the memory was used.
+func (m *Memory) Import(store db.Store) (int, error) {
+	contextIds, err := store.GetContextIDs()
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, contextId := range contextIds {
+		messages, err := store.GetMessagesByContextID(contextId)
+		if err != nil {
+			return imported, err
+		}
+		for i := 0; i+1 < len(messages); i++ {
+			question, answer := messages[i], messages[i+1]
+			if question.Role != db.UserRoleName || question.Status == db.MessageStatusFailed || answer.Role != db.AssistentRoleNeam {
+				continue
+			}
+			if _, err := m.Store.GetVector(m.collection(), question.ID); err == nil {
+				continue
+			} else if !errors.Is(err, db.ErrVectorNotFound) {
+				return imported, err
+			}
+			if err := m.Remember(question, answer); err != nil {
+				return imported, fmt.Errorf("unable to remember message %s: %v", question.ID, err)
+			}
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+// Recall returns the exchanges of eligible contexts closest to query, best
+// first, within the token limit. Exchanges with a message in exclude, e.g.
+// the history that is sent anyway, are skipped.
+func (m *Memory) Recall(query string, exclude ...string) ([]Recollection, error) {
+	if strings.TrimSpace(query) == "" {
+		return []Recollection{}, nil
+	}
+	queryEmbedding, err := embedding.EmbedQuery(m.Embedder, query)
+	if err != nil {
+		return nil, err
+	}
+	// The store cannot filter by eligibility, every match is ranked and the
+	// best eligible ones are kept.
+	matches, err := m.Store.SearchVectors(m.collection(), queryEmbedding, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	excluded := toSet(exclude)
+	topK := m.TopK
+	if topK <= 0 {
+		topK = DefaultTopK
+	}
+	maxTokens := m.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultMaxTokens
+	}
+	recollections := make([]Recollection, 0)
+	tokens := 0
+	for _, match := range matches {
+		if len(recollections) == topK || match.Score < m.MinScore {
+			break
+		}
+		contextId := match.Metadata[MetadataContextId]
+		if excluded[match.ID] || excluded[match.Metadata[MetadataAnswerId]] {
+			continue
+		}
+		if m.Eligible != nil && !m.Eligible(contextId) {
+			continue
+		}
+		size := estimateTokens(match.Content)
+		if tokens+size > maxTokens {
+			continue
+		}
+		tokens += size
+		timestamp, _ := time.Parse(time.RFC3339, match.Metadata[MetadataTimestamp])
+		recollections = append(recollections, Recollection{
+			ID:        match.ID,
+			ContextId: contextId,
+			Timestamp: timestamp,
+			Content:   match.Content,
+			Score:     match.Score,
+		})
+	}
+	return recollections, nil
+}
+
+// Forget removes the exchanges of a context.
+func (m *Memory) Forget(contextId string) error {
+	return m.Store.DeleteVectorsByMetadata(m.collection(), map[string]string{MetadataContextId: contextId})
+}
+
+func (m *Memory) collection() string {
+	if m.Collection == "" {
+		return DefaultCollection
+	}
+	return m.Collection
+}
+
+// estimateTokens counts about four characters per token.
+func estimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+func exchangeContent(question db.Message, answer db.Message) string {
+	return fmt.Sprintf("User: %s\nAssistant: %s", question.Content, answer.Content)
+}
+
+// FormatRecollections writes the exchanges with the context and the date
+// they come from for the system context.
+func FormatRecollections(recollections []Recollection) string {
+	var b strings.Builder
+	b.WriteString("Excerpts of earlier conversations with the user that may be relevant, with the conversation and the date they come from:")
+	for _, recollection := range recollections {
+		fmt.Fprintf(&b, "\n\n(conversation %s, %s)\n%s", recollection.ContextId,
+			recollection.Timestamp.Format("2006-01-02"), recollection.Content)
+	}
+	return b.String()
+}
+
+// Client is an LllmChatClient that adds the exchanges recalled for the last
+// user message to the context of the request. As a client.TurnObserver it
+// remembers a turn once client.Client has stored it, so failed turns,
+// retries of structured output and the random context are never
+// remembered.
+type Client struct {
+	Client client.LllmChatClient
+	Memory *Memory
+	Logger *logrus.Logger
+}
+
+func NewClient(llmClient client.LllmChatClient, memory *Memory, logger *logrus.Logger) *Client {
+	return &Client{
+		Client: llmClient,
+		Memory: memory,
+		Logger: logger,
+	}
+}
+
+func (c *Client) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return c.send(messages, context, c.Client.SendMessages)
+}
+
+func (c *Client) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	stream := func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.StreamMessages(c.Client, messages, context, onChunk)
+	}
+	return c.send(messages, context, stream)
+}
+
+// SendMessagesWithOptions passes the options to the model with the recalled
+// exchanges.
+func (c *Client) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithOptions(c.Client, messages, context, options, c.Logger)
+	})
+}
+
+func (c *Client) StreamMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions, onChunk func(chunk string) error) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.StreamMessagesWithOptions(c.Client, messages, context, options, c.Logger, onChunk)
+	})
+}
+
+// SendMessagesWithFormat passes the response format to the model with the
+// recalled exchanges.
+func (c *Client) SendMessagesWithFormat(messages []db.Message, context []string, format *client.ResponseFormat) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithFormat(c.Client, messages, context, format)
+	})
+}
+
+// SendMessagesWithTools passes the tools to the model. Exchanges are recalled
+// for user messages only, not for the tool results of the same turn.
+func (c *Client) SendMessagesWithTools(messages []db.Message, context []string, tools []client.Tool) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithTools(c.Client, messages, context, tools)
+	})
+}
+
+// Unwrap returns the provider behind the memory.
+func (c *Client) Unwrap() client.LllmChatClient {
+	return c.Client
+}
+
+func (c *Client) send(messages []db.Message, context []string, send func([]db.Message, []string) ([]db.Message, error)) ([]db.Message, error) {
+	if len(messages) == 0 || messages[len(messages)-1].Role != db.UserRoleName {
+		return send(messages, context)
+	}
+	question := messages[len(messages)-1]
+	exclude := make([]string, len(messages))
+	for i, message := range messages {
+		exclude[i] = message.ID
+	}
+	recollections, err := c.Memory.Recall(question.Content, exclude...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to recall earlier conversations: %v", err)
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"contextId":     question.ContextId,
+			"recollections": len(recollections),
+		}).Debug("Recalled earlier conversations")
+	}
+	if len(recollections) > 0 {
+		context = append(context[:len(context):len(context)], FormatRecollections(recollections))
+	}
+	return send(messages, context)
+}
+
+// TurnStored remembers a stored exchange. The answer is already there, a
+// failure to remember it only costs the exchange in later recalls.
+func (c *Client) TurnStored(request db.Message, answer db.Message) {
+	if request.Role != db.UserRoleName || request.Status == db.MessageStatusFailed ||
+		request.ContextId == "" || request.ContextId == db.RandomContextId {
+		return
+	}
+	if err := c.Memory.Remember(request, answer); err != nil && c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"contextId": request.ContextId,
+			"error":     err,
+		}).Warn("Unable to remember exchange")
+	}
+}
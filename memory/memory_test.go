@@ -0,0 +1,194 @@
+package memory
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/cache"
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keywordEmbedder embeds a text by the keywords it contains.
+type keywordEmbedder struct {
+	keywords []string
+}
+
+func (k *keywordEmbedder) Embed(inputs []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		embeddings[i] = make([]float32, len(k.keywords))
+		for j, keyword := range k.keywords {
+			embeddings[i][j] = float32(strings.Count(strings.ToLower(input), keyword))
+		}
+	}
+	return embeddings, nil
+}
+
+// contextRecorder answers "noted" and records the context of the last
+// request.
+type contextRecorder struct {
+	context []string
+}
+
+func (r *contextRecorder) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	r.context = context
+	return append(messages, db.CreateNewMessage(db.AssistentRoleNeam, "noted", messages[0].ContextId)), nil
+}
+
+// scriptedLlm answers with the next of its answers, an error when there are
+// none left.
+type scriptedLlm struct {
+	answers []string
+}
+
+func (s *scriptedLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	if len(s.answers) == 0 {
+		return nil, errors.New("no more answers")
+	}
+	answer := s.answers[0]
+	s.answers = s.answers[1:]
+	return append(messages, db.CreateNewMessage(db.AssistentRoleNeam, answer, messages[0].ContextId)), nil
+}
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestClientRecallsOtherContexts(t *testing.T) {
+	store := newTestStore(t)
+	memory := NewMemory(&keywordEmbedder{keywords: []string{"cat", "dog", "paris"}}, store, nil)
+	memory.MinScore = 0.1
+	llm := &contextRecorder{}
+	c := &client.Client{Client: NewClient(llm, memory, nil), ContextDepth: 10, Store: store}
+
+	_, err := c.SendMessage("My cat is called Tom", "pets")
+	require.NoError(t, err)
+	assert.Empty(t, llm.context)
+	_, err = c.SendMessage("I am flying to Paris", "travel")
+	require.NoError(t, err)
+
+	_, err = c.SendMessage("What food does my cat like?", "food")
+	require.NoError(t, err)
+	require.Len(t, llm.context, 1)
+	assert.Contains(t, llm.context[0], "(conversation pets, ")
+	assert.Contains(t, llm.context[0], "User: My cat is called Tom\nAssistant: noted")
+	assert.NotContains(t, llm.context[0], "Paris")
+
+	// Exchanges that are in the history are not recalled again.
+	_, err = c.SendMessage("Is a cat allowed in Paris?", "food")
+	require.NoError(t, err)
+	require.Len(t, llm.context, 1)
+	assert.NotContains(t, llm.context[0], "What food")
+	assert.Contains(t, llm.context[0], "(conversation travel, ")
+
+	memory.Eligible = ExceptContexts("pets", "travel")
+	_, err = c.SendMessage("Is a cat allowed in Paris?", "other")
+	require.NoError(t, err)
+	require.Len(t, llm.context, 1)
+	assert.Contains(t, llm.context[0], "(conversation food, ")
+	assert.NotContains(t, llm.context[0], "conversation pets")
+}
+
+func TestOnlyStoredTurnsAreRemembered(t *testing.T) {
+	store := newTestStore(t)
+	memory := NewMemory(&keywordEmbedder{keywords: []string{"cat"}}, store, nil)
+	llm := &scriptedLlm{answers: []string{"not json", `{"name": "Tom"}`, "noted"}}
+	// The cache is in front of the memory, which is still told about the
+	// stored turns.
+	cached, err := cache.New(NewClient(llm, memory, nil), cache.NewMemoryBackend(10), "test")
+	require.NoError(t, err)
+	c := &client.Client{Client: cached, ContextDepth: 10, Store: store, KeepFailedMessages: true}
+
+	type pet struct {
+		Name string `json:"name"`
+	}
+	answer, err := client.SendStructured[pet](c, "What is my cat called?", "pets", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Tom", answer.Name)
+	_, err = c.SendNoContextMessage("Which cat food is best?")
+	require.NoError(t, err)
+	_, err = c.SendMessage("Which cat toys are best?", "pets")
+	require.Error(t, err)
+
+	recollections, err := memory.Recall("cat")
+	require.NoError(t, err)
+	require.Len(t, recollections, 1, "retries, the random context and failed turns are not remembered")
+	assert.Equal(t, "pets", recollections[0].ContextId)
+	assert.Equal(t, "User: What is my cat called?\nAssistant: {\"name\": \"Tom\"}", recollections[0].Content)
+}
+
+func TestRecallLimits(t *testing.T) {
+	store := newTestStore(t)
+	memory := NewMemory(&keywordEmbedder{keywords: []string{"cat", "dog"}}, store, nil)
+	remember := func(contextId string, question string, answer string) {
+		q := db.CreateNewMessage(db.UserRoleName, question, contextId)
+		require.NoError(t, memory.Remember(q, db.CreateNewMessage(db.AssistentRoleNeam, answer, contextId)))
+	}
+	remember("a", "cat", "short")
+	remember("b", "cat and dog", strings.Repeat("long ", 20))
+	remember("c", "dog", "short")
+
+	recollections, err := memory.Recall("cat")
+	require.NoError(t, err)
+	require.Len(t, recollections, 3)
+	assert.Equal(t, "a", recollections[0].ContextId)
+	assert.InDelta(t, 1.0, recollections[0].Score, 1e-6)
+
+	memory.MinScore = 0.5
+	recollections, err = memory.Recall("cat")
+	require.NoError(t, err)
+	require.Len(t, recollections, 2)
+
+	memory.MaxTokens = 10
+	recollections, err = memory.Recall("cat")
+	require.NoError(t, err)
+	require.Len(t, recollections, 1)
+	assert.Equal(t, "a", recollections[0].ContextId)
+
+	memory.MaxTokens = 0
+	memory.Eligible = OnlyContexts("b")
+	recollections, err = memory.Recall("cat")
+	require.NoError(t, err)
+	require.Len(t, recollections, 1)
+	assert.Equal(t, "b", recollections[0].ContextId)
+
+	require.NoError(t, memory.Forget("b"))
+	recollections, err = memory.Recall("cat")
+	require.NoError(t, err)
+	assert.Empty(t, recollections)
+}
+
+func TestImportRemembersStoredExchanges(t *testing.T) {
+	store := newTestStore(t)
+	require.NoError(t, store.CreateContext("old", ""))
+	failed := db.CreateNewMessage(db.UserRoleName, "lost cat", "old")
+	failed.Status = db.MessageStatusFailed
+	require.NoError(t, store.StoreMessages(
+		db.CreateNewMessage(db.UserRoleName, "my dog", "old"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "nice", "old"),
+		failed,
+		db.CreateNewMessage(db.UserRoleName, "my cat", "old"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "cute", "old"),
+	))
+	memory := NewMemory(&keywordEmbedder{keywords: []string{"cat", "dog"}}, store, nil)
+
+	imported, err := memory.Import(store)
+	require.NoError(t, err)
+	assert.Equal(t, 2, imported)
+	imported, err = memory.Import(store)
+	require.NoError(t, err)
+	assert.Equal(t, 0, imported)
+
+	recollections, err := memory.Recall("cat")
+	require.NoError(t, err)
+	require.NotEmpty(t, recollections)
+	assert.Equal(t, "User: my cat\nAssistant: cute", recollections[0].Content)
+}
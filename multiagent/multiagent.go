@@ -0,0 +1,311 @@
+// Package multiagent lets several agents, each with its own provider, model
+// and system prompt, talk in one conversation. A TurnPolicy decides who
+// speaks next and the transcript is stored in a context with the name of the
+// speaker of every message.
+package multiagent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultMaxTurns = 10
+
+// Reasons a conversation ended.
+const (
+	StopMaxTurns  = "max_turns"
+	StopPolicy    = "policy"
+	StopCondition = "condition"
+)
+
+// Agent is a participant of a conversation.
+type Agent struct {
+	Name         string
+	Provider     client.LllmChatClient
+	SystemPrompt string
+	// Sees lists the agents whose messages this agent gets, every agent
+	// when empty. Its own messages and those of the user are always sent.
+	Sees []string
+}
+
+func NewAgent(name string, provider client.LllmChatClient, systemPrompt string) *Agent {
+	return &Agent{
+		Name:         name,
+		Provider:     provider,
+		SystemPrompt: systemPrompt,
+	}
+}
+
+// AgentFromClient creates an agent with the provider and the default context
+// of a client, e.g. one created by a provider package or the registry.
+func AgentFromClient(name string, c *client.Client) *Agent {
+	return NewAgent(name, c.Client, c.DefaultContext)
+}
+
+func (a *Agent) sees(speaker string) bool {
+	if len(a.Sees) == 0 || speaker == "" || speaker == a.Name {
+		return true
+	}
+	for _, name := range a.Sees {
+		if name == speaker {
+			return true
+		}
+	}
+	return false
+}
+
+// Conversation runs agents in a context of a store.
+type Conversation struct {
+	ContextId string
+	Store     db.Store
+	Agents    []*Agent
+	// Policy picks the speakers, RoundRobin when nil.
+	Policy TurnPolicy
+	// MaxTurns is the most agent messages per Run, DefaultMaxTurns when
+	// zero.
+	MaxTurns int
+	// Stop ends the conversation when it returns true after a turn.
+	Stop func(transcript []db.Message) bool
+	// OnTurn is called with every agent message once it is stored.
+	OnTurn func(message db.Message)
+	Logger *logrus.Logger
+}
+
+func NewConversation(contextId string, store db.Store, agents ...*Agent) *Conversation {
+	return &Conversation{
+		ContextId: contextId,
+		Store:     store,
+		Agents:    agents,
+	}
+}
+
+// Result is the transcript of the whole conversation and why it ended.
+type Result struct {
+	Transcript []db.Message
+	Reason     string
+}
+
+// Run adds message of the user to the conversation, e.g. the topic, and lets
+// the agents talk until the policy or Stop ends the conversation or MaxTurns
+// agent messages were sent. An empty message continues a stored
+// conversation. When no agent answers the message it is stored as failed and
+// the run can be repeated with the same message.
+func (c *Conversation) Run(message string) (*Result, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	exists, err := c.Store.CheckIfContextExists(c.ContextId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := c.Store.CreateContext(c.ContextId, ""); err != nil {
+			return nil, err
+		}
+	}
+	stored, err := c.Store.GetMessagesByContextID(c.ContextId)
+	if err != nil {
+		return nil, err
+	}
+	// Messages of failed runs are not part of the conversation.
+	transcript := make([]db.Message, 0, len(stored)+1)
+	for _, m := range stored {
+		if m.Status != db.MessageStatusFailed {
+			transcript = append(transcript, m)
+		}
+	}
+	// The user message is stored together with the first agent message, or
+	// as failed when no agent answers it.
+	var pending []db.Message
+	if message != "" {
+		userMessage := db.CreateNewMessage(db.UserRoleName, message, c.ContextId)
+		pending = append(pending, userMessage)
+		transcript = append(transcript, userMessage)
+	}
+	if len(transcript) == 0 {
+		return nil, errors.New("a new conversation needs a message")
+	}
+
+	policy := c.Policy
+	if policy == nil {
+		policy = &RoundRobin{}
+	}
+	maxTurns := c.MaxTurns
+	if maxTurns <= 0 {
+		maxTurns = DefaultMaxTurns
+	}
+	for turn := 0; turn < maxTurns; turn++ {
+		name, err := policy.Next(State{Agents: c.agentNames(), Transcript: transcript})
+		if err != nil {
+			return c.fail(transcript, pending, err)
+		}
+		if name == "" {
+			if len(pending) > 0 {
+				if err := c.Store.StoreMessages(pending...); err != nil {
+					return c.fail(transcript, pending, err)
+				}
+			}
+			return &Result{Transcript: transcript, Reason: StopPolicy}, nil
+		}
+		agent := c.agent(name)
+		if agent == nil {
+			return c.fail(transcript, pending, fmt.Errorf("policy picked unknown agent %s", name))
+		}
+		answer, err := c.speak(agent, policy, transcript)
+		if err != nil {
+			return c.fail(transcript, pending, fmt.Errorf("%s failed: %v", agent.Name, err))
+		}
+		if err := c.Store.StoreMessages(append(pending, answer)...); err != nil {
+			return c.fail(transcript, pending, err)
+		}
+		pending = nil
+		transcript = append(transcript, answer)
+		if c.OnTurn != nil {
+			c.OnTurn(answer)
+		}
+		if c.Stop != nil && c.Stop(transcript) {
+			return &Result{Transcript: transcript, Reason: StopCondition}, nil
+		}
+	}
+	return &Result{Transcript: transcript, Reason: StopMaxTurns}, nil
+}
+
+func (c *Conversation) validate() error {
+	if len(c.Agents) == 0 {
+		return errors.New("a conversation needs agents")
+	}
+	names := map[string]bool{}
+	for _, agent := range c.Agents {
+		if agent.Name == "" || agent.Provider == nil {
+			return errors.New("agents need a name and a provider")
+		}
+		if names[agent.Name] {
+			return fmt.Errorf("agent %s is defined twice", agent.Name)
+		}
+		names[agent.Name] = true
+	}
+	return nil
+}
+
+// speak sends the transcript as the agent sees it and returns the answer.
+func (c *Conversation) speak(agent *Agent, policy TurnPolicy, transcript []db.Message) (db.Message, error) {
+	messages := c.view(agent, transcript)
+	context := make([]string, 0)
+	if agent.SystemPrompt != "" {
+		context = append(context, agent.SystemPrompt)
+	}
+	context = append(context, c.introduction(agent))
+	if instructor, ok := policy.(Instructor); ok {
+		if instructions := instructor.Instructions(agent.Name); instructions != "" {
+			context = append(context, instructions)
+		}
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"contextId": c.ContextId,
+			"agent":     agent.Name,
+			"messages":  len(messages),
+		}).Debug("Agent turn")
+	}
+	answers, err := agent.Provider.SendMessages(messages, context)
+	if err != nil {
+		return db.Message{}, err
+	}
+	if len(answers) == 0 {
+		return db.Message{}, errors.New("model returned no messages")
+	}
+	// Models tend to copy the "Name: " in front of the messages they see.
+	content := strings.TrimSpace(answers[len(answers)-1].Content)
+	content = strings.TrimSpace(strings.TrimPrefix(content, agent.Name+":"))
+	answer := db.CreateNewMessage(db.AssistentRoleNeam, content, c.ContextId)
+	answer.Metadata = answers[len(answers)-1].Metadata
+	answer.Metadata.Speaker = agent.Name
+	return answer, nil
+}
+
+// fail stores the user message of a run that no agent answered as failed, so
+// that running again with the same message neither sends it twice nor keeps
+// an unanswered copy.
+func (c *Conversation) fail(transcript []db.Message, pending []db.Message, err error) (*Result, error) {
+	for _, message := range pending {
+		message.Status = db.MessageStatusFailed
+		if _, storeErr := c.Store.StoreMessage(message); storeErr != nil && c.Logger != nil {
+			c.Logger.WithFields(logrus.Fields{
+				"contextId": c.ContextId,
+				"error":     storeErr,
+			}).Warn("Unable to store failed message")
+		}
+	}
+	return &Result{Transcript: transcript[:len(transcript)-len(pending)]}, err
+}
+
+// view is the transcript from the point of view of an agent: its own
+// messages are assistant messages, the messages of everyone else are user
+// messages that start with the name of the speaker. Consecutive user
+// messages are joined for providers that need alternating turns.
+func (c *Conversation) view(agent *Agent, transcript []db.Message) []db.Message {
+	messages := make([]db.Message, 0, len(transcript)+1)
+	for _, message := range transcript {
+		speaker := message.Metadata.Speaker
+		if !agent.sees(speaker) {
+			continue
+		}
+		if speaker == agent.Name {
+			own := db.CreateNewMessage(db.AssistentRoleNeam, message.Content, c.ContextId)
+			own.Timestamp = message.Timestamp
+			messages = append(messages, own)
+			continue
+		}
+		if speaker == "" {
+			speaker = "User"
+		}
+		content := fmt.Sprintf("%s: %s", speaker, message.Content)
+		if last := len(messages) - 1; last >= 0 && messages[last].Role == db.UserRoleName {
+			messages[last].Content += "\n\n" + content
+			continue
+		}
+		other := db.CreateNewMessage(db.UserRoleName, content, c.ContextId)
+		other.Timestamp = message.Timestamp
+		messages = append(messages, other)
+	}
+	if len(messages) == 0 || messages[len(messages)-1].Role != db.UserRoleName {
+		messages = append(messages, db.CreateNewMessage(db.UserRoleName, fmt.Sprintf("It is your turn, %s.", agent.Name), c.ContextId))
+	}
+	return messages
+}
+
+func (c *Conversation) introduction(agent *Agent) string {
+	others := make([]string, 0, len(c.Agents))
+	for _, other := range c.Agents {
+		if other.Name != agent.Name && agent.sees(other.Name) {
+			others = append(others, other.Name)
+		}
+	}
+	if len(others) == 0 {
+		return fmt.Sprintf("You are %s. Messages of the user start with \"User:\". Answer with your own message only, without your name in front of it.", agent.Name)
+	}
+	return fmt.Sprintf("You are %s in a conversation with %s and the user. Their messages start with their name. Answer with your own message only, without your name in front of it.",
+		agent.Name, strings.Join(others, ", "))
+}
+
+func (c *Conversation) agent(name string) *Agent {
+	for _, agent := range c.Agents {
+		if agent.Name == name {
+			return agent
+		}
+	}
+	return nil
+}
+
+func (c *Conversation) agentNames() []string {
+	names := make([]string, len(c.Agents))
+	for i, agent := range c.Agents {
+		names[i] = agent.Name
+	}
+	return names
+}
@@ -0,0 +1,167 @@
+package multiagent
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedLlm answers with the next of its answers and records the
+// requests.
+type scriptedLlm struct {
+	answers  []string
+	requests [][]db.Message
+	contexts [][]string
+}
+
+func (s *scriptedLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	s.requests = append(s.requests, messages)
+	s.contexts = append(s.contexts, context)
+	if len(s.answers) == 0 {
+		return nil, errors.New("no more answers")
+	}
+	answer := s.answers[0]
+	s.answers = s.answers[1:]
+	return append(messages, db.CreateNewMessage(db.AssistentRoleNeam, answer, messages[0].ContextId)), nil
+}
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func speakers(transcript []db.Message) []string {
+	names := make([]string, len(transcript))
+	for i, message := range transcript {
+		names[i] = message.Metadata.Speaker
+	}
+	return names
+}
+
+func TestRoundRobinDebateIsStoredWithSpeakers(t *testing.T) {
+	store := newTestStore(t)
+	pro := &scriptedLlm{answers: []string{"Tabs are compact.", "pro: And consistent."}}
+	contra := &scriptedLlm{answers: []string{"Spaces look the same everywhere."}}
+	conversation := NewConversation("debate", store,
+		NewAgent("pro", pro, "Argue for tabs."),
+		AgentFromClient("contra", &client.Client{Client: contra, DefaultContext: "Argue for spaces."}))
+	conversation.MaxTurns = 3
+
+	result, err := conversation.Run("Tabs or spaces?")
+	require.NoError(t, err)
+	assert.Equal(t, StopMaxTurns, result.Reason)
+	assert.Equal(t, []string{"", "pro", "contra", "pro"}, speakers(result.Transcript))
+	assert.Equal(t, "And consistent.", result.Transcript[3].Content)
+
+	stored, err := store.GetMessagesByContextID("debate")
+	require.NoError(t, err)
+	assert.Equal(t, speakers(result.Transcript), speakers(stored))
+
+	// contra sees the others as user messages.
+	assert.Equal(t, []string{"Argue for spaces.", "You are contra in a conversation with pro and the user. Their messages start with their name. Answer with your own message only, without your name in front of it."}, contra.contexts[0])
+	require.Len(t, contra.requests[0], 1)
+	assert.Equal(t, "User: Tabs or spaces?\n\npro: Tabs are compact.", contra.requests[0][0].Content)
+	// pro sees its own message as an assistant message.
+	second := pro.requests[1]
+	require.Len(t, second, 3)
+	assert.Equal(t, db.AssistentRoleNeam, second[1].Role)
+	assert.Equal(t, "Tabs are compact.", second[1].Content)
+	assert.Equal(t, "contra: Spaces look the same everywhere.", second[2].Content)
+
+	// Run continues after the last speaker.
+	contra.answers = []string{"Fine."}
+	conversation.MaxTurns = 1
+	result, err = conversation.Run("")
+	require.NoError(t, err)
+	assert.Equal(t, "contra", result.Transcript[4].Metadata.Speaker)
+}
+
+func TestPartiallySharedConversation(t *testing.T) {
+	author := &scriptedLlm{answers: []string{"Draft 1", "Draft 2"}}
+	reviewer := &scriptedLlm{answers: []string{"Too long. APPROVED"}}
+	secretary := &scriptedLlm{answers: []string{"Notes"}}
+	conversation := NewConversation("review", newTestStore(t),
+		&Agent{Name: "author", Provider: author},
+		&Agent{Name: "reviewer", Provider: reviewer, Sees: []string{"author"}},
+		&Agent{Name: "secretary", Provider: secretary, Sees: []string{"reviewer"}})
+	conversation.Stop = StopWhenContains("APPROVED")
+
+	result, err := conversation.Run("Write a poem.")
+	require.NoError(t, err)
+	assert.Equal(t, StopCondition, result.Reason)
+	assert.Equal(t, []string{"", "author", "reviewer"}, speakers(result.Transcript))
+	assert.Empty(t, secretary.requests)
+}
+
+func TestModeratorPicksSpeakers(t *testing.T) {
+	moderator := &scriptedLlm{answers: []string{"Critic.", "writer", "DONE"}}
+	writer := &scriptedLlm{answers: []string{"A story."}}
+	critic := &scriptedLlm{answers: []string{"Needs a story first."}}
+	conversation := NewConversation("moderated", newTestStore(t), NewAgent("writer", writer, ""), NewAgent("critic", critic, ""))
+	conversation.Policy = NewModerator(moderator, "Let the critic start.")
+
+	result, err := conversation.Run("A story please.")
+	require.NoError(t, err)
+	assert.Equal(t, StopPolicy, result.Reason)
+	assert.Equal(t, []string{"", "critic", "writer"}, speakers(result.Transcript))
+	assert.Equal(t, "User: A story please.\n\ncritic: Needs a story first.\n\nWho speaks next?", moderator.requests[1][0].Content)
+	assert.Equal(t, "Let the critic start.", moderator.contexts[0][1])
+
+	moderator.answers = []string{"nobody"}
+	_, err = conversation.Run("")
+	assert.EqualError(t, err, `moderator picked unknown speaker "nobody"`)
+}
+
+func TestUntilConsensus(t *testing.T) {
+	a := &scriptedLlm{answers: []string{"Let us use Go.", "Agreed. [AGREE]"}}
+	b := &scriptedLlm{answers: []string{"Rust is faster.", "Go then. [AGREE]"}}
+	conversation := NewConversation("consensus", newTestStore(t), NewAgent("a", a, ""), NewAgent("b", b, ""))
+	policy := &UntilConsensus{}
+	conversation.Policy = policy
+
+	result, err := conversation.Run("Which language?")
+	require.NoError(t, err)
+	assert.Equal(t, StopPolicy, result.Reason)
+	assert.Len(t, result.Transcript, 5)
+	assert.Contains(t, a.contexts[0][1], "[AGREE]")
+
+	// Agents that left the conversation do not count.
+	left := db.CreateNewMessage(db.AssistentRoleNeam, "I disagree.", "consensus")
+	left.Metadata.Speaker = "c"
+	transcript := append(result.Transcript[:len(result.Transcript):len(result.Transcript)], left)
+	assert.True(t, policy.agreed(State{Agents: []string{"a", "b"}, Transcript: transcript}))
+
+	// A new user message needs a new agreement.
+	assert.False(t, policy.agreed(State{Agents: []string{"a", "b"}, Transcript: append(result.Transcript, db.CreateNewMessage(db.UserRoleName, "Sure?", "consensus"))}))
+}
+
+func TestFailedRunCanBeRepeated(t *testing.T) {
+	store := newTestStore(t)
+	pro := &scriptedLlm{}
+	conversation := NewConversation("debate", store, NewAgent("pro", pro, ""))
+	conversation.MaxTurns = 1
+
+	result, err := conversation.Run("Tabs or spaces?")
+	assert.EqualError(t, err, "pro failed: no more answers")
+	assert.Empty(t, result.Transcript)
+
+	pro.answers = []string{"Tabs."}
+	result, err = conversation.Run("Tabs or spaces?")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "pro"}, speakers(result.Transcript))
+	require.Len(t, pro.requests[1], 1, "the failed message is not sent again")
+	assert.Equal(t, "User: Tabs or spaces?", pro.requests[1][0].Content)
+
+	stored, err := store.GetMessagesByContextID("debate")
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	assert.Equal(t, db.MessageStatusFailed, stored[0].Status)
+	assert.Equal(t, db.MessageStatusOK, stored[1].Status)
+}
@@ -0,0 +1,181 @@
+package multiagent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// State is what a TurnPolicy decides on.
+type State struct {
+	// Agents are the names of the agents in their order.
+	Agents     []string
+	Transcript []db.Message
+}
+
+// lastSpeaker is the agent of the last agent message, "" before the first
+// one.
+func (s State) lastSpeaker() string {
+	for i := len(s.Transcript) - 1; i >= 0; i-- {
+		if speaker := s.Transcript[i].Metadata.Speaker; speaker != "" {
+			return speaker
+		}
+	}
+	return ""
+}
+
+// TurnPolicy picks the agent that speaks next, an empty name ends the
+// conversation.
+type TurnPolicy interface {
+	Next(state State) (string, error)
+}
+
+// Instructor is implemented by policies that tell the agents how to take
+// part, the instructions are added to the system context.
+type Instructor interface {
+	Instructions(agent string) string
+}
+
+// RoundRobin lets the agents speak in their order. It continues after the
+// last speaker of the transcript, so a resumed conversation goes on where it
+// stopped.
+type RoundRobin struct{}
+
+func (RoundRobin) Next(state State) (string, error) {
+	last := state.lastSpeaker()
+	for i, name := range state.Agents {
+		if name == last {
+			return state.Agents[(i+1)%len(state.Agents)], nil
+		}
+	}
+	return state.Agents[0], nil
+}
+
+// DefaultDoneWord is what a moderator answers to end the conversation.
+const DefaultDoneWord = "DONE"
+
+// Moderator asks a model who speaks next.
+type Moderator struct {
+	Provider client.LllmChatClient
+	// Instructions tell the moderator how to pick, e.g. "Let the reviewer
+	// answer every new draft."
+	Instructions string
+	// DoneWord ends the conversation, DefaultDoneWord when empty.
+	DoneWord string
+}
+
+func NewModerator(provider client.LllmChatClient, instructions string) *Moderator {
+	return &Moderator{
+		Provider:     provider,
+		Instructions: instructions,
+	}
+}
+
+func (m *Moderator) Next(state State) (string, error) {
+	done := m.DoneWord
+	if done == "" {
+		done = DefaultDoneWord
+	}
+	context := []string{fmt.Sprintf("You moderate a conversation between %s and the user. Decide who speaks next. Answer with the name only, or with %s when the conversation has reached its goal.",
+		strings.Join(state.Agents, ", "), done)}
+	if m.Instructions != "" {
+		context = append(context, m.Instructions)
+	}
+	var transcript strings.Builder
+	for _, message := range state.Transcript {
+		speaker := message.Metadata.Speaker
+		if speaker == "" {
+			speaker = "User"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", speaker, message.Content)
+	}
+	transcript.WriteString("Who speaks next?")
+	contextId := ""
+	if len(state.Transcript) > 0 {
+		contextId = state.Transcript[0].ContextId
+	}
+	answers, err := m.Provider.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, transcript.String(), contextId)}, context)
+	if err != nil {
+		return "", fmt.Errorf("moderator failed: %v", err)
+	}
+	if len(answers) == 0 {
+		return "", fmt.Errorf("moderator returned no messages")
+	}
+	choice := strings.Trim(answers[len(answers)-1].Content, " \t\n.!\"'`*")
+	if strings.EqualFold(choice, done) {
+		return "", nil
+	}
+	for _, name := range state.Agents {
+		if strings.EqualFold(choice, name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("moderator picked unknown speaker %q", choice)
+}
+
+// DefaultConsensusMarker is how agents show that they agree.
+const DefaultConsensusMarker = "[AGREE]"
+
+// UntilConsensus lets the agents speak in their order until the latest
+// message of every agent since the last user message ends with Marker.
+type UntilConsensus struct {
+	// Marker is DefaultConsensusMarker when empty.
+	Marker string
+}
+
+func (u *UntilConsensus) Next(state State) (string, error) {
+	if u.agreed(state) {
+		return "", nil
+	}
+	return RoundRobin{}.Next(state)
+}
+
+func (u *UntilConsensus) Instructions(agent string) string {
+	return fmt.Sprintf("When you agree with the others and have nothing to add, end your message with %s. Only do so when you really agree.", u.marker())
+}
+
+// agreed checks the last message of every agent since the last message of
+// the user. Speakers that are no longer in the conversation are ignored.
+func (u *UntilConsensus) agreed(state State) bool {
+	agents := map[string]bool{}
+	for _, name := range state.Agents {
+		agents[name] = true
+	}
+	agreed := map[string]bool{}
+	for i := len(state.Transcript) - 1; i >= 0 && len(agreed) < len(state.Agents); i-- {
+		message := state.Transcript[i]
+		speaker := message.Metadata.Speaker
+		if speaker == "" {
+			return false
+		}
+		if _, ok := agreed[speaker]; !ok && agents[speaker] {
+			agreed[speaker] = strings.HasSuffix(strings.TrimSpace(message.Content), u.marker())
+		}
+	}
+	if len(agreed) < len(state.Agents) {
+		return false
+	}
+	for _, agrees := range agreed {
+		if !agrees {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *UntilConsensus) marker() string {
+	if u.Marker == "" {
+		return DefaultConsensusMarker
+	}
+	return u.Marker
+}
+
+// StopWhenContains ends a conversation when the last message contains text,
+// e.g. "APPROVED" from a reviewer.
+func StopWhenContains(text string) func(transcript []db.Message) bool {
+	return func(transcript []db.Message) bool {
+		return len(transcript) > 0 && strings.Contains(transcript[len(transcript)-1].Content, text)
+	}
+}
@@ -0,0 +1,294 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/sirupsen/logrus"
+)
+
+// OllamaClient talks to the /api/chat endpoint of a local Ollama server.
+type OllamaClient struct {
+	// BaseURL of the server, DefaultBaseURL when empty.
+	BaseURL string
+	// Model is an installed model, e.g. "llama3" or "mistral:7b".
+	Model string
+	// KeepAlive controls how long the model stays loaded after a request,
+	// the server default when empty.
+	KeepAlive string
+	// Options are model parameters such as "temperature" or "num_ctx".
+	Options map[string]interface{}
+	Logger  *logrus.Logger
+}
+
+func NewOllamaClient(model string, logger *logrus.Logger) *client.Client {
+	return NewOllamaClientWithURL(DefaultBaseURL, model, logger)
+}
+
+func NewOllamaClientWithURL(baseURL string, model string, logger *logrus.Logger) *client.Client {
+	return &client.Client{
+		Client: &OllamaClient{
+			BaseURL: baseURL,
+			Model:   model,
+			Logger:  logger,
+		},
+		ContextDepth:   8,
+		DefaultContext: "",
+		Logger:         logger,
+	}
+}
+
+// supportedOptions are the request options that map onto Options.
+var supportedOptions = []string{
+	client.OptionTemperature,
+	client.OptionTopP,
+	client.OptionTopK,
+	client.OptionMaxTokens,
+	client.OptionStop,
+	client.OptionSeed,
+	client.OptionPresencePenalty,
+	client.OptionFrequencyPenalty,
+}
+
+func (c *OllamaClient) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return c.send(messages, context, nil, nil)
+}
+
+// SendMessagesWithOptions overrides Options for one request.
+func (c *OllamaClient) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	return c.send(messages, context, nil, options)
+}
+
+// SendMessagesWithFormat sets the format of the request to the schema, or to
+// "json" for any JSON object.
+func (c *OllamaClient) SendMessagesWithFormat(messages []db.Message, context []string, format *client.ResponseFormat) ([]db.Message, error) {
+	return c.send(messages, context, format, nil)
+}
+
+func (c *OllamaClient) send(messages []db.Message, context []string, format *client.ResponseFormat, options *client.RequestOptions) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, false, options)
+	if err != nil {
+		return nil, err
+	}
+	if format != nil && format.Schema != nil {
+		request.Format = format.Schema
+	} else if format != nil {
+		request.Format = "json"
+	}
+	resp, err := c.post(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("error response from Ollama: %s", response.Error)
+	}
+	return appendAnswer(messages, response.Message.Content, &response), nil
+}
+
+// StreamMessages reads the answer as newline-delimited JSON objects and calls
+// onChunk with the content of each of them.
+func (c *OllamaClient) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	return c.StreamMessagesWithOptions(messages, context, nil, onChunk)
+}
+
+func (c *OllamaClient) StreamMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions, onChunk func(chunk string) error) ([]db.Message, error) {
+	request, err := c.buildRequest(messages, context, true, options)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk ChatResponse
+		err := decoder.Decode(&chunk)
+		if errors.Is(err, io.EOF) {
+			// The connection was cut, the answer would be truncated.
+			return nil, &client.APIError{Provider: "Ollama", StatusCode: resp.StatusCode, Message: "stream ended before the answer was done", Retryable: true}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse Ollama stream: %v", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("error response from Ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			if err := onChunk(chunk.Message.Content); err != nil {
+				return nil, err
+			}
+		}
+		if chunk.Done {
+			return appendAnswer(messages, text.String(), &chunk), nil
+		}
+	}
+}
+
+func appendAnswer(messages []db.Message, content string, done *ChatResponse) []db.Message {
+	newMessage := db.CreateNewMessage(db.AssistentRoleNeam, content, messages[0].ContextId)
+	newMessage.Metadata = db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            done.Model,
+		FinishReason:     done.DoneReason,
+		PromptTokens:     done.PromptEvalCount,
+		CompletionTokens: done.EvalCount,
+		TotalTokens:      done.PromptEvalCount + done.EvalCount,
+	}
+	return append(messages, newMessage)
+}
+
+// buildRequest sends the context as system messages ahead of the history,
+// Ollama applies the chat template of the model itself.
+func (c *OllamaClient) buildRequest(messages []db.Message, context []string, stream bool, options *client.RequestOptions) (*ChatRequest, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("no messages to send")
+	}
+	if err := options.Check(ProviderName, c.Logger, supportedOptions...); err != nil {
+		return nil, err
+	}
+	if c.Model == "" {
+		return nil, errors.New("Ollama model is not set")
+	}
+	ollamaMessages := make([]Message, 0, len(context)+len(messages))
+	for _, contextMsg := range context {
+		ollamaMessages = append(ollamaMessages, Message{Role: db.SystemRoleName, Content: contextMsg})
+	}
+	for _, msg := range messages {
+		ollamaMessages = append(ollamaMessages, Message{Role: msg.Role, Content: msg.Content})
+	}
+	return &ChatRequest{
+		Model:     c.Model,
+		Messages:  ollamaMessages,
+		Stream:    stream,
+		KeepAlive: c.KeepAlive,
+		Options:   c.options(options),
+	}, nil
+}
+
+// options is Options with the request options applied.
+func (c *OllamaClient) options(options *client.RequestOptions) map[string]interface{} {
+	if options == nil {
+		return c.Options
+	}
+	merged := make(map[string]interface{}, len(c.Options))
+	for key, value := range c.Options {
+		merged[key] = value
+	}
+	if options.Temperature != nil {
+		merged["temperature"] = *options.Temperature
+	}
+	if options.TopP != nil {
+		merged["top_p"] = *options.TopP
+	}
+	if options.TopK != nil {
+		merged["top_k"] = *options.TopK
+	}
+	if options.MaxTokens > 0 {
+		merged["num_predict"] = options.MaxTokens
+	}
+	if len(options.Stop) > 0 {
+		merged["stop"] = options.Stop
+	}
+	if options.Seed != nil {
+		merged["seed"] = *options.Seed
+	}
+	if options.PresencePenalty != nil {
+		merged["presence_penalty"] = *options.PresencePenalty
+	}
+	if options.FrequencyPenalty != nil {
+		merged["frequency_penalty"] = *options.FrequencyPenalty
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// ListModels returns the models installed on the server.
+func (c *OllamaClient) ListModels() ([]ModelInfo, error) {
+	resp, err := http.Get(c.baseURL() + "/api/tags")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var tags tagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	return tags.Models, nil
+}
+
+// GetListOfModels returns the names of the installed models.
+func (c *OllamaClient) GetListOfModels() ([]string, error) {
+	models, err := c.ListModels()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(models))
+	for _, model := range models {
+		names = append(names, model.Name)
+	}
+	return names, nil
+}
+
+func (c *OllamaClient) baseURL() string {
+	if c.BaseURL == "" {
+		return DefaultBaseURL
+	}
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+func (c *OllamaClient) post(request *ChatRequest) (*http.Response, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"requestBody": string(requestBody),
+		}).Debug("Ollama request")
+	}
+	resp, err := http.Post(c.baseURL()+"/api/chat", "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var apiError errorResponse
+	if json.Unmarshal(body, &apiError) == nil && apiError.Error != "" {
+		return client.NewAPIError("Ollama", resp.StatusCode, apiError.Error)
+	}
+	return client.NewAPIError("Ollama", resp.StatusCode, string(body))
+}
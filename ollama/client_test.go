@@ -0,0 +1,143 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendMessages(t *testing.T) {
+	var received ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"model": "llama3", "message": {"role": "assistant", "content": "Paris"}, "done": true, "done_reason": "stop", "prompt_eval_count": 26, "eval_count": 2}`))
+	}))
+	defer server.Close()
+
+	c := &OllamaClient{
+		BaseURL:   server.URL,
+		Model:     "llama3",
+		KeepAlive: "10m",
+		Options:   map[string]interface{}{"temperature": 0.1},
+	}
+	history := []db.Message{
+		db.CreateNewMessage(db.UserRoleName, "Hi", "ctx"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "Hello!", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "Capital of France?", "ctx"),
+	}
+	result, err := c.SendMessages(history, []string{"Answer with one word."})
+	require.NoError(t, err)
+	require.Len(t, result, len(history)+1)
+	assert.Equal(t, "Paris", result[3].Content)
+	assert.Equal(t, db.MessageMetadata{
+		Provider:         ProviderName,
+		Model:            "llama3",
+		FinishReason:     "stop",
+		PromptTokens:     26,
+		CompletionTokens: 2,
+		TotalTokens:      28,
+	}, result[3].Metadata)
+
+	assert.Equal(t, ChatRequest{
+		Model: "llama3",
+		Messages: []Message{
+			{Role: "system", Content: "Answer with one word."},
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello!"},
+			{Role: "user", Content: "Capital of France?"},
+		},
+		KeepAlive: "10m",
+		Options:   map[string]interface{}{"temperature": 0.1},
+	}, received)
+}
+
+func TestStreamMessages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ChatRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.True(t, request.Stream)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"model": "llama3", "message": {"role": "assistant", "content": "Hel"}, "done": false}`)
+		fmt.Fprintln(w, `{"model": "llama3", "message": {"role": "assistant", "content": "lo!"}, "done": false}`)
+		fmt.Fprintln(w, `{"model": "llama3", "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "length", "prompt_eval_count": 5, "eval_count": 2}`)
+	}))
+	defer server.Close()
+
+	c := &OllamaClient{BaseURL: server.URL, Model: "llama3"}
+	chunks := make([]string, 0)
+	result, err := c.StreamMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hel", "lo!"}, chunks)
+	require.Len(t, result, 2)
+	assert.Equal(t, "Hello!", result[1].Content)
+	assert.Equal(t, "length", result[1].Metadata.FinishReason)
+	assert.Equal(t, 7, result[1].Metadata.TotalTokens)
+}
+
+func TestStreamErrorsAreReported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model": "llama3", "message": {"role": "assistant", "content": "Hel"}, "done": false}`)
+		fmt.Fprintln(w, `{"error": "model runner has unexpectedly stopped"}`)
+	}))
+	defer server.Close()
+
+	c := &OllamaClient{BaseURL: server.URL, Model: "llama3"}
+	_, err := c.StreamMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil, func(string) error { return nil })
+	assert.EqualError(t, err, "error response from Ollama: model runner has unexpectedly stopped")
+}
+
+func TestTruncatedStreamIsAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model": "llama3", "message": {"role": "assistant", "content": "Hel"}, "done": false}`)
+	}))
+	defer server.Close()
+
+	c := &OllamaClient{BaseURL: server.URL, Model: "llama3"}
+	_, err := c.StreamMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil, func(string) error { return nil })
+	assert.EqualError(t, err, "error response from Ollama (200): stream ended before the answer was done")
+	assert.True(t, client.IsRetryable(err))
+}
+
+func TestMissingModelIsReported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "model \"llama9\" not found, try pulling it first"}`))
+	}))
+	defer server.Close()
+
+	c := &OllamaClient{BaseURL: server.URL, Model: "llama9"}
+	_, err := c.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil)
+	assert.EqualError(t, err, `error response from Ollama (404): model "llama9" not found, try pulling it first`)
+}
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/tags", r.URL.Path)
+		w.Write([]byte(`{"models": [
+			{"name": "llama3:latest", "model": "llama3:latest", "size": 4661224676, "digest": "365c0bd3c000"},
+			{"name": "mistral:7b", "model": "mistral:7b", "size": 4109865159, "digest": "f974a74358d6"}
+		]}`))
+	}))
+	defer server.Close()
+
+	c := &OllamaClient{BaseURL: server.URL + "/"}
+	models, err := c.ListModels()
+	require.NoError(t, err)
+	require.Len(t, models, 2)
+	assert.Equal(t, int64(4661224676), models[0].Size)
+
+	names, err := c.GetListOfModels()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"llama3:latest", "mistral:7b"}, names)
+}
@@ -0,0 +1,6 @@
+package ollama
+
+const DefaultBaseURL = "http://localhost:11434"
+
+// ProviderName identifies Ollama answers in db.MessageMetadata.
+const ProviderName = "ollama"
@@ -0,0 +1,46 @@
+package ollama
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ChatRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream"`
+	// KeepAlive is how long the model stays loaded, e.g. "10m" or "-1".
+	KeepAlive string                 `json:"keep_alive,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	// Format is "json" or a JSON schema the answer must match.
+	Format interface{} `json:"format,omitempty"`
+}
+
+// ChatResponse is the whole answer, or one line of a streamed answer where
+// the last line has Done set and carries the statistics.
+type ChatResponse struct {
+	Model           string  `json:"model"`
+	CreatedAt       string  `json:"created_at"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+	Error           string  `json:"error"`
+}
+
+type ModelInfo struct {
+	Name       string `json:"name"`
+	Model      string `json:"model"`
+	ModifiedAt string `json:"modified_at"`
+	Size       int64  `json:"size"`
+	Digest     string `json:"digest"`
+}
+
+type tagsResponse struct {
+	Models []ModelInfo `json:"models"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
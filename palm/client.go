@@ -1,138 +1,245 @@
 package palm
+
 import (
-	"fmt"
-	"context"
-	"encoding/json"
-	"net/http"
 	"bytes"
-	"io/ioutil"
-	"github.com/assistant-ai/llmchat-client/db"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/assistant-ai/llmchat-client/client"
-	"golang.org/x/oauth2/google"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/gcpauth"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"sync"
 )
 
 type PalmClient struct {
+	// TokenSource issues the OAuth access tokens for Vertex AI. Tokens are
+	// refreshed shortly before they expire and a request rejected with 401
+	// is retried once with a new token.
+	TokenSource oauth2.TokenSource
+	// GCPAccessToken is a fixed access token, used only when TokenSource is
+	// nil.
 	GCPAccessToken string
-	GCPProjectId string
+	GCPProjectId   string
+	// Examples are sample exchanges that show the model how to answer.
+	Examples []PalmExample
+	// Logger receives warnings such as ignored request options, may be nil.
+	Logger *logrus.Logger
+	// Config holds the defaults for every request, unset fields fall back to
+	// DefaultLocation, ModelChatBison and DefaultParameters.
+	Config
+
+	tokensMu sync.Mutex
+	tokens   *gcpauth.RefreshingTokenSource
 }
 
 func NewDefaultTokenPalmClient(GCPProjectId string) (*client.Client, error) {
-	token, err := getDefaultAccesstToken()
-	if (err != nil) {
+	tokenSource, err := gcpauth.DefaultTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	palmClient, err := newPalmClientWithTokenSource(GCPProjectId, tokenSource)
+	if err != nil {
 		return nil, err
 	}
 	return &client.Client{
-		Client:  &PalmClient{
-			GCPAccessToken: token,
-			GCPProjectId: GCPProjectId,
-		},
+		Client:         palmClient,
 		ContextDepth:   8,
 		DefaultContext: "",
 	}, nil
 }
 
 func NewPalmClient(GCPProjectId string, serviceAccountJsonPath string) (*client.Client, error) {
-	token, err := getAccessTokenFromFile(serviceAccountJsonPath)
-	if (err != nil) {
+	tokenSource, err := gcpauth.TokenSourceFromFile(serviceAccountJsonPath)
+	if err != nil {
+		return nil, err
+	}
+	palmClient, err := newPalmClientWithTokenSource(GCPProjectId, tokenSource)
+	if err != nil {
 		return nil, err
 	}
 	return &client.Client{
-		Client:  &PalmClient{
-			GCPAccessToken: token,
-			GCPProjectId: GCPProjectId,
-		},
+		Client:         palmClient,
 		ContextDepth:   8,
 		DefaultContext: db.RandomContextId,
 	}, nil
 }
 
-func getDefaultAccesstToken() (string, error) {
-	ctx := context.Background()
-	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
-		return "", fmt.Errorf("unable to find default credentials: %v", err)
-	}
-	return getAccessToken(creds)
+// NewPalmClientWithTokenSource creates a client that takes access tokens from
+// tokenSource, e.g. a custom issuer or oauth2.StaticTokenSource in tests.
+func NewPalmClientWithTokenSource(GCPProjectId string, tokenSource oauth2.TokenSource) *client.Client {
+	return NewPalmClientWithConfig(GCPProjectId, tokenSource, Config{})
 }
 
-func getAccessTokenFromFile(saFilePath string) (string, error) {
-	ctx := context.Background()
-
-	// Read service account file content
-	jsonKey, err := ioutil.ReadFile(saFilePath)
-	if err != nil {
-		return "", fmt.Errorf("unable to read service account file: %v", err)
+// NewPalmClientWithConfig creates a client with its own region, model and
+// parameters, e.g. Config{Location: "europe-west4", Model: ModelChatBison32k}.
+func NewPalmClientWithConfig(GCPProjectId string, tokenSource oauth2.TokenSource, config Config) *client.Client {
+	return &client.Client{
+		Client: &PalmClient{
+			TokenSource:  tokenSource,
+			GCPProjectId: GCPProjectId,
+			Config:       config,
+		},
+		ContextDepth:   8,
+		DefaultContext: "",
 	}
+}
 
-	// Parse the credentials from the JSON key
-	creds, err := google.CredentialsFromJSON(ctx, jsonKey, "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
-		return "", fmt.Errorf("unable to parse service account credentials: %v", err)
+// newPalmClientWithTokenSource fetches the first token right away, so broken
+// credentials are reported by the constructor and not by the first message.
+func newPalmClientWithTokenSource(GCPProjectId string, tokenSource oauth2.TokenSource) (*PalmClient, error) {
+	palmClient := &PalmClient{
+		TokenSource:  tokenSource,
+		GCPProjectId: GCPProjectId,
 	}
-	return getAccessToken(creds)
+	if _, err := palmClient.tokenSource().Token(); err != nil {
+		return nil, err
+	}
+	return palmClient, nil
 }
 
-func getAccessToken(creds *google.Credentials) (string, error) {
-	tokenSource := creds.TokenSource
-	token, err := tokenSource.Token()
-	if err != nil {
-		return "", fmt.Errorf("unable to obtain access token: %v", err)
+func (c *PalmClient) tokenSource() *gcpauth.RefreshingTokenSource {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	if c.tokens == nil {
+		base := c.TokenSource
+		if base == nil {
+			base = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.GCPAccessToken})
+		}
+		c.tokens = gcpauth.NewRefreshingTokenSource(base)
 	}
+	return c.tokens
+}
 
-	return token.AccessToken, nil
+// Config selects where and how messages are sent. Zero fields are not set.
+type Config struct {
+	// Location is the Vertex AI region, e.g. "europe-west4".
+	Location string
+	// BaseURL overrides the endpoint derived from Location, e.g. for a
+	// proxy or a test server.
+	BaseURL    string
+	Model      *PalmModel
+	Parameters *Parameters
 }
 
-func hackyZipAllMessagesInOne(messages []PalmMessage) []PalmMessage {
-	finalMessage := ""
-	for _, msg := range messages {
-		finalMessage = fmt.Sprintf("%s: %s\n", msg.Author, msg.Content)
+// merge returns c with the zero fields taken from defaults.
+func (c Config) merge(defaults Config) Config {
+	if c.Location == "" {
+		c.Location = defaults.Location
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = defaults.BaseURL
+	}
+	if c.Model == nil {
+		c.Model = defaults.Model
+	}
+	if c.Parameters == nil {
+		c.Parameters = defaults.Parameters
 	}
-	newMessages := make([]PalmMessage, 1)
-	newMessages[0] = PalmMessage{
-		Author: "user",
-		Content: finalMessage,
+	return c
+}
+
+func (c Config) predictURL(projectId string) string {
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", c.Location)
 	}
-	return newMessages
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/google/models/%s:predict", baseURL, projectId, c.Location, c.Model.Name)
 }
 
+const palmUserAuthor = "user"
+const palmBotAuthor = "bot"
+
 func (c *PalmClient) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
-	apiEndpoint := "us-central1-aiplatform.googleapis.com"
-	modelID := "chat-bison"
+	return c.SendMessagesWithConfig(messages, context, Config{})
+}
 
-	url := fmt.Sprintf("https://%s/v1/projects/%s/locations/us-central1/publishers/google/models/%s:predict", apiEndpoint, c.GCPProjectId, modelID)
+// SendMessagesWithOptions maps temperature, top_p, top_k, max_tokens and
+// stop onto the parameters of the client, chat-bison has no other settings.
+func (c *PalmClient) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	if err := options.Check(ProviderName, c.Logger, client.OptionTemperature, client.OptionTopP, client.OptionTopK, client.OptionMaxTokens, client.OptionStop); err != nil {
+		return nil, err
+	}
+	if options == nil {
+		return c.SendMessages(messages, context)
+	}
+	parameters := DefaultParameters
+	if c.Config.Parameters != nil {
+		parameters = *c.Config.Parameters
+	}
+	if options.Temperature != nil {
+		parameters.Temperature = *options.Temperature
+	}
+	if options.TopP != nil {
+		parameters.TopP = *options.TopP
+	}
+	if options.TopK != nil {
+		parameters.TopK = *options.TopK
+	}
+	if options.MaxTokens > 0 {
+		parameters.MaxOutputTokens = options.MaxTokens
+	}
+	if len(options.Stop) > 0 {
+		parameters.StopSequences = options.Stop
+	}
+	return c.SendMessagesWithConfig(messages, context, Config{Parameters: &parameters})
+}
 
-	palmMessages := make([]PalmMessage, 0, len(messages))
-	for _, msg := range messages {
-		palmMessages = append(palmMessages, dbMessageToPalmMessage(msg))
+// SendMessagesWithConfig sends messages with per-request settings, the zero
+// fields of config are taken from the client.
+func (c *PalmClient) SendMessagesWithConfig(messages []db.Message, context []string, config Config) ([]db.Message, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("no messages to send")
 	}
+	config = config.merge(c.Config).merge(Config{
+		Location:   DefaultLocation,
+		Model:      ModelChatBison,
+		Parameters: &DefaultParameters,
+	})
+	parameters := *config.Parameters
+	if parameters.MaxOutputTokens > config.Model.MaxOutputTokens {
+		parameters.MaxOutputTokens = config.Model.MaxOutputTokens
+	}
+	url := config.predictURL(c.GCPProjectId)
 
 	finalContext := ""
-
 	for _, contextMsg := range context {
 		finalContext = finalContext + "\n" + contextMsg
 	}
+	for _, msg := range messages {
+		// chat-bison has no system author, system messages become context.
+		if msg.Role == db.SystemRoleName {
+			finalContext = finalContext + "\n" + msg.Content
+		}
+	}
 
-	palmMessages, err := TrimIfNeeded(palmMessages, finalContext)
+	palmMessages, err := TrimIfNeeded(dbMessagesToPalmMessages(messages), finalContext, config.Model.MaxInputTokens)
 	if err != nil {
 		return nil, err
 	}
+	palmMessages = alternateAuthors(palmMessages)
+	if len(palmMessages) == 0 {
+		return nil, errors.New("no user message to send")
+	}
 
+	examples := c.Examples
+	if examples == nil {
+		examples = make([]PalmExample, 0)
+	}
 	palmInstance := &PalmInstance{
-		Context: finalContext,
-		Examples: make([]string, 0),
-		Messages: hackyZipAllMessagesInOne(palmMessages),
+		Context:  finalContext,
+		Examples: examples,
+		Messages: palmMessages,
 	}
 	palmInstances := make([]PalmInstance, 1)
 	palmInstances[0] = *palmInstance
 
 	payload := PredictPayload{
-		Instances: palmInstances,
-		Parameters: Parameters{
-			Temperature:     0.2,
-			MaxOutputTokens: 1000,
-			TopP:            0.9,
-			TopK:            40,
-		},
+		Instances:  palmInstances,
+		Parameters: parameters,
 	}
 
 	data, err := json.Marshal(payload)
@@ -140,27 +247,13 @@ func (c *PalmClient) SendMessages(messages []db.Message, context []string) ([]db
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.GCPAccessToken))
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	responseBody, err := ioutil.ReadAll(resp.Body)
+	responseBody, err := c.post(url, data)
 	if err != nil {
 		return nil, err
 	}
 
 	var predictResp PredictResponse
-	
+
 	err = json.Unmarshal(responseBody, &predictResp)
 	if err != nil {
 		return nil, err
@@ -170,9 +263,21 @@ func (c *PalmClient) SendMessages(messages []db.Message, context []string) ([]db
 	newDbMessages := make([]db.Message, 0, len(predictResp.Predictions))
 	for _, prediction := range predictResp.Predictions {
 		for _, candidate := range prediction.Candidates {
-			newDbMessages = append(newDbMessages, db.CreateNewMessage(db.AssistentRoleNeam, candidate.Content, messages[0].ContextId))
+			newMessage := db.CreateNewMessage(db.AssistentRoleNeam, candidate.Content, messages[0].ContextId)
+			tokens := predictResp.Metadata.TokenMetadata
+			newMessage.Metadata = db.MessageMetadata{
+				Provider:         ProviderName,
+				Model:            config.Model.Name,
+				PromptTokens:     tokens.InputTokenCount.TotalTokens,
+				CompletionTokens: tokens.OutputTokenCount.TotalTokens,
+				TotalTokens:      tokens.InputTokenCount.TotalTokens + tokens.OutputTokenCount.TotalTokens,
+			}
+			newDbMessages = append(newDbMessages, newMessage)
 		}
 	}
+	if len(newDbMessages) == 0 {
+		return nil, fmt.Errorf("no candidates in PaLM response: %s", string(responseBody))
+	}
 
 	messages = append(messages, newDbMessages...)
 
@@ -180,9 +285,65 @@ func (c *PalmClient) SendMessages(messages []db.Message, context []string) ([]db
 	return messages, nil
 }
 
-func dbMessageToPalmMessage(dbMsg db.Message) PalmMessage {
-	return PalmMessage{
-		Author:  dbMsg.Role,
-		Content: dbMsg.Content,
+// post sends an authorized request and returns the response body. When the
+// token is rejected with 401 the request is retried once with a new token.
+func (c *PalmClient) post(url string, data []byte) ([]byte, error) {
+	resp, err := c.tokenSource().Do(&http.Client{}, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, client.NewAPIError("PaLM", resp.StatusCode, string(responseBody))
+	}
+	return responseBody, nil
+}
+
+// dbMessagesToPalmMessages maps user messages to the "user" author and
+// answers to the "bot" author, system messages are sent as context instead.
+func dbMessagesToPalmMessages(messages []db.Message) []PalmMessage {
+	palmMessages := make([]PalmMessage, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case db.SystemRoleName:
+			continue
+		case db.AssistentRoleNeam:
+			palmMessages = append(palmMessages, PalmMessage{Author: palmBotAuthor, Content: msg.Content})
+		default:
+			palmMessages = append(palmMessages, PalmMessage{Author: palmUserAuthor, Content: msg.Content})
+		}
+	}
+	return palmMessages
+}
+
+// alternateAuthors makes the conversation acceptable for chat-bison: it has
+// to start and end with the user and authors have to alternate. Consecutive
+// messages of one author are joined and leading bot messages are dropped.
+func alternateAuthors(messages []PalmMessage) []PalmMessage {
+	result := make([]PalmMessage, 0, len(messages))
+	for _, msg := range messages {
+		if len(result) == 0 && msg.Author != palmUserAuthor {
+			continue
+		}
+		if len(result) > 0 && result[len(result)-1].Author == msg.Author {
+			result[len(result)-1].Content += "\n" + msg.Content
+			continue
+		}
+		result = append(result, msg)
+	}
+	for len(result) > 0 && result[len(result)-1].Author != palmUserAuthor {
+		result = result[:len(result)-1]
 	}
+	return result
 }
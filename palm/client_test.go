@@ -1,11 +1,39 @@
 package palm
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
 )
 
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
 func TestSendMessage(t *testing.T) {
-	client, err := NewPalmClient("ml-lab-152505", "/Users/slava.kovalevskyi/Downloads/ml-lab-152505-1829490d6dd7.json")
+	serviceAccountJsonPath := "/Users/slava.kovalevskyi/Downloads/ml-lab-152505-1829490d6dd7.json"
+	if _, err := os.Stat(serviceAccountJsonPath); err != nil {
+		t.Skipf("Service account file is not available: %v", err)
+	}
+	client, err := NewPalmClient("ml-lab-152505", serviceAccountJsonPath)
 
 	if err != nil {
 		t.Fatalf("Failed to send message: %v", err)
@@ -26,3 +54,221 @@ func TestSendMessage(t *testing.T) {
 		t.Fatalf("Assistant response is empty")
 	}
 }
+
+func TestSendMessagesKeepsHistory(t *testing.T) {
+	var received PredictPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/projects/project/locations/us-central1/publishers/google/models/chat-bison:predict", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"predictions": [{"candidates": [{"author": "bot", "content": "Paris"}]}]}`))
+	}))
+	defer server.Close()
+
+	c := &PalmClient{
+		GCPAccessToken: "token",
+		GCPProjectId:   "project",
+		Config:         Config{BaseURL: server.URL},
+		Examples: []PalmExample{{
+			Input:  PalmExampleContent{Content: "Capital of Italy?"},
+			Output: PalmExampleContent{Content: "Rome"},
+		}},
+	}
+	history := []db.Message{
+		db.CreateNewMessage(db.AssistentRoleNeam, "dangling answer", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "Hi", "ctx"),
+		db.CreateNewMessage(db.AssistentRoleNeam, "Hello!", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "I am planning a trip.", "ctx"),
+		db.CreateNewMessage(db.UserRoleName, "Capital of France?", "ctx"),
+	}
+
+	result, err := c.SendMessages(history, []string{"Answer with one word."})
+	require.NoError(t, err)
+	require.Len(t, result, len(history)+1)
+	assert.Equal(t, db.AssistentRoleNeam, result[len(result)-1].Role)
+	assert.Equal(t, "Paris", result[len(result)-1].Content)
+
+	require.Len(t, received.Instances, 1)
+	instance := received.Instances[0]
+	assert.Equal(t, "\nAnswer with one word.", instance.Context)
+	assert.Equal(t, []PalmMessage{
+		{Author: "user", Content: "Hi"},
+		{Author: "bot", Content: "Hello!"},
+		{Author: "user", Content: "I am planning a trip.\nCapital of France?"},
+	}, instance.Messages)
+	assert.Equal(t, c.Examples, instance.Examples)
+}
+
+func TestSendMessagesReturnsApiErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"message": "bad request"}}`))
+	}))
+	defer server.Close()
+
+	c := &PalmClient{GCPProjectId: "project", Config: Config{BaseURL: server.URL}}
+	_, err := c.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil)
+	assert.ErrorContains(t, err, "bad request")
+}
+
+// fakeIssuer hands out numbered tokens that expire after lifetime.
+type fakeIssuer struct {
+	mu       sync.Mutex
+	issued   int
+	lifetime time.Duration
+}
+
+func (f *fakeIssuer) Token() (*oauth2.Token, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.issued++
+	return &oauth2.Token{
+		AccessToken: fmt.Sprintf("token-%d", f.issued),
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(f.lifetime),
+	}, nil
+}
+
+func newTokenCheckingServer(t *testing.T, validToken func(string) bool) (*httptest.Server, *[]string) {
+	seen := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		seen = append(seen, token)
+		if !validToken(token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": 401}}`))
+			return
+		}
+		w.Write([]byte(`{"predictions": [{"candidates": [{"author": "bot", "content": "ok"}]}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &seen
+}
+
+func TestTokenIsRefreshedBeforeExpiry(t *testing.T) {
+	server, seen := newTokenCheckingServer(t, func(string) bool { return true })
+	issuer := &fakeIssuer{lifetime: time.Minute}
+	c := &PalmClient{TokenSource: issuer, GCPProjectId: "project", Config: Config{BaseURL: server.URL}}
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}
+
+	_, err := c.SendMessages(messages, nil)
+	require.NoError(t, err)
+	_, err = c.SendMessages(messages, nil)
+	require.NoError(t, err)
+	// A token that expires within the refresh margin is never reused.
+	assert.Equal(t, []string{"token-1", "token-2"}, *seen)
+
+	issuer.lifetime = time.Hour
+	_, err = c.SendMessages(messages, nil)
+	require.NoError(t, err)
+	_, err = c.SendMessages(messages, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-1", "token-2", "token-3", "token-3"}, *seen)
+}
+
+func TestUnauthorizedIsRetriedOnceWithNewToken(t *testing.T) {
+	revoked := "token-1"
+	server, seen := newTokenCheckingServer(t, func(token string) bool { return token != revoked })
+	issuer := &fakeIssuer{lifetime: time.Hour}
+	c := &PalmClient{TokenSource: issuer, GCPProjectId: "project", Config: Config{BaseURL: server.URL}}
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}
+
+	result, err := c.SendMessages(messages, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", result[len(result)-1].Content)
+	assert.Equal(t, []string{"token-1", "token-2"}, *seen)
+
+	// Only one retry: a source that keeps issuing rejected tokens fails.
+	revoked = "token-3"
+	c = &PalmClient{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token-3"}), GCPProjectId: "project", Config: Config{BaseURL: server.URL}}
+	_, err = c.SendMessages(messages, nil)
+	assert.ErrorContains(t, err, "401")
+	assert.Equal(t, []string{"token-1", "token-2", "token-3", "token-3"}, *seen)
+}
+
+func TestNewPalmClientWithTokenSource(t *testing.T) {
+	server, seen := newTokenCheckingServer(t, func(string) bool { return true })
+	c := NewPalmClientWithTokenSource("project", &fakeIssuer{lifetime: time.Hour})
+	c.Client.(*PalmClient).BaseURL = server.URL
+	c.Store = newTestStore(t)
+
+	answer, err := c.SendMessage("Hi", "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", answer)
+	assert.Equal(t, []string{"token-1"}, *seen)
+}
+
+func TestConfigPerClientAndPerRequest(t *testing.T) {
+	var paths []string
+	var received []PredictPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		var payload PredictPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received = append(received, payload)
+		w.Write([]byte(`{"predictions": [{"candidates": [{"author": "bot", "content": "ok"}]}]}`))
+	}))
+	defer server.Close()
+
+	c := NewPalmClientWithConfig("project", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), Config{
+		Location: "europe-west4",
+		BaseURL:  server.URL,
+		Model:    ModelChatBison32k,
+	}).Client.(*PalmClient)
+	messages := []db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}
+
+	_, err := c.SendMessages(messages, nil)
+	require.NoError(t, err)
+	_, err = c.SendMessagesWithConfig(messages, nil, Config{
+		Model:      ModelCodechatBison,
+		Parameters: &Parameters{Temperature: 0, MaxOutputTokens: 4096, TopP: 1, TopK: 1, StopSequences: []string{"END"}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"/v1/projects/project/locations/europe-west4/publishers/google/models/chat-bison-32k:predict",
+		"/v1/projects/project/locations/europe-west4/publishers/google/models/codechat-bison:predict",
+	}, paths)
+	assert.Equal(t, DefaultParameters, received[0].Parameters)
+	assert.Equal(t, Parameters{Temperature: 0, MaxOutputTokens: 1024, TopP: 1, TopK: 1, StopSequences: []string{"END"}}, received[1].Parameters,
+		"max output tokens are capped by the model")
+}
+
+func TestTrimIfNeededUsesModelLimit(t *testing.T) {
+	messages := []PalmMessage{
+		{Author: "user", Content: strings.Repeat("a", 3000)},
+		{Author: "bot", Content: strings.Repeat("b", 3000)},
+		{Author: "user", Content: "question"},
+	}
+	trimmed, err := TrimIfNeeded(messages, "", ModelChatBison001.MaxInputTokens)
+	require.NoError(t, err)
+	assert.Len(t, trimmed, 2)
+
+	trimmed, err = TrimIfNeeded(messages, "", ModelChatBison32k.MaxInputTokens)
+	require.NoError(t, err)
+	assert.Len(t, trimmed, 3)
+
+	_, err = TrimIfNeeded(messages[2:], strings.Repeat("c", 5000), ModelChatBison001.MaxInputTokens)
+	assert.Error(t, err)
+}
+
+func TestIgnoredOptionsAreLogged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"predictions": [{"candidates": [{"author": "bot", "content": "ok"}]}]}`))
+	}))
+	defer server.Close()
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logs
+	c := NewPalmClientWithConfig("project", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), Config{
+		BaseURL: server.URL,
+	}).Client.(*PalmClient)
+	c.Logger = logger
+
+	_, err := c.SendMessagesWithOptions([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hi", "ctx")}, nil, &client.RequestOptions{
+		User:              "user-1",
+		IgnoreUnsupported: true,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, logs.String(), "Ignoring unsupported request options")
+}
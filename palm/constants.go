@@ -1,3 +1,61 @@
 package palm
 
-const MAX_INPUT_TOKENS = 4096
\ No newline at end of file
+// ProviderName identifies PaLM answers in db.MessageMetadata.
+const ProviderName = "vertex"
+
+const DefaultLocation = "us-central1"
+
+// PalmModel describes a Vertex AI chat model. Token limits are compared with
+// the character count of the messages, which keeps requests well within the
+// real limits.
+type PalmModel struct {
+	Name            string `json:"name"`
+	MaxInputTokens  int    `json:"max_input_tokens"`
+	MaxOutputTokens int    `json:"max_output_tokens"`
+}
+
+// ModelChatBison is the latest stable chat-bison version.
+var ModelChatBison = &PalmModel{
+	Name:            "chat-bison",
+	MaxInputTokens:  8192,
+	MaxOutputTokens: 2048,
+}
+
+var ModelChatBison001 = &PalmModel{
+	Name:            "chat-bison@001",
+	MaxInputTokens:  4096,
+	MaxOutputTokens: 1024,
+}
+
+var ModelChatBison002 = &PalmModel{
+	Name:            "chat-bison@002",
+	MaxInputTokens:  8192,
+	MaxOutputTokens: 2048,
+}
+
+var ModelChatBison32k = &PalmModel{
+	Name:            "chat-bison-32k",
+	MaxInputTokens:  24576,
+	MaxOutputTokens: 8192,
+}
+
+var ModelCodechatBison = &PalmModel{
+	Name:            "codechat-bison",
+	MaxInputTokens:  6144,
+	MaxOutputTokens: 1024,
+}
+
+var ModelCodechatBison32k = &PalmModel{
+	Name:            "codechat-bison-32k",
+	MaxInputTokens:  24576,
+	MaxOutputTokens: 8192,
+}
+
+// DefaultParameters are used when neither the client nor the request sets
+// parameters.
+var DefaultParameters = Parameters{
+	Temperature:     0.2,
+	MaxOutputTokens: 1000,
+	TopP:            0.9,
+	TopK:            40,
+}
@@ -1,30 +1,51 @@
 package palm
 
 type PalmInstance struct {
-	Context string `json:"context"`
-	Examples []string `json:"examples"`
+	Context  string        `json:"context"`
+	Examples []PalmExample `json:"examples"`
 	Messages []PalmMessage `json:"messages"`
 }
 
 type PalmMessage struct {
-	Author string `json:"author"`
+	Author  string `json:"author"`
+	Content string `json:"content"`
+}
+
+type PalmExample struct {
+	Input  PalmExampleContent `json:"input"`
+	Output PalmExampleContent `json:"output"`
+}
+
+type PalmExampleContent struct {
 	Content string `json:"content"`
 }
 
 type PredictPayload struct {
 	Instances  []PalmInstance `json:"instances"`
-	Parameters Parameters   `json:"parameters"`
+	Parameters Parameters     `json:"parameters"`
 }
 
 type Parameters struct {
-	Temperature    float64 `json:"temperature"`
-	MaxOutputTokens int     `json:"maxOutputTokens"`
-	TopP            float64 `json:"topP"`
-	TopK            int     `json:"topK"`
+	Temperature     float64  `json:"temperature"`
+	MaxOutputTokens int      `json:"maxOutputTokens"`
+	TopP            float64  `json:"topP"`
+	TopK            int      `json:"topK"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+	CandidateCount  int      `json:"candidateCount,omitempty"`
 }
 
 type PredictResponse struct {
 	Predictions []struct {
 		Candidates []PalmMessage `json:"candidates"`
 	} `json:"predictions"`
+	Metadata struct {
+		TokenMetadata struct {
+			InputTokenCount  TokenCount `json:"inputTokenCount"`
+			OutputTokenCount TokenCount `json:"outputTokenCount"`
+		} `json:"tokenMetadata"`
+	} `json:"metadata"`
+}
+
+type TokenCount struct {
+	TotalTokens int `json:"totalTokens"`
 }
@@ -4,24 +4,32 @@ import (
 	"fmt"
 )
 
+func GetPalmModels() map[string]*PalmModel {
+	models := make(map[string]*PalmModel)
+	for _, model := range []*PalmModel{ModelChatBison, ModelChatBison001, ModelChatBison002, ModelChatBison32k, ModelCodechatBison, ModelCodechatBison32k} {
+		models[model.Name] = model
+	}
+	return models
+}
+
 func countTokens(messages []PalmMessage, context string) int {
-		totalInputTokens := 0
-		for _, msg := range messages {
-			totalInputTokens += len(msg.Content)
-		}
-		totalInputTokens += len(context)
-		return totalInputTokens
+	totalInputTokens := 0
+	for _, msg := range messages {
+		totalInputTokens += len(msg.Content)
+	}
+	totalInputTokens += len(context)
+	return totalInputTokens
 }
 
-func TrimIfNeeded(messages []PalmMessage, context string) ([]PalmMessage, error) {
+func TrimIfNeeded(messages []PalmMessage, context string, maxInputTokens int) ([]PalmMessage, error) {
 	totalInputTokens := countTokens(messages, context)
-	for totalInputTokens >= MAX_INPUT_TOKENS && len(messages) > 0 {
+	for totalInputTokens >= maxInputTokens && len(messages) > 0 {
 		// Return an error if even one message + context is still bigger
 		if len(messages) == 1 {
-			return nil, fmt.Errorf("the message and context size (%d tokens) exceeds the maximum allowed input tokens (%d)", totalInputTokens, MAX_INPUT_TOKENS)
+			return nil, fmt.Errorf("the message and context size (%d tokens) exceeds the maximum allowed input tokens (%d)", totalInputTokens, maxInputTokens)
 		}
 		messages = messages[1:]
 		totalInputTokens = countTokens(messages, context)
-	}	
+	}
 	return messages, nil
 }
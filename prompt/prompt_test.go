@@ -0,0 +1,113 @@
+package prompt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderChecksVariables(t *testing.T) {
+	r := NewRegistry(nil)
+	_, err := r.Register(Template{
+		Name: "review",
+		Body: "Review {{.language}} code in at most {{.lines}} lines.{{if .strict}} Be strict.{{end}} Focus: {{range $i, $f := .focus}}{{if $i}}, {{end}}{{$f}}{{end}}",
+		Variables: []Variable{
+			{Name: "language", Type: StringVariable, Required: true},
+			{Name: "lines", Type: IntVariable, Default: 10},
+			{Name: "strict", Type: BoolVariable},
+			{Name: "focus", Type: ListVariable, Default: []string{"bugs"}},
+		},
+	})
+	require.NoError(t, err)
+
+	rendered, err := r.Render("review", 0, map[string]interface{}{"language": "Go", "strict": true})
+	require.NoError(t, err)
+	assert.Equal(t, "Review Go code in at most 10 lines. Be strict. Focus: bugs", rendered.Text)
+	assert.Equal(t, 1, rendered.TemplateVersion)
+
+	rendered, err = r.Render("review", 0, map[string]interface{}{"language": "Go", "lines": 3, "focus": []string{"naming", "tests"}})
+	require.NoError(t, err)
+	assert.Equal(t, "Review Go code in at most 3 lines. Focus: naming, tests", rendered.Text)
+
+	_, err = r.Render("review", 0, map[string]interface{}{})
+	assert.EqualError(t, err, `template "review": variable "language" is required`)
+
+	_, err = r.Render("review", 0, map[string]interface{}{"language": "Go", "lines": "ten"})
+	assert.EqualError(t, err, `template "review": variable "lines" must be int, got string`)
+
+	_, err = r.Render("review", 0, map[string]interface{}{"language": "Go", "langauge": "Go"})
+	assert.EqualError(t, err, `template "review": unknown variables: langauge`)
+}
+
+func TestPartialsAndVersions(t *testing.T) {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer store.Close()
+	r := NewRegistry(store)
+
+	_, err = r.Register(Template{Name: "tone", Body: "Answer politely."})
+	require.NoError(t, err)
+	tone, err := r.Register(Template{
+		Name:      "tone",
+		Body:      "Answer in {{.style}} style.",
+		Variables: []Variable{{Name: "style", Type: StringVariable, Default: "a formal"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, tone.Version)
+
+	again, err := r.Register(tone)
+	require.NoError(t, err)
+	assert.Equal(t, 2, again.Version, "registering the same definition does not create a version")
+
+	_, err = r.Register(Template{
+		Name:     "assistant",
+		Body:     "You help {{.user}}. {{template \"tone\" .}}",
+		Partials: []string{"tone"},
+		Variables: []Variable{
+			{Name: "user", Type: StringVariable, Required: true},
+		},
+	})
+	require.NoError(t, err)
+	_, err = r.Register(Template{
+		Name:     "assistant",
+		Body:     "You help {{.user}}. {{template \"tone\" .}}",
+		Partials: []string{"tone@1"},
+		Variables: []Variable{
+			{Name: "user", Type: StringVariable, Required: true},
+		},
+	})
+	require.NoError(t, err)
+
+	rendered, err := r.Render("assistant", 1, map[string]interface{}{"user": "Ann", "style": "a casual"})
+	require.NoError(t, err)
+	assert.Equal(t, "You help Ann. Answer in a casual style.", rendered.Text)
+	assert.Equal(t, map[string]int{"tone": 2}, rendered.Partials)
+
+	rendered, err = r.Render("assistant", 0, map[string]interface{}{"user": "Ann"})
+	require.NoError(t, err)
+	assert.Equal(t, "You help Ann. Answer politely.", rendered.Text)
+	assert.Equal(t, 2, rendered.TemplateVersion)
+
+	versions, err := r.Versions("tone")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "Answer politely.", versions[0].Body)
+
+	_, err = r.Render("missing", 0, nil)
+	assert.ErrorIs(t, err, db.ErrTemplateNotFound)
+}
+
+func TestRegisterRejectsInvalidTemplates(t *testing.T) {
+	r := NewRegistry(nil)
+	_, err := r.Register(Template{Name: "broken", Body: "{{.x"})
+	assert.Error(t, err)
+	_, err = r.Register(Template{Name: "typed", Body: "{{.n}}", Variables: []Variable{{Name: "n", Type: IntVariable, Default: "one"}}})
+	assert.Error(t, err)
+	_, err = r.Register(Template{Name: "self", Body: `{{template "self" .}}`, Partials: []string{"self"}})
+	require.NoError(t, err)
+	_, err = r.Render("self", 0, nil)
+	assert.Error(t, err)
+}
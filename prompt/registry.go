@@ -0,0 +1,268 @@
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// Registry keeps named, versioned templates. With a db.TemplateStore the
+// templates are stored next to the contexts, otherwise they live in memory.
+type Registry struct {
+	store db.TemplateStore
+
+	mu        sync.RWMutex
+	templates map[string][]Template
+}
+
+func NewRegistry(store db.TemplateStore) *Registry {
+	return &Registry{
+		store:     store,
+		templates: make(map[string][]Template),
+	}
+}
+
+// Register adds t as a new version of t.Name and returns it with the version
+// set. Registering the same body, variables and partials as the latest
+// version returns that version instead of creating a new one.
+func (r *Registry) Register(t Template) (Template, error) {
+	if t.Name == "" {
+		return Template{}, errors.New("template name is empty")
+	}
+	if _, err := template.New(t.Name).Parse(t.Body); err != nil {
+		return Template{}, fmt.Errorf("unable to parse template %q: %v", t.Name, err)
+	}
+	for _, v := range t.Variables {
+		if _, err := zeroValue(v.Type); err != nil {
+			return Template{}, fmt.Errorf("variable %q: %v", v.Name, err)
+		}
+		if v.Default != nil {
+			if _, err := convertValue(v, v.Default); err != nil {
+				return Template{}, fmt.Errorf("default of %v", err)
+			}
+		}
+	}
+	for _, ref := range t.Partials {
+		if _, _, err := parsePartialRef(ref); err != nil {
+			return Template{}, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latest, err := r.get(t.Name, 0)
+	if err == nil && sameDefinition(latest, t) {
+		return latest, nil
+	}
+	if err != nil && !errors.Is(err, db.ErrTemplateNotFound) {
+		return Template{}, err
+	}
+
+	if r.store == nil {
+		t.Version = len(r.templates[t.Name]) + 1
+		r.templates[t.Name] = append(r.templates[t.Name], t)
+		return t, nil
+	}
+	stored, err := toPromptTemplate(t)
+	if err != nil {
+		return Template{}, err
+	}
+	stored, err = r.store.SaveTemplate(stored)
+	if err != nil {
+		return Template{}, err
+	}
+	t.Version = stored.Version
+	return t, nil
+}
+
+// Get returns the given version of a template, or the latest one when version
+// is 0.
+func (r *Registry) Get(name string, version int) (Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(name, version)
+}
+
+func (r *Registry) get(name string, version int) (Template, error) {
+	if r.store != nil {
+		stored, err := r.store.GetTemplate(name, version)
+		if err != nil {
+			return Template{}, err
+		}
+		return fromPromptTemplate(stored)
+	}
+	versions := r.templates[name]
+	if len(versions) == 0 || version > len(versions) || version < 0 {
+		return Template{}, db.ErrTemplateNotFound
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	return versions[version-1], nil
+}
+
+// Versions returns all versions of a template, the oldest first.
+func (r *Registry) Versions(name string) ([]Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.store == nil {
+		return append([]Template{}, r.templates[name]...), nil
+	}
+	stored, err := r.store.GetTemplateVersions(name)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Template, 0, len(stored))
+	for _, s := range stored {
+		t, err := fromPromptTemplate(s)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, t)
+	}
+	return versions, nil
+}
+
+// Render executes a template version (0 for the latest) with the given
+// variables.
+func (r *Registry) Render(name string, version int, values map[string]interface{}) (Rendered, error) {
+	t, err := r.Get(name, version)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("template %q: %w", name, err)
+	}
+
+	root := template.New(t.Name).Option("missingkey=error")
+	declared := append([]Variable{}, t.Variables...)
+	partials := map[string]int{}
+	if err := r.addPartials(root, t, partials, &declared, map[string]bool{t.Name: true}); err != nil {
+		return Rendered{}, err
+	}
+	if _, err := root.Parse(t.Body); err != nil {
+		return Rendered{}, fmt.Errorf("unable to parse template %q: %v", t.Name, err)
+	}
+
+	resolved, err := resolveVariables(declared, values)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("template %q: %v", t.Name, err)
+	}
+	var text strings.Builder
+	if err := root.Execute(&text, resolved); err != nil {
+		return Rendered{}, err
+	}
+	return Rendered{
+		Text:            text.String(),
+		TemplateName:    t.Name,
+		TemplateVersion: t.Version,
+		Variables:       resolved,
+		Partials:        partials,
+	}, nil
+}
+
+// addPartials parses the partials of t (and their partials) into root. The
+// variables they declare are added to declared unless t already has them.
+func (r *Registry) addPartials(root *template.Template, t Template, used map[string]int, declared *[]Variable, visiting map[string]bool) error {
+	for _, ref := range t.Partials {
+		name, version, err := parsePartialRef(ref)
+		if err != nil {
+			return err
+		}
+		if visiting[name] {
+			return fmt.Errorf("template %q includes itself through %q", t.Name, name)
+		}
+		if _, ok := used[name]; ok {
+			continue
+		}
+		partial, err := r.Get(name, version)
+		if err != nil {
+			return fmt.Errorf("partial %q of template %q: %v", ref, t.Name, err)
+		}
+		if _, err := root.New(name).Parse(partial.Body); err != nil {
+			return fmt.Errorf("unable to parse partial %q: %v", name, err)
+		}
+		used[name] = partial.Version
+		for _, v := range partial.Variables {
+			if !hasVariable(*declared, v.Name) {
+				*declared = append(*declared, v)
+			}
+		}
+		visiting[name] = true
+		if err := r.addPartials(root, partial, used, declared, visiting); err != nil {
+			return err
+		}
+		delete(visiting, name)
+	}
+	return nil
+}
+
+func hasVariable(variables []Variable, name string) bool {
+	for _, v := range variables {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sameDefinition(a Template, b Template) bool {
+	aJson, errA := json.Marshal([]interface{}{a.Body, a.Variables, a.Partials})
+	bJson, errB := json.Marshal([]interface{}{b.Body, b.Variables, b.Partials})
+	return errA == nil && errB == nil && string(aJson) == string(bJson)
+}
+
+func toPromptTemplate(t Template) (db.PromptTemplate, error) {
+	variables, err := json.Marshal(t.Variables)
+	if err != nil {
+		return db.PromptTemplate{}, err
+	}
+	partials, err := json.Marshal(t.Partials)
+	if err != nil {
+		return db.PromptTemplate{}, err
+	}
+	return db.PromptTemplate{
+		Name:      t.Name,
+		Body:      t.Body,
+		Variables: string(variables),
+		Partials:  string(partials),
+	}, nil
+}
+
+func fromPromptTemplate(stored db.PromptTemplate) (Template, error) {
+	t := Template{
+		Name:    stored.Name,
+		Version: stored.Version,
+		Body:    stored.Body,
+	}
+	if err := json.Unmarshal([]byte(stored.Variables), &t.Variables); err != nil {
+		return Template{}, fmt.Errorf("template %q has invalid variables: %v", stored.Name, err)
+	}
+	if err := json.Unmarshal([]byte(stored.Partials), &t.Partials); err != nil {
+		return Template{}, fmt.Errorf("template %q has invalid partials: %v", stored.Name, err)
+	}
+	return t, nil
+}
+
+// Usage describes the rendered text as a db.TemplateUsage of the given
+// message (empty for a system prompt) in the given context.
+func (r Rendered) Usage(messageId string, contextId string) (db.TemplateUsage, error) {
+	variables, err := json.Marshal(r.Variables)
+	if err != nil {
+		return db.TemplateUsage{}, err
+	}
+	partials, err := json.Marshal(r.Partials)
+	if err != nil {
+		return db.TemplateUsage{}, err
+	}
+	return db.TemplateUsage{
+		MessageID:       messageId,
+		ContextId:       contextId,
+		TemplateName:    r.TemplateName,
+		TemplateVersion: r.TemplateVersion,
+		Variables:       string(variables),
+		Partials:        string(partials),
+	}, nil
+}
@@ -0,0 +1,167 @@
+package prompt
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type VariableType string
+
+const (
+	StringVariable VariableType = "string"
+	IntVariable    VariableType = "int"
+	FloatVariable  VariableType = "float"
+	BoolVariable   VariableType = "bool"
+	ListVariable   VariableType = "list"
+)
+
+// Variable declares a value the template expects. Values passed to Render are
+// checked against Type, a missing optional variable gets Default (or the zero
+// value of its type).
+type Variable struct {
+	Name        string       `json:"name"`
+	Type        VariableType `json:"type"`
+	Required    bool         `json:"required"`
+	Default     interface{}  `json:"default,omitempty"`
+	Description string       `json:"description,omitempty"`
+}
+
+// Template is a text/template prompt (a system prompt or a user message).
+// Variables are available as {{.name}}. Partials lists other registered
+// templates the body includes with {{template "name" .}}, "name@2" pins a
+// version, otherwise the latest version is used.
+type Template struct {
+	Name      string     `json:"name"`
+	Version   int        `json:"version"`
+	Body      string     `json:"body"`
+	Variables []Variable `json:"variables"`
+	Partials  []string   `json:"partials"`
+}
+
+// Rendered is the text produced by a template together with what is needed
+// to reproduce it.
+type Rendered struct {
+	Text            string
+	TemplateName    string
+	TemplateVersion int
+	Variables       map[string]interface{}
+	// Partials maps each included template to the version that was used.
+	Partials map[string]int
+}
+
+func parsePartialRef(ref string) (string, int, error) {
+	name, version, found := strings.Cut(ref, "@")
+	if !found {
+		return ref, 0, nil
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil || v <= 0 {
+		return "", 0, fmt.Errorf("invalid partial reference %q", ref)
+	}
+	return name, v, nil
+}
+
+// resolveVariables checks values against the declared variables and fills in
+// defaults. Values that are not declared are rejected, a typo in a variable
+// name should not silently produce a different prompt.
+func resolveVariables(declared []Variable, values map[string]interface{}) (map[string]interface{}, error) {
+	known := make(map[string]Variable, len(declared))
+	for _, v := range declared {
+		known[v.Name] = v
+	}
+	unknown := make([]string, 0)
+	for name := range values {
+		if _, ok := known[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown variables: %s", strings.Join(unknown, ", "))
+	}
+
+	resolved := make(map[string]interface{}, len(declared))
+	for _, v := range declared {
+		value, ok := values[v.Name]
+		if !ok {
+			if v.Required {
+				return nil, fmt.Errorf("variable %q is required", v.Name)
+			}
+			value = v.Default
+		}
+		converted, err := convertValue(v, value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[v.Name] = converted
+	}
+	return resolved, nil
+}
+
+func convertValue(v Variable, value interface{}) (interface{}, error) {
+	if value == nil {
+		return zeroValue(v.Type)
+	}
+	rv := reflect.ValueOf(value)
+	switch v.Type {
+	case StringVariable:
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+	case IntVariable:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(rv.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			// Numbers decoded from JSON are floats.
+			if f := rv.Float(); f == float64(int64(f)) {
+				return int64(f), nil
+			}
+		}
+	case FloatVariable:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(rv.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		}
+	case BoolVariable:
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case ListVariable:
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			list := make([]interface{}, rv.Len())
+			for i := range list {
+				list[i] = rv.Index(i).Interface()
+			}
+			return list, nil
+		}
+	default:
+		return nil, fmt.Errorf("variable %q has unknown type %q", v.Name, v.Type)
+	}
+	return nil, fmt.Errorf("variable %q must be %s, got %T", v.Name, v.Type, value)
+}
+
+func zeroValue(t VariableType) (interface{}, error) {
+	switch t {
+	case StringVariable:
+		return "", nil
+	case IntVariable:
+		return int64(0), nil
+	case FloatVariable:
+		return float64(0), nil
+	case BoolVariable:
+		return false, nil
+	case ListVariable:
+		return []interface{}{}, nil
+	}
+	return nil, fmt.Errorf("unknown variable type %q", t)
+}
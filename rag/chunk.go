@@ -0,0 +1,153 @@
+package rag
+
+import (
+	"regexp"
+	"strings"
+)
+
+const defaultChunkSize = 1000
+
+// Chunker splits a document into the pieces that are embedded and retrieved.
+// Sizes are counted in characters.
+type Chunker interface {
+	Chunk(text string) []string
+}
+
+// FixedSizeChunker cuts the text every Size characters, neighbouring chunks
+// share Overlap characters.
+type FixedSizeChunker struct {
+	// Size is 1000 when zero.
+	Size    int
+	Overlap int
+}
+
+func (c *FixedSizeChunker) Chunk(text string) []string {
+	size := c.Size
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	step := size - c.Overlap
+	if step <= 0 {
+		step = size
+	}
+	runes := []rune(text)
+	chunks := make([]string, 0, len(runes)/step+1)
+	for start := 0; start < len(runes); start += step {
+		end := start + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		if chunk := strings.TrimSpace(string(runes[start:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(runes) {
+			break
+		}
+	}
+	return chunks
+}
+
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+// ParagraphChunker keeps paragraphs together and joins short ones until a
+// chunk would exceed MaxSize. Longer paragraphs are cut to MaxSize.
+type ParagraphChunker struct {
+	// MaxSize is 1000 when zero.
+	MaxSize int
+}
+
+func (c *ParagraphChunker) Chunk(text string) []string {
+	maxSize := c.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultChunkSize
+	}
+	chunks := make([]string, 0)
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+	for _, paragraph := range paragraphBreak.Split(text, -1) {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		length := len([]rune(paragraph))
+		if length > maxSize {
+			flush()
+			chunks = append(chunks, (&FixedSizeChunker{Size: maxSize}).Chunk(paragraph)...)
+			continue
+		}
+		if current.Len() > 0 && len([]rune(current.String()))+2+length > maxSize {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+	}
+	flush()
+	return chunks
+}
+
+var markdownHeading = regexp.MustCompile(`^#{1,6}\s`)
+
+// MarkdownChunker starts a chunk at every heading. Sections longer than
+// MaxSize are split by paragraph and every part starts with the heading of
+// its section.
+type MarkdownChunker struct {
+	// MaxSize is 1000 when zero.
+	MaxSize int
+}
+
+func (c *MarkdownChunker) Chunk(text string) []string {
+	maxSize := c.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultChunkSize
+	}
+	chunks := make([]string, 0)
+	heading := ""
+	var body strings.Builder
+	flush := func() {
+		content := strings.TrimSpace(body.String())
+		body.Reset()
+		if content == "" {
+			if heading != "" {
+				chunks = append(chunks, heading)
+			}
+			return
+		}
+		if heading == "" {
+			chunks = append(chunks, (&ParagraphChunker{MaxSize: maxSize}).Chunk(content)...)
+			return
+		}
+		if len([]rune(heading))+1+len([]rune(content)) <= maxSize {
+			chunks = append(chunks, heading+"\n"+content)
+			return
+		}
+		size := maxSize - len([]rune(heading)) - 1
+		if size <= 0 {
+			size = maxSize
+		}
+		for _, part := range (&ParagraphChunker{MaxSize: size}).Chunk(content) {
+			chunks = append(chunks, heading+"\n"+part)
+		}
+	}
+	inFence := false
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if !inFence && markdownHeading.MatchString(line) {
+			flush()
+			heading = strings.TrimSpace(line)
+			continue
+		}
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	flush()
+	return chunks
+}
@@ -0,0 +1,320 @@
+// Package rag answers with the help of document collections. Documents are
+// split into chunks, embedded and stored in a db.VectorStore; collections are
+// attached to contexts and the chunks closest to each user message are given
+// to the model as system context.
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/embedding"
+	"github.com/sirupsen/logrus"
+)
+
+// Metadata keys of the stored chunks.
+const (
+	MetadataDocument = "document"
+	MetadataChunk    = "chunk"
+)
+
+const DefaultTopK = 4
+
+// Store keeps the chunks and which collections are attached to which
+// context, db.SQLStore implements it.
+type Store interface {
+	db.VectorStore
+	db.ContextCollectionStore
+}
+
+// Pipeline ingests documents into collections and retrieves their chunks.
+type Pipeline struct {
+	Embedder embedding.Embedder
+	Store    Store
+	// Chunker is a ParagraphChunker when nil.
+	Chunker Chunker
+	// TopK is the number of chunks retrieved per message, DefaultTopK when
+	// zero.
+	TopK int
+	// MinScore drops chunks less similar to the message than this.
+	MinScore float64
+	Logger   *logrus.Logger
+}
+
+func NewPipeline(embedder embedding.Embedder, store Store, logger *logrus.Logger) *Pipeline {
+	return &Pipeline{
+		Embedder: embedder,
+		Store:    store,
+		Logger:   logger,
+	}
+}
+
+// Ingest splits a document into chunks, embeds and stores them in collection
+// and returns the number of chunks. The chunks of an earlier version of the
+// document are replaced.
+func (p *Pipeline) Ingest(collection string, document embedding.Document) (int, error) {
+	if document.ID == "" {
+		return 0, errors.New("document id is required")
+	}
+	chunker := p.Chunker
+	if chunker == nil {
+		chunker = &ParagraphChunker{}
+	}
+	chunks := chunker.Chunk(document.Content)
+	documents := make([]embedding.Document, len(chunks))
+	for i, chunk := range chunks {
+		metadata := map[string]string{}
+		for key, value := range document.Metadata {
+			metadata[key] = value
+		}
+		metadata[MetadataDocument] = document.ID
+		metadata[MetadataChunk] = strconv.Itoa(i)
+		documents[i] = embedding.Document{
+			ID:       chunkId(document.ID, i),
+			Content:  chunk,
+			Metadata: metadata,
+		}
+	}
+	// The new chunks replace the old ones with the same ids and only then
+	// are the remaining old chunks removed, a failed embedding leaves the
+	// earlier version searchable.
+	if err := embedding.NewIndex(p.Embedder, p.Store, collection).Add(documents...); err != nil {
+		return 0, err
+	}
+	if err := p.removeChunksFrom(collection, document.ID, len(chunks)); err != nil {
+		return 0, fmt.Errorf("unable to remove old chunks of %s: %v", document.ID, err)
+	}
+	if p.Logger != nil {
+		p.Logger.WithFields(logrus.Fields{
+			"collection": collection,
+			"document":   document.ID,
+			"chunks":     len(chunks),
+		}).Debug("Ingested document")
+	}
+	return len(chunks), nil
+}
+
+func chunkId(documentId string, chunk int) string {
+	return fmt.Sprintf("%s#%d", documentId, chunk)
+}
+
+// removeChunksFrom deletes the chunks of a document from chunk first on.
+// Ingest numbers the chunks of a document without gaps, so the old chunks
+// end with the first missing id.
+func (p *Pipeline) removeChunksFrom(collection string, documentId string, first int) error {
+	stale := make([]string, 0)
+	for i := first; ; i++ {
+		id := chunkId(documentId, i)
+		if _, err := p.Store.GetVector(collection, id); err != nil {
+			if errors.Is(err, db.ErrVectorNotFound) {
+				break
+			}
+			return err
+		}
+		stale = append(stale, id)
+	}
+	return p.Store.DeleteVectors(collection, stale...)
+}
+
+// RemoveDocument deletes every chunk of a document.
+func (p *Pipeline) RemoveDocument(collection string, documentId string) error {
+	return p.Store.DeleteVectorsByMetadata(collection, map[string]string{MetadataDocument: documentId})
+}
+
+// Attach makes the chunks of collections available to the messages of a
+// context.
+func (p *Pipeline) Attach(contextId string, collections ...string) error {
+	for _, collection := range collections {
+		if err := p.Store.AttachCollection(contextId, collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) Detach(contextId string, collection string) error {
+	return p.Store.DetachCollection(contextId, collection)
+}
+
+// Retrieve returns the chunks of the collections attached to the context and
+// of extra collections that are closest to query, best first.
+func (p *Pipeline) Retrieve(contextId string, query string, extra ...string) ([]Chunk, error) {
+	attached, err := p.Store.GetContextCollections(contextId)
+	if err != nil {
+		return nil, err
+	}
+	collections := make([]string, 0, len(attached)+len(extra))
+	seen := map[string]bool{}
+	for _, collection := range append(attached, extra...) {
+		if !seen[collection] {
+			seen[collection] = true
+			collections = append(collections, collection)
+		}
+	}
+	if len(collections) == 0 || strings.TrimSpace(query) == "" {
+		return []Chunk{}, nil
+	}
+	queryEmbedding, err := embedding.EmbedQuery(p.Embedder, query)
+	if err != nil {
+		return nil, err
+	}
+	topK := p.TopK
+	if topK <= 0 {
+		topK = DefaultTopK
+	}
+	chunks := make([]Chunk, 0)
+	for _, collection := range collections {
+		matches, err := p.Store.SearchVectors(collection, queryEmbedding, topK, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to search %s: %v", collection, err)
+		}
+		for _, match := range matches {
+			if match.Score < p.MinScore {
+				continue
+			}
+			chunks = append(chunks, Chunk{Collection: collection, VectorMatch: match})
+		}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Score > chunks[j].Score
+	})
+	if len(chunks) > topK {
+		chunks = chunks[:topK]
+	}
+	return chunks, nil
+}
+
+// Chunk is a retrieved part of a document.
+type Chunk struct {
+	Collection string
+	db.VectorMatch
+}
+
+func (c Chunk) Citation() db.Citation {
+	return db.Citation{
+		Collection: c.Collection,
+		ID:         c.ID,
+		Document:   c.Metadata[MetadataDocument],
+		Score:      c.Score,
+	}
+}
+
+// Client is an LllmChatClient that adds the chunks retrieved for the last
+// user message to the context of the request and lists them as citations in
+// the metadata of the answer, which client.Client stores with it.
+type Client struct {
+	Client   client.LllmChatClient
+	Pipeline *Pipeline
+	// Collections are searched for every context in addition to the
+	// attached ones.
+	Collections []string
+	Logger      *logrus.Logger
+}
+
+func NewClient(llmClient client.LllmChatClient, pipeline *Pipeline, logger *logrus.Logger) *Client {
+	return &Client{
+		Client:   llmClient,
+		Pipeline: pipeline,
+		Logger:   logger,
+	}
+}
+
+func (c *Client) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return c.send(messages, context, c.Client.SendMessages)
+}
+
+func (c *Client) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	stream := func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.StreamMessages(c.Client, messages, context, onChunk)
+	}
+	return c.send(messages, context, stream)
+}
+
+// SendMessagesWithOptions passes the options to the model with the retrieved
+// chunks.
+func (c *Client) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithOptions(c.Client, messages, context, options, c.Logger)
+	})
+}
+
+func (c *Client) StreamMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions, onChunk func(chunk string) error) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.StreamMessagesWithOptions(c.Client, messages, context, options, c.Logger, onChunk)
+	})
+}
+
+// SendMessagesWithFormat passes the response format to the model with the
+// retrieved chunks.
+func (c *Client) SendMessagesWithFormat(messages []db.Message, context []string, format *client.ResponseFormat) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithFormat(c.Client, messages, context, format)
+	})
+}
+
+// SendMessagesWithTools passes the tools to the model. Chunks are retrieved
+// for user messages only, not for the tool results of the same turn.
+func (c *Client) SendMessagesWithTools(messages []db.Message, context []string, tools []client.Tool) ([]db.Message, error) {
+	return c.send(messages, context, func(messages []db.Message, context []string) ([]db.Message, error) {
+		return client.SendMessagesWithTools(c.Client, messages, context, tools)
+	})
+}
+
+// Unwrap returns the provider behind the retrieval.
+func (c *Client) Unwrap() client.LllmChatClient {
+	return c.Client
+}
+
+func (c *Client) send(messages []db.Message, context []string, send func([]db.Message, []string) ([]db.Message, error)) ([]db.Message, error) {
+	if len(messages) == 0 {
+		return send(messages, context)
+	}
+	last := messages[len(messages)-1]
+	if last.Role != db.UserRoleName {
+		return send(messages, context)
+	}
+	chunks, err := c.Pipeline.Retrieve(last.ContextId, last.Content, c.Collections...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve documents: %v", err)
+	}
+	if c.Logger != nil {
+		c.Logger.WithFields(logrus.Fields{
+			"contextId": last.ContextId,
+			"chunks":    len(chunks),
+		}).Debug("Retrieved document chunks")
+	}
+	if len(chunks) == 0 {
+		return send(messages, context)
+	}
+	context = append(context[:len(context):len(context)], FormatChunks(chunks))
+	answers, err := send(messages, context)
+	if err != nil || len(answers) == 0 {
+		return answers, err
+	}
+	citations := make([]db.Citation, len(chunks))
+	for i, chunk := range chunks {
+		citations[i] = chunk.Citation()
+	}
+	answers[len(answers)-1].Metadata.Citations = citations
+	return answers, nil
+}
+
+// FormatChunks writes the chunks as numbered excerpts with their source for
+// the system context.
+func FormatChunks(chunks []Chunk) string {
+	var b strings.Builder
+	b.WriteString("Use the following excerpts from documents where they help to answer. They are numbered, cite them as [n].")
+	for i, chunk := range chunks {
+		source := chunk.Metadata[MetadataDocument]
+		if source == "" {
+			source = chunk.ID
+		}
+		fmt.Fprintf(&b, "\n\n[%d] (source: %s)\n%s", i+1, source, chunk.Content)
+	}
+	return b.String()
+}
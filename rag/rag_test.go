@@ -0,0 +1,197 @@
+package rag
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/embedding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keywordEmbedder embeds a text by the keywords it contains.
+type keywordEmbedder struct {
+	keywords []string
+}
+
+func (k *keywordEmbedder) Embed(inputs []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		embeddings[i] = make([]float32, len(k.keywords))
+		for j, keyword := range k.keywords {
+			embeddings[i][j] = float32(strings.Count(strings.ToLower(input), keyword))
+		}
+	}
+	return embeddings, nil
+}
+
+// contextRecorder answers "ok" and records the context of the last request.
+type contextRecorder struct {
+	context []string
+}
+
+func (r *contextRecorder) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	r.context = context
+	return append(messages, db.CreateNewMessage(db.AssistentRoleNeam, "ok", messages[0].ContextId)), nil
+}
+
+func newTestStore(t *testing.T) *db.SQLStore {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestFixedSizeChunker(t *testing.T) {
+	chunker := &FixedSizeChunker{Size: 4, Overlap: 1}
+	assert.Equal(t, []string{"abcd", "defg", "ghij"}, chunker.Chunk("abcdefghij"))
+	assert.Equal(t, []string{"äöüß"}, (&FixedSizeChunker{Size: 4}).Chunk("äöüß"))
+	assert.Empty(t, chunker.Chunk("   "))
+}
+
+func TestParagraphChunkerJoinsShortParagraphs(t *testing.T) {
+	text := "one two\n\nthree\n\n\n  \nfour five six seven eight nine ten"
+	chunks := (&ParagraphChunker{MaxSize: 16}).Chunk(text)
+	assert.Equal(t, []string{"one two\n\nthree", "four five six se", "ven eight nine t", "en"}, chunks)
+}
+
+func TestMarkdownChunkerSplitsAtHeadings(t *testing.T) {
+	text := "Intro text\n\n# Install\nRun go get.\n\n```\n# not a heading\n```\n## Usage\nFirst paragraph of usage.\n\nSecond paragraph of usage."
+	chunks := (&MarkdownChunker{MaxSize: 50}).Chunk(text)
+	assert.Equal(t, []string{
+		"Intro text",
+		"# Install\nRun go get.\n\n```\n# not a heading\n```",
+		"## Usage\nFirst paragraph of usage.",
+		"## Usage\nSecond paragraph of usage.",
+	}, chunks)
+}
+
+func TestIngestReplacesOldChunks(t *testing.T) {
+	store := newTestStore(t)
+	pipeline := NewPipeline(&keywordEmbedder{keywords: []string{"cat", "dog"}}, store, nil)
+
+	count, err := pipeline.Ingest("pets", embedding.Document{ID: "guide", Content: "cats\n\ndogs\n\nmore cats", Metadata: map[string]string{"lang": "en"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	pipeline.Chunker = &ParagraphChunker{MaxSize: 5}
+	count, err = pipeline.Ingest("pets", embedding.Document{ID: "guide", Content: "cats\n\ndogs"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = store.GetVector("pets", "guide#0")
+	require.NoError(t, err)
+	vector, err := store.GetVector("pets", "guide#1")
+	require.NoError(t, err)
+	assert.Equal(t, "dogs", vector.Content)
+	assert.Equal(t, map[string]string{MetadataDocument: "guide", MetadataChunk: "1"}, vector.Metadata)
+
+	require.NoError(t, pipeline.RemoveDocument("pets", "guide"))
+	_, err = store.GetVector("pets", "guide#0")
+	assert.ErrorIs(t, err, db.ErrVectorNotFound)
+}
+
+// failingEmbedder cannot reach its model.
+type failingEmbedder struct{}
+
+func (failingEmbedder) Embed(inputs []string) ([][]float32, error) {
+	return nil, errors.New("embeddings unavailable")
+}
+
+func TestIngestKeepsOldChunksUntilNewOnesAreStored(t *testing.T) {
+	store := newTestStore(t)
+	pipeline := NewPipeline(&keywordEmbedder{keywords: []string{"cat", "dog"}}, store, nil)
+	pipeline.Chunker = &ParagraphChunker{MaxSize: 5}
+	count, err := pipeline.Ingest("pets", embedding.Document{ID: "guide", Content: "cats\n\ndogs\n\ncats"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	pipeline.Embedder = failingEmbedder{}
+	_, err = pipeline.Ingest("pets", embedding.Document{ID: "guide", Content: "dogs"})
+	assert.Error(t, err)
+	vector, err := store.GetVector("pets", "guide#2")
+	require.NoError(t, err, "the earlier version is still searchable")
+	assert.Equal(t, "cats", vector.Content)
+
+	pipeline.Embedder = &keywordEmbedder{keywords: []string{"cat", "dog"}}
+	count, err = pipeline.Ingest("pets", embedding.Document{ID: "guide", Content: "dogs"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	vector, err = store.GetVector("pets", "guide#0")
+	require.NoError(t, err)
+	assert.Equal(t, "dogs", vector.Content)
+	for _, id := range []string{"guide#1", "guide#2"} {
+		_, err = store.GetVector("pets", id)
+		assert.ErrorIs(t, err, db.ErrVectorNotFound, id)
+	}
+}
+
+// optionsRecorder records the options and the context of the last request.
+type optionsRecorder struct {
+	contextRecorder
+	options *client.RequestOptions
+}
+
+func (r *optionsRecorder) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	r.options = options
+	return r.SendMessages(messages, context)
+}
+
+func TestClientPassesOptionsOn(t *testing.T) {
+	store := newTestStore(t)
+	pipeline := NewPipeline(&keywordEmbedder{keywords: []string{"cat"}}, store, nil)
+	_, err := pipeline.Ingest("pets", embedding.Document{ID: "cats.md", Content: "Cats sleep all day."})
+	require.NoError(t, err)
+	require.NoError(t, pipeline.Attach("ctx", "pets"))
+
+	llm := &optionsRecorder{}
+	ragClient := NewClient(llm, pipeline, nil)
+	assert.Same(t, llm, ragClient.Unwrap())
+	options := &client.RequestOptions{MaxTokens: 10}
+	answers, err := ragClient.SendMessagesWithOptions([]db.Message{db.CreateNewMessage(db.UserRoleName, "cat?", "ctx")}, nil, options)
+	require.NoError(t, err)
+	assert.Same(t, options, llm.options)
+	assert.Len(t, llm.context, 1, "the chunks are retrieved as for SendMessages")
+	assert.Len(t, answers[len(answers)-1].Metadata.Citations, 1)
+}
+
+func TestClientInjectsAttachedChunksAndStoresCitations(t *testing.T) {
+	store := newTestStore(t)
+	pipeline := NewPipeline(&keywordEmbedder{keywords: []string{"cat", "dog", "car"}}, store, nil)
+	pipeline.TopK = 2
+	pipeline.MinScore = 0.1
+	_, err := pipeline.Ingest("pets", embedding.Document{ID: "cats.md", Content: "Cats sleep all day."})
+	require.NoError(t, err)
+	_, err = pipeline.Ingest("pets", embedding.Document{ID: "dogs.md", Content: "Dogs like walks."})
+	require.NoError(t, err)
+	_, err = pipeline.Ingest("garage", embedding.Document{ID: "car.md", Content: "The car and the cat are red."})
+	require.NoError(t, err)
+	require.NoError(t, pipeline.Attach("ctx", "pets", "garage"))
+
+	llm := &contextRecorder{}
+	c := &client.Client{Client: NewClient(llm, pipeline, nil), ContextDepth: 10, Store: store}
+	_, err = c.SendMessage("When does my cat sleep?", "ctx")
+	require.NoError(t, err)
+
+	require.Len(t, llm.context, 1)
+	assert.Equal(t, "Use the following excerpts from documents where they help to answer. They are numbered, cite them as [n].\n\n"+
+		"[1] (source: cats.md)\nCats sleep all day.\n\n[2] (source: car.md)\nThe car and the cat are red.", llm.context[0])
+
+	messages, err := store.GetMessagesByContextID("ctx")
+	require.NoError(t, err)
+	answer := messages[len(messages)-1]
+	require.Len(t, answer.Metadata.Citations, 2)
+	assert.Equal(t, db.Citation{Collection: "pets", ID: "cats.md#0", Document: "cats.md", Score: 1}, answer.Metadata.Citations[0])
+	assert.Equal(t, "garage", answer.Metadata.Citations[1].Collection)
+
+	// A detached collection is not searched, a message without matches gets
+	// no excerpts.
+	require.NoError(t, pipeline.Detach("ctx", "pets"))
+	_, err = c.SendMessage("What about dogs?", "ctx")
+	require.NoError(t, err)
+	assert.Empty(t, llm.context)
+}
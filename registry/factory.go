@@ -0,0 +1,242 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/anthropic"
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/gcpauth"
+	"github.com/assistant-ai/llmchat-client/gemini"
+	"github.com/assistant-ai/llmchat-client/gpt"
+	"github.com/assistant-ai/llmchat-client/llamacpp"
+	"github.com/assistant-ai/llmchat-client/ollama"
+	"github.com/assistant-ai/llmchat-client/palm"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// Options carry what a provider needs besides the model. Zero fields fall
+// back to the environment and the provider defaults.
+type Options struct {
+	// APIKey for OpenAI, Anthropic and Google AI Studio, read from
+	// OPENAI_API_KEY, ANTHROPIC_API_KEY or GOOGLE_API_KEY when empty.
+	APIKey string
+	// ProjectId is the Google Cloud project for Vertex AI, read from
+	// GOOGLE_CLOUD_PROJECT when empty.
+	ProjectId string
+	Location  string
+	// TokenSource issues Vertex AI tokens. When nil the service account key
+	// in ServiceAccountFile is used, or Application Default Credentials.
+	TokenSource        oauth2.TokenSource
+	ServiceAccountFile string
+	// BaseURL overrides the endpoint of the providers, for OpenAI it is the
+	// whole chat completions URL as in gpt.GptClient.
+	BaseURL string
+	// ContextDepth and DefaultContext override the client defaults.
+	ContextDepth   int
+	DefaultContext string
+	Logger         *logrus.Logger
+}
+
+// Provider builds clients for its models.
+type Provider struct {
+	Name string
+	// AcceptsAnyModel is set for local servers, which run whatever model is
+	// installed.
+	AcceptsAnyModel bool
+	New             func(model Model, options Options) (*client.Client, error)
+}
+
+// NewClient looks up spec and builds a client for the model.
+func (r *Registry) NewClient(spec string, options Options) (*client.Client, error) {
+	model, err := r.Lookup(spec)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	provider, ok := r.providers[model.Provider]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no provider %q for model %s", model.Provider, model.Name)
+	}
+	c, err := provider.New(model, options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client for %s: %v", model.Spec(), err)
+	}
+	if options.ContextDepth > 0 {
+		c.ContextDepth = options.ContextDepth
+	}
+	if options.DefaultContext != "" {
+		c.DefaultContext = options.DefaultContext
+	}
+	return c, nil
+}
+
+// NewClient builds a client for spec with the Default registry.
+func NewClient(spec string, options Options) (*client.Client, error) {
+	return Default.NewClient(spec, options)
+}
+
+// NewFallbackClient builds a client that tries the models of specs in order,
+// see client.FallbackClient. The providers are named by their specs.
+func (r *Registry) NewFallbackClient(specs []string, options Options) (*client.Client, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("no models for the fallback client")
+	}
+	providers := make([]client.FallbackProvider, 0, len(specs))
+	var first *client.Client
+	for _, spec := range specs {
+		c, err := r.NewClient(spec, options)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = c
+		}
+		providers = append(providers, client.FallbackProvider{Name: spec, Client: c.Client})
+	}
+	fallback := client.NewFallbackClient(providers...)
+	fallback.Logger = options.Logger
+	first.Client = fallback
+	return first, nil
+}
+
+// NewFallbackClient builds a fallback client with the Default registry.
+func NewFallbackClient(specs []string, options Options) (*client.Client, error) {
+	return Default.NewFallbackClient(specs, options)
+}
+
+func builtinProviders() []Provider {
+	return []Provider{
+		{Name: gpt.ProviderName, New: newOpenAIClient},
+		{Name: palm.ProviderName, New: newVertexClient},
+		{Name: gemini.ProviderName, New: newGeminiClient},
+		{Name: anthropic.ProviderName, New: newAnthropicClient},
+		{Name: ollama.ProviderName, AcceptsAnyModel: true, New: newOllamaClient},
+		{Name: llamacpp.ProviderName, AcceptsAnyModel: true, New: newLlamaCppClient},
+	}
+}
+
+func apiKey(options Options, envVariable string) (string, error) {
+	if options.APIKey != "" {
+		return options.APIKey, nil
+	}
+	if key := os.Getenv(envVariable); key != "" {
+		return key, nil
+	}
+	return "", fmt.Errorf("no API key, set Options.APIKey or %s", envVariable)
+}
+
+func newOpenAIClient(model Model, options Options) (*client.Client, error) {
+	key, err := apiKey(options, "OPENAI_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	gptModel := &gpt.GPTModel{
+		Name:       model.Name,
+		MaxTokens:  model.ContextWindow,
+		JSONMode:   model.Capabilities.JSONMode,
+		JSONSchema: model.Capabilities.JSONSchema,
+	}
+	c := gpt.NewGptClient(key, 5, gptModel, "", model.MaxOutputTokens, options.Logger)
+	c.Client.(*gpt.GptClient).BaseURL = options.BaseURL
+	return c, nil
+}
+
+// newVertexClient serves Gemini models with the Gemini client and the older
+// models with the PaLM client.
+func newVertexClient(model Model, options Options) (*client.Client, error) {
+	projectId := options.ProjectId
+	if projectId == "" {
+		projectId = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if projectId == "" {
+		return nil, errors.New("no Google Cloud project, set Options.ProjectId or GOOGLE_CLOUD_PROJECT")
+	}
+	tokenSource, err := vertexTokenSource(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(model.Name, "gemini") {
+		c := gemini.NewVertexGeminiClientWithTokenSource(projectId, options.Location, tokenSource, &gemini.GeminiModel{
+			Name:            model.Name,
+			MaxInputTokens:  model.ContextWindow,
+			MaxOutputTokens: model.MaxOutputTokens,
+		}, options.Logger)
+		c.Client.(*gemini.GeminiClient).BaseURL = options.BaseURL
+		return c, nil
+	}
+	c := palm.NewPalmClientWithConfig(projectId, tokenSource, palm.Config{
+		Location: options.Location,
+		BaseURL:  options.BaseURL,
+		Model: &palm.PalmModel{
+			Name:            model.Name,
+			MaxInputTokens:  model.ContextWindow,
+			MaxOutputTokens: model.MaxOutputTokens,
+		},
+	})
+	c.Client.(*palm.PalmClient).Logger = options.Logger
+	c.Logger = options.Logger
+	return c, nil
+}
+
+func vertexTokenSource(options Options) (oauth2.TokenSource, error) {
+	if options.TokenSource != nil {
+		return options.TokenSource, nil
+	}
+	if options.ServiceAccountFile != "" {
+		return gcpauth.TokenSourceFromFile(options.ServiceAccountFile)
+	}
+	return gcpauth.DefaultTokenSource()
+}
+
+func newGeminiClient(model Model, options Options) (*client.Client, error) {
+	key, err := apiKey(options, "GOOGLE_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	c := gemini.NewGeminiClient(key, &gemini.GeminiModel{
+		Name:            model.Name,
+		MaxInputTokens:  model.ContextWindow,
+		MaxOutputTokens: model.MaxOutputTokens,
+	}, options.Logger)
+	c.Client.(*gemini.GeminiClient).BaseURL = options.BaseURL
+	return c, nil
+}
+
+func newAnthropicClient(model Model, options Options) (*client.Client, error) {
+	key, err := apiKey(options, "ANTHROPIC_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	claudeModel := &anthropic.ClaudeModel{
+		Name:            model.Name,
+		MaxTokens:       model.ContextWindow,
+		MaxOutputTokens: model.MaxOutputTokens,
+	}
+	c := anthropic.NewAnthropicClient(key, 8, claudeModel, "", model.MaxOutputTokens, options.Logger)
+	c.Client.(*anthropic.AnthropicClient).BaseURL = options.BaseURL
+	return c, nil
+}
+
+func newOllamaClient(model Model, options Options) (*client.Client, error) {
+	baseURL := options.BaseURL
+	if baseURL == "" {
+		baseURL = ollama.DefaultBaseURL
+	}
+	return ollama.NewOllamaClientWithURL(baseURL, model.Name, options.Logger), nil
+}
+
+// newLlamaCppClient takes the chat template from the model name, e.g.
+// "llamacpp:llama3", and uses ChatML for other names.
+func newLlamaCppClient(model Model, options Options) (*client.Client, error) {
+	baseURL := options.BaseURL
+	if baseURL == "" {
+		baseURL = llamacpp.DefaultBaseURL
+	}
+	return llamacpp.NewLlamaCppClient(baseURL, llamacpp.GetChatTemplates()[model.Name], options.Logger), nil
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"github.com/assistant-ai/llmchat-client/anthropic"
+	"github.com/assistant-ai/llmchat-client/gemini"
+	"github.com/assistant-ai/llmchat-client/gpt"
+	"github.com/assistant-ai/llmchat-client/palm"
+)
+
+var (
+	chatOnly    = Capabilities{}
+	tools       = Capabilities{Tools: true}
+	toolsJSON   = Capabilities{Tools: true, JSONMode: true}
+	vision      = Capabilities{Vision: true}
+	streaming   = Capabilities{Streaming: true}
+	allButTools = Capabilities{Vision: true, JSONMode: true, Streaming: true}
+)
+
+// builtinModels lists the models of the providers in this module. The limits
+// come from the model constants of the provider packages, models without a
+// constant are only known here. Prices are the list prices per million
+// tokens, PaLM bills characters and is converted at four characters a token.
+func builtinModels() []Model {
+	return []Model{
+		openAIModel(gpt.ModelGPT3Turbo, "gpt3Turbo", 4096, tools, Pricing{0.5, 1.5}),
+		openAIModel(gpt.ModelGPT3TurboBig, "gpt3TurboBig", 4096, tools, Pricing{3, 4}),
+		openAIModel(gpt.ModelGPT4, "gpt4", 4096, tools, Pricing{30, 60}),
+		openAIModel(gpt.ModelGPT4Big, "gpt4Big", 4096, tools, Pricing{60, 120}),
+		openAIModel(gpt.ModelGPT4Turbo, "gpt4Turbo", 4096, toolsJSON, Pricing{10, 30}),
+		openAIModel(gpt.ModelGPT4Vision, "gpt4Vision", 4096, vision, Pricing{10, 30}),
+		{
+			Provider:        gpt.ProviderName,
+			Name:            "gpt-4-turbo",
+			ContextWindow:   128000,
+			MaxOutputTokens: 4096,
+			Capabilities:    Capabilities{Vision: true, Tools: true, JSONMode: true},
+			Pricing:         Pricing{10, 30},
+		},
+		openAIModel(gpt.ModelGPT4o, "gpt4o", 4096, Capabilities{Vision: true, Tools: true, JSONMode: true, JSONSchema: true}, Pricing{5, 15}),
+
+		palmModel(palm.ModelChatBison, Pricing{2, 2}),
+		palmModel(palm.ModelChatBison001, Pricing{2, 2}),
+		palmModel(palm.ModelChatBison002, Pricing{2, 2}),
+		palmModel(palm.ModelChatBison32k, Pricing{2, 2}),
+		palmModel(palm.ModelCodechatBison, Pricing{2, 2}),
+		palmModel(palm.ModelCodechatBison32k, Pricing{2, 2}),
+
+		geminiModel(palm.ProviderName, gemini.ModelGemini10Pro, streaming, Pricing{0.5, 1.5}),
+		geminiModel(palm.ProviderName, gemini.ModelGemini15Pro, allButTools, Pricing{3.5, 10.5}),
+		geminiModel(palm.ProviderName, gemini.ModelGemini15Flash, allButTools, Pricing{0.35, 1.05}),
+		geminiModel(gemini.ProviderName, gemini.ModelGemini10Pro, streaming, Pricing{0.5, 1.5}),
+		geminiModel(gemini.ProviderName, gemini.ModelGemini15Pro, allButTools, Pricing{3.5, 10.5}),
+		geminiModel(gemini.ProviderName, gemini.ModelGemini15Flash, allButTools, Pricing{0.35, 1.05}),
+
+		claudeModel(anthropic.ModelClaude3Opus, "claude-3-opus", Pricing{15, 75}),
+		claudeModel(anthropic.ModelClaude3Sonnet, "claude-3-sonnet", Pricing{3, 15}),
+		claudeModel(anthropic.ModelClaude3Haiku, "claude-3-haiku", Pricing{0.25, 1.25}),
+		claudeModel(anthropic.ModelClaude35Sonnet, "claude-3-5-sonnet", Pricing{3, 15}),
+	}
+}
+
+// openAIModel keeps the keys of gpt.GetLlmClientGptModels as aliases.
+func openAIModel(model *gpt.GPTModel, key string, maxOutputTokens int, capabilities Capabilities, pricing Pricing) Model {
+	return Model{
+		Provider:        gpt.ProviderName,
+		Name:            model.Name,
+		Aliases:         []string{key},
+		ContextWindow:   model.MaxTokens,
+		MaxOutputTokens: maxOutputTokens,
+		Capabilities:    capabilities,
+		Pricing:         pricing,
+	}
+}
+
+func palmModel(model *palm.PalmModel, pricing Pricing) Model {
+	return Model{
+		Provider:        palm.ProviderName,
+		Name:            model.Name,
+		ContextWindow:   model.MaxInputTokens,
+		MaxOutputTokens: model.MaxOutputTokens,
+		Capabilities:    chatOnly,
+		Pricing:         pricing,
+	}
+}
+
+func geminiModel(provider string, model *gemini.GeminiModel, capabilities Capabilities, pricing Pricing) Model {
+	return Model{
+		Provider:        provider,
+		Name:            model.Name,
+		ContextWindow:   model.MaxInputTokens,
+		MaxOutputTokens: model.MaxOutputTokens,
+		Capabilities:    capabilities,
+		Pricing:         pricing,
+	}
+}
+
+func claudeModel(model *anthropic.ClaudeModel, alias string, pricing Pricing) Model {
+	return Model{
+		Provider:        anthropic.ProviderName,
+		Name:            model.Name,
+		Aliases:         []string{alias},
+		ContextWindow:   model.MaxTokens,
+		MaxOutputTokens: model.MaxOutputTokens,
+		// The anthropic package does not send tools or images yet.
+		Capabilities: streaming,
+		Pricing:      pricing,
+	}
+}
@@ -0,0 +1,199 @@
+// Package registry describes the models of all providers in one place and
+// builds a ready client.Client from a model spec such as "openai:gpt-4-turbo"
+// or "vertex:chat-bison".
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrModelNotFound = errors.New("model not found")
+
+type Capabilities struct {
+	Vision bool `json:"vision"`
+	Tools  bool `json:"tools"`
+	// JSONMode models can be asked for JSON, JSONSchema models also for JSON
+	// that matches a schema.
+	JSONMode   bool `json:"json_mode"`
+	JSONSchema bool `json:"json_schema"`
+	Streaming  bool `json:"streaming"`
+}
+
+// Pricing is in US dollars per million tokens, zero when unknown or free.
+type Pricing struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+type Model struct {
+	Provider string `json:"provider"`
+	// Name is the model name of the provider API.
+	Name string `json:"name"`
+	// Aliases are other names the model is found by, e.g. "gpt4Turbo".
+	Aliases         []string     `json:"aliases,omitempty"`
+	ContextWindow   int          `json:"context_window"`
+	MaxOutputTokens int          `json:"max_output_tokens"`
+	Capabilities    Capabilities `json:"capabilities"`
+	Pricing         Pricing      `json:"pricing"`
+}
+
+// Spec returns "provider:name".
+func (m Model) Spec() string {
+	return m.Provider + ":" + m.Name
+}
+
+// Cost returns the price in US dollars of a request with the given token
+// counts.
+func (m Model) Cost(promptTokens int, completionTokens int) float64 {
+	return (float64(promptTokens)*m.Pricing.InputPerMillion + float64(completionTokens)*m.Pricing.OutputPerMillion) / 1e6
+}
+
+func (m Model) hasName(name string) bool {
+	if m.Name == name {
+		return true
+	}
+	for _, alias := range m.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSpec splits "provider:name". The provider is empty when spec has no
+// prefix. Model names may contain colons themselves, e.g. "ollama:llama3:8b".
+func ParseSpec(spec string) (provider string, name string, err error) {
+	provider, name, found := strings.Cut(spec, ":")
+	if !found {
+		provider, name = "", spec
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("invalid model spec %q", spec)
+	}
+	return provider, name, nil
+}
+
+// Registry holds the known models and the providers that build clients for
+// them.
+type Registry struct {
+	mu        sync.RWMutex
+	models    []Model
+	providers map[string]Provider
+}
+
+// NewRegistry returns an empty registry, Default has all built-in models and
+// providers.
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Provider)}
+}
+
+// Register adds a model, replacing the model of the same provider and name.
+func (r *Registry) Register(model Model) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.models {
+		if existing.Provider == model.Provider && existing.Name == model.Name {
+			r.models[i] = model
+			return
+		}
+	}
+	r.models = append(r.models, model)
+}
+
+func (r *Registry) RegisterProvider(provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[provider.Name] = provider
+}
+
+// Lookup finds a model by spec. Without a provider prefix the name has to be
+// unique across providers. Providers with AcceptsAnyModel return a model with
+// just the name for models that are not registered.
+func (r *Registry) Lookup(spec string) (Model, error) {
+	providerName, name, err := ParseSpec(spec)
+	if err != nil {
+		return Model{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matches := make([]Model, 0, 1)
+	for _, model := range r.models {
+		if (providerName == "" || model.Provider == providerName) && model.hasName(name) {
+			matches = append(matches, model)
+		}
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		specs := make([]string, 0, len(matches))
+		for _, model := range matches {
+			specs = append(specs, model.Spec())
+		}
+		return Model{}, fmt.Errorf("model %q is ambiguous, use one of %s", spec, strings.Join(specs, ", "))
+	}
+	if provider, ok := r.providers[providerName]; ok && provider.AcceptsAnyModel {
+		return Model{
+			Provider:     providerName,
+			Name:         name,
+			Capabilities: Capabilities{Streaming: true},
+		}, nil
+	}
+	return Model{}, fmt.Errorf("%w: %s", ErrModelNotFound, spec)
+}
+
+// Models returns the registered models of a provider, or of all providers
+// when provider is empty, sorted by spec.
+func (r *Registry) Models(provider string) []Model {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	models := make([]Model, 0, len(r.models))
+	for _, model := range r.models {
+		if provider == "" || model.Provider == provider {
+			models = append(models, model)
+		}
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Spec() < models[j].Spec()
+	})
+	return models
+}
+
+// Providers returns the names of the registered providers, sorted.
+func (r *Registry) Providers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Default has the built-in models and providers.
+var Default = newDefaultRegistry()
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, model := range builtinModels() {
+		r.Register(model)
+	}
+	for _, provider := range builtinProviders() {
+		r.RegisterProvider(provider)
+	}
+	return r
+}
+
+func Lookup(spec string) (Model, error) {
+	return Default.Lookup(spec)
+}
+
+func Models(provider string) []Model {
+	return Default.Models(provider)
+}
@@ -0,0 +1,218 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/anthropic"
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/gemini"
+	"github.com/assistant-ai/llmchat-client/gpt"
+	"github.com/assistant-ai/llmchat-client/llamacpp"
+	"github.com/assistant-ai/llmchat-client/ollama"
+	"github.com/assistant-ai/llmchat-client/palm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestLookup(t *testing.T) {
+	model, err := Lookup("openai:gpt-4-turbo")
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4-turbo", model.Name)
+	assert.Equal(t, 128000, model.ContextWindow)
+	assert.True(t, model.Capabilities.Vision)
+	assert.True(t, model.Capabilities.JSONMode)
+
+	model, err = Lookup("vertex:chat-bison")
+	require.NoError(t, err)
+	assert.Equal(t, palm.ModelChatBison.MaxInputTokens, model.ContextWindow)
+	assert.False(t, model.Capabilities.Streaming)
+
+	// The keys of gpt.GetLlmClientGptModels keep working.
+	model, err = Lookup("gpt4Turbo")
+	require.NoError(t, err)
+	assert.Equal(t, "openai:"+gpt.ModelGPT4Turbo.Name, model.Spec())
+
+	model, err = Lookup("claude-3-haiku")
+	require.NoError(t, err)
+	assert.Equal(t, anthropic.ModelClaude3Haiku.Name, model.Name)
+	assert.InDelta(t, 0.00075, model.Cost(1000, 400), 1e-9)
+	assert.Equal(t, Capabilities{Streaming: true}, model.Capabilities, "the anthropic package sends neither tools nor images")
+}
+
+func TestLookupErrors(t *testing.T) {
+	_, err := Lookup("openai:gpt-17")
+	assert.True(t, errors.Is(err, ErrModelNotFound))
+
+	_, err = Lookup("gemini-1.5-pro")
+	assert.EqualError(t, err, `model "gemini-1.5-pro" is ambiguous, use one of vertex:gemini-1.5-pro, gemini:gemini-1.5-pro`)
+
+	_, err = Lookup("openai:")
+	assert.EqualError(t, err, `invalid model spec "openai:"`)
+}
+
+func TestLocalProvidersAcceptAnyModel(t *testing.T) {
+	model, err := Lookup("ollama:llama3:8b")
+	require.NoError(t, err)
+	assert.Equal(t, Model{Provider: "ollama", Name: "llama3:8b", Capabilities: Capabilities{Streaming: true}}, model)
+
+	c, err := NewClient("ollama:llama3:8b", Options{BaseURL: "http://gpu-box:11434"})
+	require.NoError(t, err)
+	ollamaClient := c.Client.(*ollama.OllamaClient)
+	assert.Equal(t, "llama3:8b", ollamaClient.Model)
+	assert.Equal(t, "http://gpu-box:11434", ollamaClient.BaseURL)
+
+	c, err = NewClient("llamacpp:llama3", Options{})
+	require.NoError(t, err)
+	assert.Equal(t, llamacpp.TemplateLlama3, c.Client.(*llamacpp.LlamaCppClient).Template)
+}
+
+func TestModels(t *testing.T) {
+	models := Models(anthropic.ProviderName)
+	require.Len(t, models, len(anthropic.GetClaudeModels()))
+	for _, model := range models {
+		assert.Equal(t, anthropic.ProviderName, model.Provider)
+	}
+	assert.Len(t, Models(""), len(Default.models))
+	assert.Equal(t, []string{"anthropic", "gemini", "llamacpp", "ollama", "openai", "vertex"}, Default.Providers())
+}
+
+func TestNewClient(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "openai-key")
+	c, err := NewClient("openai:gpt4", Options{ContextDepth: 3, DefaultContext: "be brief"})
+	require.NoError(t, err)
+	gptClient := c.Client.(*gpt.GptClient)
+	assert.Equal(t, "openai-key", gptClient.OpenAiKey)
+	assert.Equal(t, gpt.ModelGPT4.Name, gptClient.Model.Name)
+	assert.Equal(t, 4096, gptClient.MaxTokens)
+	assert.Equal(t, 3, c.ContextDepth)
+	assert.Equal(t, "be brief", c.DefaultContext)
+
+	c, err = NewClient("openai:gpt-4o", Options{BaseURL: "http://localhost:8080/v1/chat/completions"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/v1/chat/completions", c.Client.(*gpt.GptClient).BaseURL)
+
+	c, err = NewClient("anthropic:claude-3-5-sonnet", Options{APIKey: "anthropic-key"})
+	require.NoError(t, err)
+	claudeClient := c.Client.(*anthropic.AnthropicClient)
+	assert.Equal(t, "anthropic-key", claudeClient.ApiKey)
+	assert.Equal(t, anthropic.ModelClaude35Sonnet, claudeClient.Model)
+
+	c, err = NewClient("gemini:gemini-1.5-flash", Options{APIKey: "google-key"})
+	require.NoError(t, err)
+	assert.Equal(t, "google-key", c.Client.(*gemini.GeminiClient).ApiKey)
+
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	c, err = NewClient("vertex:gemini-1.5-pro", Options{ProjectId: "project", Location: "europe-west4", TokenSource: tokenSource})
+	require.NoError(t, err)
+	vertexGemini := c.Client.(*gemini.GeminiClient)
+	assert.Equal(t, "project", vertexGemini.ProjectId)
+	assert.Equal(t, "europe-west4", vertexGemini.Location)
+	assert.Equal(t, gemini.ModelGemini15Pro, vertexGemini.Model)
+
+	c, err = NewClient("vertex:chat-bison-32k", Options{ProjectId: "project", TokenSource: tokenSource})
+	require.NoError(t, err)
+	palmClient := c.Client.(*palm.PalmClient)
+	assert.Equal(t, palm.ModelChatBison32k, palmClient.Model)
+}
+
+// A model with Tools must get a client that calls tools natively, otherwise
+// agents fall back to ReAct and ModeNative fails.
+func TestToolsCapabilityMatchesTheClients(t *testing.T) {
+	options := Options{
+		APIKey:      "key",
+		ProjectId:   "project",
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+	}
+	for _, model := range Models("") {
+		if !model.Capabilities.Tools {
+			continue
+		}
+		c, err := NewClient(model.Spec(), options)
+		require.NoError(t, err, model.Spec())
+		assert.True(t, client.SupportsTools(c.Client), model.Spec())
+	}
+}
+
+func TestGptCatalogMatchesTheRegistry(t *testing.T) {
+	for key, gptModel := range gpt.GetLlmClientGptModels() {
+		model, err := Lookup(key)
+		require.NoError(t, err, key)
+		assert.Equal(t, gptModel.Name, model.Name, key)
+		assert.Equal(t, gptModel.JSONMode, model.Capabilities.JSONMode, key)
+		assert.Equal(t, gptModel.JSONSchema, model.Capabilities.JSONSchema, key)
+	}
+}
+
+func TestNewClientNeedsCredentials(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	_, err := NewClient("anthropic:claude-3-opus", Options{})
+	assert.EqualError(t, err, "unable to create client for anthropic:claude-3-opus-20240229: no API key, set Options.APIKey or ANTHROPIC_API_KEY")
+
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	_, err = NewClient("vertex:chat-bison", Options{})
+	assert.EqualError(t, err, "unable to create client for vertex:chat-bison: no Google Cloud project, set Options.ProjectId or GOOGLE_CLOUD_PROJECT")
+}
+
+func TestNewClientSendsToProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		w.Write([]byte(`{"model": "mistral", "message": {"role": "assistant", "content": "Bonjour"}, "done": true}`))
+	}))
+	defer server.Close()
+
+	c, err := NewClient("ollama:mistral", Options{BaseURL: server.URL})
+	require.NoError(t, err)
+	answers, err := c.Client.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hello", "ctx")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Bonjour", answers[1].Content)
+}
+
+func TestCustomRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Model{Provider: "openai", Name: "ft:gpt-3.5-turbo:acme", ContextWindow: 16000, MaxOutputTokens: 4096})
+	r.RegisterProvider(Provider{Name: "openai", New: newOpenAIClient})
+
+	c, err := r.NewClient("openai:ft:gpt-3.5-turbo:acme", Options{APIKey: "key"})
+	require.NoError(t, err)
+	assert.Equal(t, "ft:gpt-3.5-turbo:acme", c.Client.(*gpt.GptClient).Model.Name)
+
+	_, err = r.NewClient("openai:gpt-4o", Options{APIKey: "key"})
+	assert.True(t, errors.Is(err, ErrModelNotFound))
+}
+
+func TestNewFallbackClient(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error": "server busy"}`))
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"content": "Bonjour", "stop": true}`))
+	}))
+	defer up.Close()
+
+	r := NewRegistry()
+	r.RegisterProvider(Provider{Name: "ollama", AcceptsAnyModel: true, New: func(model Model, options Options) (*client.Client, error) {
+		return newOllamaClient(model, Options{BaseURL: down.URL})
+	}})
+	r.RegisterProvider(Provider{Name: "llamacpp", AcceptsAnyModel: true, New: func(model Model, options Options) (*client.Client, error) {
+		return newLlamaCppClient(model, Options{BaseURL: up.URL})
+	}})
+
+	c, err := r.NewFallbackClient([]string{"ollama:mistral", "llamacpp:chatml"}, Options{ContextDepth: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 2, c.ContextDepth)
+	answers, err := c.Client.SendMessages([]db.Message{db.CreateNewMessage(db.UserRoleName, "Hello", "ctx")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Bonjour", answers[1].Content)
+	assert.Equal(t, llamacpp.ProviderName, answers[1].Metadata.Provider)
+
+	health := c.Client.(*client.FallbackClient).Health()
+	assert.Equal(t, "ollama:mistral", health[0].Name)
+	assert.Equal(t, 1, health[0].ConsecutiveFailures)
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+)
+
+// chatCompletionRequest is the part of the chat completions request the
+// server understands.
+type chatCompletionRequest struct {
+	Model            string          `json:"model"`
+	Messages         []chatMessage   `json:"messages"`
+	Stream           bool            `json:"stream"`
+	Temperature      *float64        `json:"temperature"`
+	TopP             *float64        `json:"top_p"`
+	MaxTokens        int             `json:"max_tokens"`
+	MaxCompletion    int             `json:"max_completion_tokens"`
+	Stop             json.RawMessage `json:"stop"`
+	Seed             *int            `json:"seed"`
+	PresencePenalty  *float64        `json:"presence_penalty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty"`
+	LogitBias        map[string]int  `json:"logit_bias"`
+	User             string          `json:"user"`
+	N                int             `json:"n"`
+	Tools            json.RawMessage `json:"tools"`
+	ResponseFormat   *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type contentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL    string `json:"url"`
+		Detail string `json:"detail"`
+	} `json:"image_url"`
+}
+
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int              `json:"index"`
+	Message      *assistantOutput `json:"message,omitempty"`
+	Delta        *assistantOutput `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type assistantOutput struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type modelList struct {
+	Object string      `json:"object"`
+	Data   []modelInfo `json:"data"`
+}
+
+type modelInfo struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+// conversation converts the request messages, system and developer messages
+// become the context.
+func (r *chatCompletionRequest) conversation(contextId string) ([]db.Message, []string, error) {
+	messages := make([]db.Message, 0, len(r.Messages))
+	context := make([]string, 0)
+	for i, message := range r.Messages {
+		parts, err := decodeContent(message.Content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("messages[%d]: %v", i, err)
+		}
+		switch message.Role {
+		case db.SystemRoleName, "developer":
+			context = append(context, textOf(parts))
+		case db.UserRoleName, db.AssistentRoleNeam:
+			m := db.CreateNewMultipartMessage(message.Role, contextId, parts...)
+			if !m.HasImages() {
+				m.Parts = nil
+			}
+			messages = append(messages, m)
+		default:
+			return nil, nil, fmt.Errorf("messages[%d]: role %q is not supported", i, message.Role)
+		}
+	}
+	if len(messages) == 0 || messages[len(messages)-1].Role != db.UserRoleName {
+		return nil, nil, errors.New("the last message must be a user message")
+	}
+	return messages, context, nil
+}
+
+// decodeContent reads a string or an array of content parts.
+func decodeContent(content json.RawMessage) ([]db.ContentPart, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return []db.ContentPart{}, nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return []db.ContentPart{db.NewTextPart(text)}, nil
+	}
+	var parts []contentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return nil, errors.New("content must be a string or an array of parts")
+	}
+	converted := make([]db.ContentPart, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			converted = append(converted, db.NewTextPart(part.Text))
+		case "image_url":
+			image, err := imagePart(part.ImageURL.URL, part.ImageURL.Detail)
+			if err != nil {
+				return nil, err
+			}
+			converted = append(converted, image)
+		default:
+			return nil, fmt.Errorf("content parts of type %q are not supported", part.Type)
+		}
+	}
+	return converted, nil
+}
+
+// imagePart keeps data URLs as image data, so that providers which only take
+// inline images get them.
+func imagePart(url string, detail string) (db.ContentPart, error) {
+	if !strings.HasPrefix(url, "data:") {
+		return db.NewImageURLPart(url, detail), nil
+	}
+	header, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return db.ContentPart{}, errors.New("image data URLs must be base64 encoded")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return db.ContentPart{}, fmt.Errorf("invalid image data: %v", err)
+	}
+	return db.NewImageDataPart(decoded, detail)
+}
+
+func textOf(parts []db.ContentPart) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == db.ContentPartText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// options converts the sampling parameters of the request.
+func (r *chatCompletionRequest) options() (*client.RequestOptions, error) {
+	options := &client.RequestOptions{
+		Temperature:      r.Temperature,
+		TopP:             r.TopP,
+		MaxTokens:        r.MaxTokens,
+		Seed:             r.Seed,
+		PresencePenalty:  r.PresencePenalty,
+		FrequencyPenalty: r.FrequencyPenalty,
+		LogitBias:        r.LogitBias,
+		User:             r.User,
+		// Clients send their usual parameters to every model, those the
+		// provider does not know are dropped with a warning.
+		IgnoreUnsupported: true,
+	}
+	if r.MaxCompletion > 0 {
+		options.MaxTokens = r.MaxCompletion
+	}
+	if len(r.Stop) > 0 && string(r.Stop) != "null" {
+		var stop string
+		if err := json.Unmarshal(r.Stop, &stop); err == nil {
+			options.Stop = []string{stop}
+		} else if err := json.Unmarshal(r.Stop, &options.Stop); err != nil {
+			return nil, errors.New("stop must be a string or an array of strings")
+		}
+	}
+	if len(options.Names()) == 0 {
+		return nil, nil
+	}
+	return options, nil
+}
+
+// finishReason maps the finish reasons of the providers to those of OpenAI.
+func finishReason(reason string) string {
+	lower := strings.ToLower(reason)
+	if strings.Contains(lower, "max") || strings.Contains(lower, "length") {
+		return "length"
+	}
+	return "stop"
+}
@@ -0,0 +1,306 @@
+// Package server serves models of this library with the OpenAI chat
+// completions protocol, for tools that only speak that protocol.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/internal/sse"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ConversationHeader names the context a request belongs to. With a Store
+// the last user message and the answer are stored in it.
+const ConversationHeader = "X-Conversation-Id"
+
+// maxRequestSize limits request bodies, images make them large.
+const maxRequestSize = 32 << 20
+
+// Model is a model the server offers under Name.
+type Model struct {
+	Name   string
+	Client client.LllmChatClient
+	// OwnedBy is shown in /v1/models, e.g. the provider.
+	OwnedBy string
+}
+
+// Server answers /v1/chat/completions and /v1/models.
+type Server struct {
+	Models []Model
+	// APIKeys are the keys clients send as "Authorization: Bearer <key>".
+	// Every request is accepted when there are none.
+	APIKeys []string
+	// Store keeps the conversations of requests with a ConversationHeader,
+	// they are not stored when it is nil.
+	Store  db.Store
+	Logger *logrus.Logger
+}
+
+func New(models ...Model) *Server {
+	return &Server{
+		Models: models,
+	}
+}
+
+// Handler returns the routes of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", s.authorized(s.handleChatCompletions))
+	mux.HandleFunc("/v1/models", s.authorized(s.handleModels))
+	return mux
+}
+
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.APIKeys) == 0 {
+			handler(w, r)
+			return
+		}
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, valid := range s.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(valid)) == 1 {
+				handler(w, r)
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Invalid API key.")
+	}
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Use GET.")
+		return
+	}
+	list := modelList{Object: "list", Data: make([]modelInfo, len(s.Models))}
+	for i, model := range s.Models {
+		ownedBy := model.OwnedBy
+		if ownedBy == "" {
+			ownedBy = "llmchat"
+		}
+		list.Data[i] = modelInfo{ID: model.Name, Object: "model", OwnedBy: ownedBy}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Use POST.")
+		return
+	}
+	var request chatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	model, ok := s.model(request.Model)
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", fmt.Sprintf("The model %q does not exist.", request.Model))
+		return
+	}
+	if err := request.unsupported(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+	conversationId := r.Header.Get(ConversationHeader)
+	contextId := conversationId
+	if contextId == "" {
+		contextId = db.RandomContextId
+	}
+	messages, context, err := request.conversation(contextId)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+	options, err := request.options()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+	if conversationId != "" {
+		w.Header().Set(ConversationHeader, conversationId)
+	}
+	if s.Logger != nil {
+		s.Logger.WithFields(logrus.Fields{
+			"model":          model.Name,
+			"messages":       len(messages),
+			"stream":         request.Stream,
+			"conversationId": conversationId,
+		}).Debug("Chat completion")
+	}
+
+	completion := chatCompletion{
+		ID:      "chatcmpl-" + uuid.New().String(),
+		Created: time.Now().Unix(),
+		Model:   model.Name,
+	}
+	if request.Stream {
+		s.stream(w, model, completion, messages, context, options, conversationId)
+		return
+	}
+	answers, err := client.SendMessagesWithOptions(model.Client, messages, context, options, s.Logger)
+	if err == nil && len(answers) == 0 {
+		err = errors.New("model returned no messages")
+	}
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	answer := answers[len(answers)-1]
+	s.storeTurn(conversationId, context, messages[len(messages)-1], answer)
+	reason := finishReason(answer.Metadata.FinishReason)
+	completion.Object = "chat.completion"
+	completion.Choices = []chatChoice{{
+		Message:      &assistantOutput{Role: db.AssistentRoleNeam, Content: answer.Content},
+		FinishReason: &reason,
+	}}
+	completion.Usage = &chatUsage{
+		PromptTokens:     answer.Metadata.PromptTokens,
+		CompletionTokens: answer.Metadata.CompletionTokens,
+		TotalTokens:      answer.Metadata.TotalTokens,
+	}
+	writeJSON(w, http.StatusOK, completion)
+}
+
+// stream sends the answer as chat.completion.chunk events. Errors after the
+// first event can only be reported as an event.
+func (s *Server) stream(w http.ResponseWriter, model Model, completion chatCompletion, messages []db.Message, context []string, options *client.RequestOptions, conversationId string) {
+	completion.Object = "chat.completion.chunk"
+	writer := sse.NewWriter(w)
+	started := false
+	send := func(delta assistantOutput, reason *string) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		completion.Choices = []chatChoice{{Delta: &delta, FinishReason: reason}}
+		data, err := json.Marshal(completion)
+		if err != nil {
+			return err
+		}
+		return writer.Write(sse.Event{Data: string(data)})
+	}
+	onChunk := func(chunk string) error {
+		if !started {
+			if err := send(assistantOutput{Role: db.AssistentRoleNeam}, nil); err != nil {
+				return err
+			}
+		}
+		return send(assistantOutput{Content: chunk}, nil)
+	}
+
+	answers, err := client.StreamMessagesWithOptions(model.Client, messages, context, options, s.Logger, onChunk)
+	if err == nil && len(answers) == 0 {
+		err = errors.New("model returned no messages")
+	}
+	if err != nil {
+		if !started {
+			writeProviderError(w, err)
+			return
+		}
+		data, _ := json.Marshal(errorResponse{Error: apiError{Message: err.Error(), Type: "server_error"}})
+		writer.Write(sse.Event{Data: string(data)})
+		return
+	}
+	answer := answers[len(answers)-1]
+	s.storeTurn(conversationId, context, messages[len(messages)-1], answer)
+	if !started {
+		send(assistantOutput{Role: db.AssistentRoleNeam}, nil)
+	}
+	reason := finishReason(answer.Metadata.FinishReason)
+	send(assistantOutput{}, &reason)
+	writer.Write(sse.Event{Data: "[DONE]"})
+}
+
+// storeTurn keeps the request and the answer in the conversation. The client
+// sends the whole history with every request, only the new turn is stored.
+// The answer was already sent, failures are logged.
+func (s *Server) storeTurn(conversationId string, context []string, request db.Message, answer db.Message) {
+	if s.Store == nil || conversationId == "" {
+		return
+	}
+	err := func() error {
+		exists, err := s.Store.CheckIfContextExists(conversationId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := s.Store.CreateContext(conversationId, strings.Join(context, "\n")); err != nil {
+				// Another request of the conversation may have created it
+				// since the check.
+				if exists, checkErr := s.Store.CheckIfContextExists(conversationId); checkErr != nil || !exists {
+					return err
+				}
+			}
+		}
+		answer.ContextId = conversationId
+		return s.Store.StoreMessages(request, answer)
+	}()
+	if err != nil && s.Logger != nil {
+		s.Logger.WithFields(logrus.Fields{
+			"conversationId": conversationId,
+			"error":          err,
+		}).Warn("Unable to store conversation")
+	}
+}
+
+func (s *Server) model(name string) (Model, bool) {
+	for _, model := range s.Models {
+		if model.Name == name {
+			return model, true
+		}
+	}
+	return Model{}, false
+}
+
+// unsupported rejects what the server cannot do instead of answering
+// something else than the client asked for.
+func (r *chatCompletionRequest) unsupported() error {
+	if len(r.Tools) > 0 && string(r.Tools) != "null" && string(r.Tools) != "[]" {
+		return errors.New("tools are not supported")
+	}
+	if r.N > 1 {
+		return errors.New("n greater than 1 is not supported")
+	}
+	if r.ResponseFormat != nil && r.ResponseFormat.Type != "" && r.ResponseFormat.Type != "text" {
+		return fmt.Errorf("response_format %s is not supported", r.ResponseFormat.Type)
+	}
+	return nil
+}
+
+// writeProviderError passes on the status of provider errors the client can
+// act on, e.g. rate limits, and reports everything else as a bad gateway.
+func writeProviderError(w http.ResponseWriter, err error) {
+	var unsupported *client.UnsupportedOptionsError
+	if errors.As(err, &unsupported) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+	status := http.StatusBadGateway
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusBadRequest) {
+		status = apiErr.StatusCode
+	}
+	writeError(w, status, "server_error", "", err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, errorType string, code string, message string) {
+	writeJSON(w, status, errorResponse{Error: apiError{Message: message, Type: errorType, Code: code}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/assistant-ai/llmchat-client/client"
+	"github.com/assistant-ai/llmchat-client/db"
+	"github.com/assistant-ai/llmchat-client/internal/sse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLlm streams its answer word by word and records the last request.
+type fakeLlm struct {
+	answer   string
+	err      error
+	messages []db.Message
+	context  []string
+	options  *client.RequestOptions
+}
+
+func (f *fakeLlm) SendMessages(messages []db.Message, context []string) ([]db.Message, error) {
+	return f.SendMessagesWithOptions(messages, context, nil)
+}
+
+func (f *fakeLlm) SendMessagesWithOptions(messages []db.Message, context []string, options *client.RequestOptions) ([]db.Message, error) {
+	f.messages, f.context, f.options = messages, context, options
+	if f.err != nil {
+		return nil, f.err
+	}
+	answer := db.CreateNewMessage(db.AssistentRoleNeam, f.answer, messages[0].ContextId)
+	answer.Metadata = db.MessageMetadata{Provider: "fake", FinishReason: "MAX_TOKENS", PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}
+	return append(messages, answer), nil
+}
+
+func (f *fakeLlm) StreamMessages(messages []db.Message, context []string, onChunk func(chunk string) error) ([]db.Message, error) {
+	answers, err := f.SendMessages(messages, context)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range strings.SplitAfter(f.answer, " ") {
+		if err := onChunk(word); err != nil {
+			return nil, err
+		}
+	}
+	return answers, nil
+}
+
+func newTestServer(t *testing.T, llm *fakeLlm) (*Server, *httptest.Server) {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	s := New(Model{Name: "fake-model", Client: llm, OwnedBy: "fake"})
+	s.APIKeys = []string{"secret"}
+	s.Store = store
+	httpServer := httptest.NewServer(s.Handler())
+	t.Cleanup(httpServer.Close)
+	return s, httpServer
+}
+
+func post(t *testing.T, url string, body string, headers map[string]string) *http.Response {
+	request, err := http.NewRequest(http.MethodPost, url+"/v1/chat/completions", strings.NewReader(body))
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer secret")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func decode(t *testing.T, response *http.Response) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&body))
+	return body
+}
+
+func TestModelsAndAuthentication(t *testing.T) {
+	_, httpServer := newTestServer(t, &fakeLlm{})
+
+	response, err := http.Get(httpServer.URL + "/v1/models")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	assert.Equal(t, "invalid_api_key", decode(t, response)["error"].(map[string]interface{})["code"])
+
+	request, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/v1/models", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, map[string]interface{}{
+		"object": "list",
+		"data":   []interface{}{map[string]interface{}{"id": "fake-model", "object": "model", "created": 0.0, "owned_by": "fake"}},
+	}, decode(t, response))
+}
+
+func TestChatCompletionIsStoredInConversation(t *testing.T) {
+	llm := &fakeLlm{answer: "Hello there"}
+	s, httpServer := newTestServer(t, llm)
+
+	response := post(t, httpServer.URL, `{"model": "fake-model", "temperature": 0.2, "stop": "END", "messages": [
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": "Hi"},
+		{"role": "assistant", "content": "Hi!"},
+		{"role": "user", "content": [{"type": "text", "text": "How are you?"}]}
+	]}`, map[string]string{ConversationHeader: "conv-1"})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "conv-1", response.Header.Get(ConversationHeader))
+	body := decode(t, response)
+	assert.Equal(t, "chat.completion", body["object"])
+	assert.Equal(t, "fake-model", body["model"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"index":         0.0,
+		"message":       map[string]interface{}{"role": "assistant", "content": "Hello there"},
+		"finish_reason": "length",
+	}}, body["choices"])
+	assert.Equal(t, map[string]interface{}{"prompt_tokens": 3.0, "completion_tokens": 2.0, "total_tokens": 5.0}, body["usage"])
+
+	assert.Equal(t, []string{"Be brief."}, llm.context)
+	require.Len(t, llm.messages, 3)
+	assert.Equal(t, "How are you?", llm.messages[2].Content)
+	assert.Nil(t, llm.messages[2].Parts)
+	assert.Equal(t, 0.2, *llm.options.Temperature)
+	assert.Equal(t, []string{"END"}, llm.options.Stop)
+
+	stored, err := s.Store.GetMessagesByContextID("conv-1")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "How are you?", stored[0].Content)
+	assert.Equal(t, "Hello there", stored[1].Content)
+	context, err := s.Store.GetContextMessage("conv-1")
+	require.NoError(t, err)
+	assert.Equal(t, "Be brief.", context)
+}
+
+// racingStore creates the context right after the first check for it, like
+// a concurrent request of the same conversation.
+type racingStore struct {
+	*db.SQLStore
+	raced bool
+}
+
+func (r *racingStore) CheckIfContextExists(contextId string) (bool, error) {
+	if !r.raced {
+		r.raced = true
+		return false, r.SQLStore.CreateContext(contextId, "Other request.")
+	}
+	return r.SQLStore.CheckIfContextExists(contextId)
+}
+
+func TestConcurrentlyCreatedConversationIsStored(t *testing.T) {
+	s, _ := newTestServer(t, &fakeLlm{})
+	store := &racingStore{SQLStore: s.Store.(*db.SQLStore)}
+	s.Store = store
+
+	request := db.CreateNewMessage(db.UserRoleName, "Hi", "conv-1")
+	s.storeTurn("conv-1", []string{"Be brief."}, request, db.CreateNewMessage(db.AssistentRoleNeam, "Hello", ""))
+	stored, err := store.GetMessagesByContextID("conv-1")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "Hello", stored[1].Content)
+}
+
+func TestStreamingChatCompletion(t *testing.T) {
+	llm := &fakeLlm{answer: "One two three"}
+	_, httpServer := newTestServer(t, llm)
+
+	response := post(t, httpServer.URL, `{"model": "fake-model", "stream": true, "messages": [{"role": "user", "content": "Count"}]}`, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	reader := sse.NewReader(response.Body)
+	deltas := make([]string, 0)
+	var reason interface{}
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if event.Data == "[DONE]" {
+			break
+		}
+		var chunk map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(event.Data), &chunk))
+		assert.Equal(t, "chat.completion.chunk", chunk["object"])
+		choice := chunk["choices"].([]interface{})[0].(map[string]interface{})
+		deltas = append(deltas, choice["delta"].(map[string]interface{})["content"].(string))
+		reason = choice["finish_reason"]
+	}
+	assert.Equal(t, []string{"", "One ", "two ", "three", ""}, deltas)
+	assert.Equal(t, "length", reason)
+}
+
+func TestChatCompletionErrors(t *testing.T) {
+	llm := &fakeLlm{err: client.NewAPIError("Fake", http.StatusTooManyRequests, "slow down")}
+	_, httpServer := newTestServer(t, llm)
+
+	response := post(t, httpServer.URL, `{"model": "other", "messages": [{"role": "user", "content": "Hi"}]}`, nil)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Equal(t, "model_not_found", decode(t, response)["error"].(map[string]interface{})["code"])
+
+	response = post(t, httpServer.URL, `{"model": "fake-model", "tools": [{"type": "function"}], "messages": [{"role": "user", "content": "Hi"}]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	response = post(t, httpServer.URL, `{"model": "fake-model", "messages": [{"role": "assistant", "content": "Hi"}]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Equal(t, "the last message must be a user message", decode(t, response)["error"].(map[string]interface{})["message"])
+
+	response = post(t, httpServer.URL, `{"model": "fake-model", "messages": [{"role": "user", "content": "Hi"}]}`, nil)
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	assert.Equal(t, "error response from Fake (429): slow down", decode(t, response)["error"].(map[string]interface{})["message"])
+}